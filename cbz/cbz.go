@@ -2,128 +2,372 @@ package cbz
 
 import (
 	"archive/zip"
+	"context"
 	"fmt"
+	"image"
 	"io"
-	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
+
+	"cbz2epub/util"
 )
 
 // File represents a CBZ file with its contents
 type File struct {
-	Name   string
-	Images []Image
+	Name      string
+	Images    []Image
+	ComicInfo *ComicInfo
+	Cover     *Image // Cover image that is not a page, such as Calibre's cover.jpg
 }
 
 // Image represents an image inside a CBZ file
 type Image struct {
 	Name     string
+	Path     string
 	Data     []byte
 	MimeType string
+	Bookmark string
+	Panels   []image.Rectangle // Panels in reading order, in image pixels
 }
 
 // ReadFile reads a CBZ file and returns its contents
 func ReadFile(filename string) (*File, error) {
+	return ReadFileContext(context.Background(), filename, nil)
+}
+
+// ReadFileContext reads a CBZ file, reporting each page read to progress.
+// Reading stops between pages when the context is done.
+func ReadFileContext(ctx context.Context, filename string, progress ProgressFunc) (*File, error) {
 	zipReader, err := zip.OpenReader(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open CBZ file: %w", err)
 	}
 	defer zipReader.Close()
 
+	return readZip(ctx, &zipReader.Reader, filename, progress)
+}
+
+// Read reads a CBZ file of the given size from r, such as an uploaded file
+// or a file in memory. The file has no name.
+func Read(r io.ReaderAt, size int64) (*File, error) {
+	return ReadContext(context.Background(), r, size, nil)
+}
+
+// ReadContext reads a CBZ file from r like Read, reporting each page read
+// to progress. Reading stops between pages when the context is done.
+func ReadContext(ctx context.Context, r io.ReaderAt, size int64, progress ProgressFunc) (*File, error) {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CBZ file: %w", err)
+	}
+	return readZip(ctx, zipReader, "", progress)
+}
+
+// readZip reads the images and ComicInfo.xml of an opened CBZ file
+func readZip(ctx context.Context, zipReader *zip.Reader, filename string, progress ProgressFunc) (*File, error) {
 	cbzFile := &File{
 		Name:   filename,
 		Images: []Image{},
 	}
 
+	// Count the pages first so progress can report the total
+	totalPages := 0
+	for _, file := range zipReader.File {
+		if !file.FileInfo().IsDir() && isImageFile(file.Name) {
+			totalPages++
+		}
+	}
+
 	// Read all image files from the zip
+	var comicInfoData []byte
 	for _, file := range zipReader.File {
-		// Skip directories and non-image files
-		if file.FileInfo().IsDir() || !isImageFile(file.Name) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Keep ComicInfo.xml for page bookmarks
+		if isComicInfoFile(file.Name) {
+			var err error
+			comicInfoData, err = readZipFile(file)
+			if err != nil {
+				return nil, err
+			}
 			continue
 		}
 
-		// Open the file inside the zip
-		rc, err := file.Open()
-		if err != nil {
-			return nil, fmt.Errorf("failed to open file in CBZ: %w", err)
+		// Skip directories and non-image files
+		if file.FileInfo().IsDir() || !isImageFile(file.Name) {
+			continue
 		}
 
 		// Read the file data
-		data, err := io.ReadAll(rc)
-		rc.Close()
+		data, err := readZipFile(file)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read file data: %w", err)
+			return nil, err
 		}
 
 		// Add the image to the CBZ file
 		cbzFile.Images = append(cbzFile.Images, Image{
 			Name:     filepath.Base(file.Name),
+			Path:     file.Name,
 			Data:     data,
-			MimeType: getMimeType(file.Name),
+			MimeType: MimeType(file.Name),
 		})
+		progress.Report(Progress{Stage: StageRead, Pages: len(cbzFile.Images), TotalPages: totalPages})
 	}
 
-	// Sort images by name
+	// Sort images by folder, then by name, so the pages of a chapter folder
+	// stay together even when other folders reuse their names
 	sort.Slice(cbzFile.Images, func(i, j int) bool {
-		return cbzFile.Images[i].Name < cbzFile.Images[j].Name
+		a, b := cbzFile.Images[i], cbzFile.Images[j]
+		if dirA, dirB := path.Dir(a.Path), path.Dir(b.Path); dirA != dirB {
+			return dirA < dirB
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Path < b.Path
 	})
 
+	// Apply ComicInfo.xml if present. A broken ComicInfo.xml is not fatal,
+	// the images are still usable without it.
+	if comicInfoData != nil {
+		if comicInfo, err := ParseComicInfo(comicInfoData); err == nil {
+			cbzFile.ComicInfo = comicInfo
+			comicInfo.applyBookmarks(cbzFile.Images)
+		}
+	}
+
 	return cbzFile, nil
 }
 
-// MergeFiles merges multiple CBZ files into one
-func MergeFiles(inputFiles []string, outputFile string) error {
-	// Create a new zip file
-	zipFile, err := os.Create(outputFile)
+// WriteFile writes the images of a CBZ file and its ComicInfo.xml to a new
+// zip archive
+func WriteFile(cbzFile *File, outputFile string) error {
+	return WriteFileContext(context.Background(), cbzFile, outputFile, nil)
+}
+
+// WriteFileContext writes a CBZ file like WriteFile, reporting each page
+// written to progress. Writing stops between pages when the context is
+// done, and the output file is left untouched.
+func WriteFileContext(ctx context.Context, cbzFile *File, outputFile string, progress ProgressFunc) error {
+	// Write to a temporary file that replaces the output file when complete
+	zipFile, err := util.CreateAtomic(outputFile)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
-	defer zipFile.Close()
+	defer zipFile.Abort()
+
+	if err := WriteContext(ctx, zipFile, cbzFile, progress); err != nil {
+		return err
+	}
+	return zipFile.Commit()
+}
+
+// Write writes the images of a CBZ file and its ComicInfo.xml as a zip
+// archive to w
+func Write(w io.Writer, cbzFile *File) error {
+	return WriteContext(context.Background(), w, cbzFile, nil)
+}
+
+// WriteContext writes a CBZ file to w like Write, reporting each page
+// written to progress. Writing stops between pages when the context is done.
+func WriteContext(ctx context.Context, w io.Writer, cbzFile *File, progress ProgressFunc) error {
+	counter := &util.CountingWriter{W: w}
+	zipWriter := zip.NewWriter(counter)
+
+	for i, image := range cbzFile.Images {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
+		if err := writeZipFile(zipWriter, zipName(image), image.Data); err != nil {
+			return err
+		}
+		progress.Report(Progress{Stage: StageWrite, Pages: i + 1, TotalPages: len(cbzFile.Images), Bytes: counter.N})
+	}
 
-	// Process each input file
-	imageCounter := 1
-	for chapterIndex, inputFile := range inputFiles {
-		cbzFile, err := ReadFile(inputFile)
+	if cbzFile.ComicInfo != nil {
+		data, err := cbzFile.ComicInfo.Marshal()
 		if err != nil {
-			return fmt.Errorf("failed to read input file %s: %w", inputFile, err)
+			return err
 		}
 
-		// Add each image to the output zip with a new name to avoid conflicts
-		for _, image := range cbzFile.Images {
-			// Create a new name for the image: chapterXXX_imageYYY.ext
-			ext := filepath.Ext(image.Name)
-			newName := fmt.Sprintf("chapter%03d_%03d%s", chapterIndex+1, imageCounter, ext)
-			imageCounter++
+		writer, err := zipWriter.Create("ComicInfo.xml")
+		if err != nil {
+			return fmt.Errorf("failed to create ComicInfo.xml: %w", err)
+		}
 
-			// Create a new file in the zip
-			writer, err := zipWriter.Create(newName)
-			if err != nil {
-				return fmt.Errorf("failed to create file in output zip: %w", err)
-			}
+		_, err = writer.Write(data)
+		if err != nil {
+			return fmt.Errorf("failed to write ComicInfo.xml: %w", err)
+		}
+	}
 
-			// Write the image data
-			_, err = writer.Write(image.Data)
-			if err != nil {
-				return fmt.Errorf("failed to write image data: %w", err)
-			}
+	if err := zipWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish output zip: %w", err)
+	}
+	progress.Report(Progress{Stage: StageWrite, Pages: len(cbzFile.Images), TotalPages: len(cbzFile.Images), Bytes: counter.N})
+	return nil
+}
+
+// readZipFile reads the whole content of a file inside a zip archive
+func readZipFile(file *zip.File) ([]byte, error) {
+	// Open the file inside the zip
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file in CBZ: %w", err)
+	}
+	defer rc.Close()
+
+	// Read the file data
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file data: %w", err)
+	}
+
+	return data, nil
+}
+
+// zipName returns the name of an image in a written CBZ file. The original
+// path is kept so folder-based chapters survive.
+func zipName(image Image) string {
+	if image.Path == "" {
+		return image.Name
+	}
+	return image.Path
+}
+
+// writeZipFile adds an image to a zip archive
+func writeZipFile(zipWriter *zip.Writer, name string, data []byte) error {
+	writer, err := zipWriter.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create file in output zip: %w", err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("failed to write image data: %w", err)
+	}
+	return nil
+}
+
+// MergeFiles merges multiple CBZ files into one
+func MergeFiles(inputFiles []string, outputFile string) error {
+	return MergeFilesContext(context.Background(), inputFiles, outputFile, nil)
+}
+
+// MergeFilesContext merges multiple CBZ files like MergeFiles, reporting the
+// pages read from each input file and the pages written to progress.
+// Merging stops between pages when the context is done. Only one input file
+// is held in memory at a time.
+func MergeFilesContext(ctx context.Context, inputFiles []string, outputFile string, progress ProgressFunc) error {
+	merger, err := NewMerger(outputFile, progress)
+	if err != nil {
+		return err
+	}
+	defer merger.Abort()
+
+	for _, inputFile := range inputFiles {
+		cbzFile, err := ReadFileContext(ctx, inputFile, progress)
+		if err != nil {
+			return fmt.Errorf("failed to read input file %s: %w", inputFile, err)
 		}
+		if err := merger.Add(ctx, cbzFile); err != nil {
+			return err
+		}
+	}
+	return merger.Commit(nil)
+}
+
+// Merger writes a merged CBZ file one input file at a time, renaming the
+// images to chapterXXX_YYY.ext to avoid conflicts, where XXX numbers the
+// files and YYY the pages across all files. The caller only needs to hold
+// the file being added in memory.
+type Merger struct {
+	zipFile   *util.AtomicFile
+	counter   *util.CountingWriter
+	zipWriter *zip.Writer
+	progress  ProgressFunc
+	chapters  int
+	pages     int
+}
+
+// NewMerger creates a merged CBZ file that replaces the output file when
+// it is committed, reporting the pages written to progress
+func NewMerger(outputFile string, progress ProgressFunc) (*Merger, error) {
+	zipFile, err := util.CreateAtomic(outputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
 	}
+	counter := &util.CountingWriter{W: zipFile}
+	return &Merger{zipFile: zipFile, counter: counter, zipWriter: zip.NewWriter(counter), progress: progress}, nil
+}
 
+// Add appends the images of a file as the next chapter. It stops between
+// pages when the context is done.
+func (m *Merger) Add(ctx context.Context, cbzFile *File) error {
+	m.chapters++
+	for _, image := range cbzFile.Images {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		m.pages++
+		image = mergedImage(image, m.chapters, m.pages)
+		if err := writeZipFile(m.zipWriter, image.Path, image.Data); err != nil {
+			return err
+		}
+		m.progress.Report(Progress{Stage: StageWrite, Pages: m.pages, Bytes: m.counter.N})
+	}
 	return nil
 }
 
+// Pages returns the number of pages added so far
+func (m *Merger) Pages() int {
+	return m.pages
+}
+
+// Commit writes the ComicInfo.xml of the merged file if not nil, and
+// replaces the output file
+func (m *Merger) Commit(comicInfo *ComicInfo) error {
+	if comicInfo != nil {
+		data, err := comicInfo.Marshal()
+		if err != nil {
+			return err
+		}
+		if err := writeZipFile(m.zipWriter, "ComicInfo.xml", data); err != nil {
+			return fmt.Errorf("failed to write ComicInfo.xml: %w", err)
+		}
+	}
+	if err := m.zipWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish output zip: %w", err)
+	}
+	m.progress.Report(Progress{Stage: StageWrite, Pages: m.pages, TotalPages: m.pages, Bytes: m.counter.N})
+	return m.zipFile.Commit()
+}
+
+// Abort removes the merged file unless it is committed
+func (m *Merger) Abort() {
+	m.zipFile.Abort()
+}
+
+// mergedImage renames an image of a merged file to chapterXXX_YYY.ext. The
+// bookmark is dropped, the chapter prefix marks the chapter instead.
+func mergedImage(image Image, chapter, page int) Image {
+	image.Name = fmt.Sprintf("chapter%03d_%03d%s", chapter, page, filepath.Ext(image.Name))
+	image.Path = image.Name
+	image.Bookmark = ""
+	return image
+}
+
 // isImageFile checks if a file is an image based on its extension
 func isImageFile(filename string) bool {
 	ext := strings.ToLower(filepath.Ext(filename))
 	return ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".gif" || ext == ".webp"
 }
 
-// getMimeType returns the MIME type for a file based on its extension
-func getMimeType(filename string) string {
+// MimeType returns the MIME type of an image file based on its extension
+func MimeType(filename string) string {
 	ext := strings.ToLower(filepath.Ext(filename))
 	switch ext {
 	case ".jpg", ".jpeg":
@@ -138,3 +382,20 @@ func getMimeType(filename string) string {
 		return "application/octet-stream"
 	}
 }
+
+// Extension returns the file extension of an image MIME type, or an empty
+// string for other types
+func Extension(mimeType string) string {
+	switch mimeType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ""
+	}
+}
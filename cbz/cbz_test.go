@@ -2,8 +2,10 @@ package cbz
 
 import (
 	"archive/zip"
+	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -34,8 +36,8 @@ func TestIsImageFile(t *testing.T) {
 	}
 }
 
-// TestGetMimeType tests the getMimeType function
-func TestGetMimeType(t *testing.T) {
+// TestMimeType tests the MimeType function
+func TestMimeType(t *testing.T) {
 	tests := []struct {
 		filename string
 		expected string
@@ -54,9 +56,32 @@ func TestGetMimeType(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		result := getMimeType(test.filename)
+		result := MimeType(test.filename)
 		if result != test.expected {
-			t.Errorf("getMimeType(%s) = %v, expected %v", test.filename, result, test.expected)
+			t.Errorf("MimeType(%s) = %v, expected %v", test.filename, result, test.expected)
+		}
+	}
+}
+
+// TestExtension tests the Extension function
+func TestExtension(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		expected string
+	}{
+		{"image/jpeg", ".jpg"},
+		{"image/png", ".png"},
+		{"image/gif", ".gif"},
+		{"image/webp", ".webp"},
+		{"image/svg+xml", ""},
+		{"application/octet-stream", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		result := Extension(test.mimeType)
+		if result != test.expected {
+			t.Errorf("Extension(%s) = %v, expected %v", test.mimeType, result, test.expected)
 		}
 	}
 }
@@ -102,6 +127,7 @@ func TestReadFile(t *testing.T) {
 		{"image1.jpg", "test image 1 content"},
 		{"image2.png", "test image 2 content"},
 		{"subfolder/image3.gif", "test image 3 content"},
+		{"z_extras/image0.jpg", "test image 0 content"},
 		{"not_an_image.txt", "this is not an image"},
 	}
 	createTestCBZ(t, testCBZ, testImages)
@@ -117,14 +143,16 @@ func TestReadFile(t *testing.T) {
 		t.Errorf("Expected file name %s, got %s", testCBZ, cbzFile.Name)
 	}
 
-	// Check that only image files were read (3 images, not the text file)
-	if len(cbzFile.Images) != 3 {
-		t.Errorf("Expected 3 images, got %d", len(cbzFile.Images))
+	// Check that only image files were read (4 images, not the text file)
+	if len(cbzFile.Images) != 4 {
+		t.Errorf("Expected 4 images, got %d", len(cbzFile.Images))
 	}
 
-	// Check that the images are sorted by name
-	if len(cbzFile.Images) >= 2 && cbzFile.Images[0].Name > cbzFile.Images[1].Name {
-		t.Errorf("Images are not sorted by name")
+	// Check that the images are sorted by folder, then by name
+	for i, expected := range []string{"image1.jpg", "image2.png", "subfolder/image3.gif", "z_extras/image0.jpg"} {
+		if i < len(cbzFile.Images) && cbzFile.Images[i].Path != expected {
+			t.Errorf("Expected %s at position %d, got %s", expected, i, cbzFile.Images[i].Path)
+		}
 	}
 
 	// Check that the image data is correct
@@ -211,3 +239,49 @@ func TestMergeFiles(t *testing.T) {
 		}
 	}
 }
+
+// TestMerger tests merging files that are already read
+func TestMerger(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "cbz_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	mergedCBZ := filepath.Join(tempDir, "merged.cbz")
+	merger, err := NewMerger(mergedCBZ, nil)
+	if err != nil {
+		t.Fatalf("NewMerger failed: %v", err)
+	}
+	defer merger.Abort()
+	for _, pages := range [][]string{{"001.jpg", "002.jpg"}, {"001.png"}} {
+		file := &File{}
+		for _, name := range pages {
+			file.Images = append(file.Images, Image{Name: name, Path: "chapter/" + name, Data: []byte(name), Bookmark: "Chapter"})
+		}
+		if err := merger.Add(context.Background(), file); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	if merger.Pages() != 3 {
+		t.Errorf("Expected 3 pages, got %d", merger.Pages())
+	}
+	if err := merger.Commit(&ComicInfo{Title: "Omnibus", PageCount: merger.Pages()}); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	merged, err := ReadFile(mergedCBZ)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	var names []string
+	for _, image := range merged.Images {
+		names = append(names, image.Path)
+	}
+	if strings.Join(names, " ") != "chapter001_001.jpg chapter001_002.jpg chapter002_003.png" {
+		t.Errorf("Unexpected merged pages %v", names)
+	}
+	if merged.ComicInfo == nil || merged.ComicInfo.Title != "Omnibus" || merged.ComicInfo.PageCount != 3 {
+		t.Errorf("Expected the merged metadata, got %+v", merged.ComicInfo)
+	}
+}
@@ -0,0 +1,165 @@
+package cbz
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// ComicInfo represents the ComicInfo.xml metadata file used by comic readers
+type ComicInfo struct {
+	XMLName         xml.Name        `xml:"ComicInfo"`
+	Title           string          `xml:"Title,omitempty"`
+	Series          string          `xml:"Series,omitempty"`
+	Number          string          `xml:"Number,omitempty"`
+	Count           int             `xml:"Count,omitempty"`
+	Volume          int             `xml:"Volume,omitempty"`
+	Summary         string          `xml:"Summary,omitempty"`
+	Notes           string          `xml:"Notes,omitempty"`
+	Year            int             `xml:"Year,omitempty"`
+	Month           int             `xml:"Month,omitempty"`
+	Day             int             `xml:"Day,omitempty"`
+	Writer          string          `xml:"Writer,omitempty"`
+	Penciller       string          `xml:"Penciller,omitempty"`
+	Publisher       string          `xml:"Publisher,omitempty"`
+	Genre           string          `xml:"Genre,omitempty"`
+	Tags            string          `xml:"Tags,omitempty"`
+	Web             string          `xml:"Web,omitempty"`
+	Format          string          `xml:"Format,omitempty"`
+	ScanInformation string          `xml:"ScanInformation,omitempty"`
+	PageCount       int             `xml:"PageCount,omitempty"`
+	LanguageISO     string          `xml:"LanguageISO,omitempty"`
+	GTIN            string          `xml:"GTIN,omitempty"`
+	Manga           string          `xml:"Manga,omitempty"`
+	Pages           []ComicInfoPage `xml:"Pages>Page,omitempty"`
+}
+
+// ComicInfoPage represents a single page entry in ComicInfo.xml
+type ComicInfoPage struct {
+	Image       int    `xml:"Image,attr"`
+	Type        string `xml:"Type,attr,omitempty"`
+	DoublePage  bool   `xml:"DoublePage,attr,omitempty"`
+	ImageSize   int64  `xml:"ImageSize,attr,omitempty"`
+	Bookmark    string `xml:"Bookmark,attr,omitempty"`
+	ImageWidth  int    `xml:"ImageWidth,attr,omitempty"`
+	ImageHeight int    `xml:"ImageHeight,attr,omitempty"`
+}
+
+// ParseComicInfo parses the content of a ComicInfo.xml file
+func ParseComicInfo(data []byte) (*ComicInfo, error) {
+	comicInfo := &ComicInfo{}
+	if err := xml.Unmarshal(data, comicInfo); err != nil {
+		return nil, fmt.Errorf("failed to parse ComicInfo.xml: %w", err)
+	}
+	return comicInfo, nil
+}
+
+// ReadComicInfo reads only the ComicInfo.xml of a CBZ file. It returns nil
+// if the file has no ComicInfo.xml.
+func ReadComicInfo(filename string) (*ComicInfo, error) {
+	zipReader, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CBZ file: %w", err)
+	}
+	defer zipReader.Close()
+
+	for _, file := range zipReader.File {
+		if !isComicInfoFile(file.Name) {
+			continue
+		}
+		data, err := readZipFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return ParseComicInfo(data)
+	}
+	return nil, nil
+}
+
+// Marshal returns the content of the ComicInfo.xml file
+func (c *ComicInfo) Marshal() ([]byte, error) {
+	data, err := xml.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write ComicInfo.xml: %w", err)
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// FillMissing copies the fields of other that are not set in c. Pages are
+// not copied.
+func (c *ComicInfo) FillMissing(other *ComicInfo) {
+	if other == nil {
+		return
+	}
+	dst := reflect.ValueOf(c).Elem()
+	src := reflect.ValueOf(other).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		name := dst.Type().Field(i).Name
+		if name == "XMLName" || name == "Pages" || !dst.Field(i).IsZero() {
+			continue
+		}
+		dst.Field(i).Set(src.Field(i))
+	}
+}
+
+// Override copies the fields that are set in other to c. Pages are not
+// copied.
+func (c *ComicInfo) Override(other *ComicInfo) {
+	if other == nil {
+		return
+	}
+	dst := reflect.ValueOf(c).Elem()
+	src := reflect.ValueOf(other).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		name := dst.Type().Field(i).Name
+		if name == "XMLName" || name == "Pages" || src.Field(i).IsZero() {
+			continue
+		}
+		dst.Field(i).Set(src.Field(i))
+	}
+}
+
+// Title returns the title of the CBZ file from its ComicInfo.xml, falling
+// back to the series with volume and number, then to the file name without
+// extension
+func (f *File) Title() string {
+	if f.ComicInfo != nil && f.ComicInfo.Title != "" {
+		return f.ComicInfo.Title
+	}
+	if f.ComicInfo != nil && f.ComicInfo.Series != "" {
+		title := f.ComicInfo.Series
+		if f.ComicInfo.Volume > 0 {
+			title += fmt.Sprintf(" Vol. %d", f.ComicInfo.Volume)
+		}
+		if f.ComicInfo.Number != "" {
+			title += " #" + f.ComicInfo.Number
+		}
+		return title
+	}
+	name := filepath.Base(f.Name)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// RightToLeft checks if the pages of a CBZ file are read from right to left
+func (f *File) RightToLeft() bool {
+	return f.ComicInfo != nil && f.ComicInfo.Manga == "YesAndRightToLeft"
+}
+
+// applyBookmarks copies the page bookmarks to the matching images
+func (c *ComicInfo) applyBookmarks(images []Image) {
+	for _, page := range c.Pages {
+		if page.Bookmark == "" || page.Image < 0 || page.Image >= len(images) {
+			continue
+		}
+		images[page.Image].Bookmark = page.Bookmark
+	}
+}
+
+// isComicInfoFile checks if a file is the ComicInfo.xml metadata file
+func isComicInfoFile(filename string) bool {
+	return strings.EqualFold(path.Base(filename), "ComicInfo.xml")
+}
@@ -0,0 +1,102 @@
+package cbz
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestParseComicInfo tests the ParseComicInfo function
+func TestParseComicInfo(t *testing.T) {
+	data := []byte(`<?xml version="1.0"?>
+<ComicInfo>
+  <Title>The Title</Title>
+  <Series>The Series</Series>
+  <Volume>3</Volume>
+  <Pages>
+    <Page Image="0" Type="FrontCover"/>
+    <Page Image="2" Bookmark="Chapter 2"/>
+  </Pages>
+</ComicInfo>`)
+
+	comicInfo, err := ParseComicInfo(data)
+	if err != nil {
+		t.Fatalf("ParseComicInfo failed: %v", err)
+	}
+
+	if comicInfo.Title != "The Title" || comicInfo.Series != "The Series" || comicInfo.Volume != 3 {
+		t.Errorf("Unexpected ComicInfo fields: %+v", comicInfo)
+	}
+
+	if len(comicInfo.Pages) != 2 || comicInfo.Pages[1].Bookmark != "Chapter 2" {
+		t.Errorf("Unexpected ComicInfo pages: %+v", comicInfo.Pages)
+	}
+
+	// Test with invalid XML
+	if _, err := ParseComicInfo([]byte("<ComicInfo>")); err == nil {
+		t.Errorf("ParseComicInfo should fail with invalid XML")
+	}
+}
+
+// TestReadFileComicInfo tests that ReadFile applies ComicInfo bookmarks
+func TestReadFileComicInfo(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "cbz_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	testCBZ := filepath.Join(tempDir, "test.cbz")
+	createTestCBZ(t, testCBZ, []struct{ name, content string }{
+		{"001.jpg", "page 1"},
+		{"002.jpg", "page 2"},
+		{"003.jpg", "page 3"},
+		{"ComicInfo.xml", `<ComicInfo><Pages><Page Image="1" Bookmark="Chapter 2"/></Pages></ComicInfo>`},
+	})
+
+	cbzFile, err := ReadFile(testCBZ)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+
+	if cbzFile.ComicInfo == nil {
+		t.Fatalf("ComicInfo was not read")
+	}
+
+	if cbzFile.Images[1].Bookmark != "Chapter 2" {
+		t.Errorf("Expected bookmark on second image, got %q", cbzFile.Images[1].Bookmark)
+	}
+}
+
+// TestReadComicInfo tests reading only the ComicInfo.xml of a CBZ file
+func TestReadComicInfo(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "cbz_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	withInfo := filepath.Join(tempDir, "with.cbz")
+	createTestCBZ(t, withInfo, []struct{ name, content string }{
+		{"001.jpg", "page 1"},
+		{"ComicInfo.xml", `<ComicInfo><Series>Saga</Series><Volume>2</Volume></ComicInfo>`},
+	})
+	comicInfo, err := ReadComicInfo(withInfo)
+	if err != nil {
+		t.Fatalf("ReadComicInfo failed: %v", err)
+	}
+	if comicInfo == nil || comicInfo.Series != "Saga" || comicInfo.Volume != 2 {
+		t.Errorf("Unexpected ComicInfo: %+v", comicInfo)
+	}
+
+	withoutInfo := filepath.Join(tempDir, "without.cbz")
+	createTestCBZ(t, withoutInfo, []struct{ name, content string }{{"001.jpg", "page 1"}})
+	comicInfo, err = ReadComicInfo(withoutInfo)
+	if err != nil || comicInfo != nil {
+		t.Errorf("Expected no ComicInfo and no error, got %+v, %v", comicInfo, err)
+	}
+
+	if _, err := ReadComicInfo(filepath.Join(tempDir, "missing.cbz")); err == nil {
+		t.Errorf("Expected error for a missing file, got nil")
+	}
+}
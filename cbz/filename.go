@@ -0,0 +1,144 @@
+package cbz
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Patterns for the parts of common file names such as
+// "Series Name v03 c021 (2019) (Digital) [Group].cbz"
+var (
+	groupPattern   = regexp.MustCompile(`\[([^\]]*)\]`)
+	parenPattern   = regexp.MustCompile(`\(([^)]*)\)`)
+	yearPattern    = regexp.MustCompile(`^(19|20)\d\d$`)
+	volumePattern  = regexp.MustCompile(`(?i)(?:^|[\s_.-])(?:v|vol|volume)\.?\s*(\d+)\b`)
+	numberPattern  = regexp.MustCompile(`(?i)(?:^|[\s_.-])(?:c|ch|chap|chapter|#)\.?\s*(\d+(?:\.\d+)?)\b`)
+	trailingNumber = regexp.MustCompile(`^(.*\S)\s+#?(\d+(?:\.\d+)?)$`)
+)
+
+// ParseFileName extracts the series, volume, chapter number, year, edition
+// and scan group from the name of a file. The named groups of the patterns
+// (series, volume, number or chapter, year, edition, group and title) are
+// tried first, in order. It returns nil if nothing is found.
+func ParseFileName(filename string, patterns []*regexp.Regexp) *ComicInfo {
+	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+
+	for _, pattern := range patterns {
+		if comicInfo := matchFileName(name, pattern); comicInfo != nil {
+			return comicInfo
+		}
+	}
+	return parseFileName(name)
+}
+
+// matchFileName fills the metadata from the named groups of a pattern
+func matchFileName(name string, pattern *regexp.Regexp) *ComicInfo {
+	match := pattern.FindStringSubmatch(name)
+	if match == nil {
+		return nil
+	}
+
+	comicInfo := &ComicInfo{}
+	for i, group := range pattern.SubexpNames() {
+		value := cleanName(match[i])
+		if i == 0 || value == "" {
+			continue
+		}
+		switch group {
+		case "series":
+			comicInfo.Series = value
+		case "title":
+			comicInfo.Title = value
+		case "volume":
+			comicInfo.Volume, _ = strconv.Atoi(value)
+		case "number", "chapter":
+			comicInfo.Number = trimNumber(value)
+		case "year":
+			comicInfo.Year, _ = strconv.Atoi(value)
+		case "edition":
+			comicInfo.Format = value
+		case "group":
+			comicInfo.ScanInformation = value
+		}
+	}
+	return comicInfo
+}
+
+// parseFileName parses the usual naming scheme of comic and manga releases.
+// Plain names without volume, chapter or year are not parsed.
+func parseFileName(name string) *ComicInfo {
+	comicInfo := &ComicInfo{}
+
+	// The last bracketed part names the scan group
+	if groups := groupPattern.FindAllStringSubmatch(name, -1); len(groups) > 0 {
+		comicInfo.ScanInformation = cleanName(groups[len(groups)-1][1])
+	}
+	rest := groupPattern.ReplaceAllString(name, " ")
+
+	// Parenthesized parts are the year or the edition
+	for _, paren := range parenPattern.FindAllStringSubmatch(rest, -1) {
+		value := cleanName(paren[1])
+		if yearPattern.MatchString(value) && comicInfo.Year == 0 {
+			comicInfo.Year, _ = strconv.Atoi(value)
+		} else if value != "" && comicInfo.Format == "" {
+			comicInfo.Format = value
+		}
+	}
+	rest = parenPattern.ReplaceAllString(rest, " ")
+	rest = strings.ReplaceAll(rest, "_", " ")
+
+	// The series ends where the volume or chapter starts
+	seriesEnd := len(rest)
+	if match := volumePattern.FindStringSubmatchIndex(rest); match != nil {
+		comicInfo.Volume, _ = strconv.Atoi(rest[match[2]:match[3]])
+		seriesEnd = min(seriesEnd, match[0])
+	}
+	if match := numberPattern.FindStringSubmatchIndex(rest); match != nil {
+		comicInfo.Number = trimNumber(rest[match[2]:match[3]])
+		seriesEnd = min(seriesEnd, match[0])
+	}
+	series := rest[:seriesEnd]
+
+	// A number at the end of the series is the chapter, as in "Series 021"
+	if comicInfo.Number == "" {
+		if match := trailingNumber.FindStringSubmatch(strings.TrimSpace(series)); match != nil {
+			series = match[1]
+			comicInfo.Number = trimNumber(match[2])
+		}
+	}
+
+	if comicInfo.Volume == 0 && comicInfo.Number == "" && comicInfo.Year == 0 {
+		return nil
+	}
+	comicInfo.Series = cleanName(series)
+	return comicInfo
+}
+
+// cleanName collapses whitespace and trims separators around a name
+func cleanName(s string) string {
+	return strings.Trim(strings.Join(strings.Fields(s), " "), " -_.")
+}
+
+// trimNumber removes the leading zeros of a chapter number
+func trimNumber(s string) string {
+	trimmed := strings.TrimLeft(s, "0")
+	if trimmed == "" || trimmed[0] == '.' {
+		trimmed = "0" + trimmed
+	}
+	return trimmed
+}
+
+// ApplyFileName fills the metadata missing from ComicInfo.xml with the
+// metadata parsed from the file name
+func (f *File) ApplyFileName(patterns []*regexp.Regexp) {
+	parsed := ParseFileName(f.Name, patterns)
+	if parsed == nil {
+		return
+	}
+	if f.ComicInfo == nil {
+		f.ComicInfo = &ComicInfo{}
+	}
+	f.ComicInfo.FillMissing(parsed)
+}
@@ -0,0 +1,95 @@
+package cbz
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// TestParseFileName tests extracting metadata from file names
+func TestParseFileName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		filename string
+		patterns []*regexp.Regexp
+		expected *ComicInfo
+	}{
+		{
+			name:     "full release name",
+			filename: "/comics/Series Name v03 c021 (2019) (Digital) [Group].cbz",
+			expected: &ComicInfo{Series: "Series Name", Volume: 3, Number: "21", Year: 2019, Format: "Digital", ScanInformation: "Group"},
+		},
+		{
+			name:     "underscores and long words",
+			filename: "Some_Manga_Vol.2_Chapter_10.5.cbz",
+			expected: &ComicInfo{Series: "Some Manga", Volume: 2, Number: "10.5"},
+		},
+		{
+			name:     "issue number",
+			filename: "Saga #054 (2018).cbz",
+			expected: &ComicInfo{Series: "Saga", Number: "54", Year: 2018},
+		},
+		{
+			name:     "trailing number",
+			filename: "[Group] Series Name - 007.cbz",
+			expected: &ComicInfo{Series: "Series Name", Number: "7", ScanInformation: "Group"},
+		},
+		{
+			name:     "volume only",
+			filename: "vol1.cbz",
+			expected: &ComicInfo{Volume: 1},
+		},
+		{
+			name:     "plain name",
+			filename: "My Comic.cbz",
+			expected: nil,
+		},
+		{
+			name:     "user pattern",
+			filename: "2019 - Series Name - Book 4.cbz",
+			patterns: []*regexp.Regexp{regexp.MustCompile(`^(?P<year>\d{4}) - (?P<series>.+) - Book (?P<volume>\d+)$`)},
+			expected: &ComicInfo{Series: "Series Name", Volume: 4, Year: 2019},
+		},
+		{
+			name:     "user pattern without match",
+			filename: "Series Name v03.cbz",
+			patterns: []*regexp.Regexp{regexp.MustCompile(`^Book (?P<volume>\d+)$`)},
+			expected: &ComicInfo{Series: "Series Name", Volume: 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ParseFileName(tc.filename, tc.patterns)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
+
+// TestApplyFileName tests that the file name only fills missing metadata
+func TestApplyFileName(t *testing.T) {
+	cbzFile := &File{
+		Name:      "Series Name v03 c021 (2019).cbz",
+		ComicInfo: &ComicInfo{Series: "Tagged Series", Pages: []ComicInfoPage{{Image: 0, Bookmark: "Start"}}},
+	}
+	cbzFile.ApplyFileName(nil)
+
+	if cbzFile.ComicInfo.Series != "Tagged Series" || cbzFile.ComicInfo.Volume != 3 || cbzFile.ComicInfo.Number != "21" || cbzFile.ComicInfo.Year != 2019 {
+		t.Errorf("Unexpected metadata: %+v", cbzFile.ComicInfo)
+	}
+	if len(cbzFile.ComicInfo.Pages) != 1 {
+		t.Errorf("Expected pages to be kept, got %+v", cbzFile.ComicInfo.Pages)
+	}
+	if title := cbzFile.Title(); title != "Tagged Series Vol. 3 #21" {
+		t.Errorf("Expected title from the series, got %q", title)
+	}
+
+	// Untagged files get new metadata
+	untagged := &File{Name: "Saga #054.cbz"}
+	untagged.ApplyFileName(nil)
+	if untagged.ComicInfo == nil || untagged.ComicInfo.Series != "Saga" || untagged.Title() != "Saga #54" {
+		t.Errorf("Unexpected metadata: %+v", untagged.ComicInfo)
+	}
+}
@@ -0,0 +1,32 @@
+package cbz
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+
+	// Register the decoders for the supported image formats
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// DecodeConfig returns the dimensions and color model of an image without
+// decoding the whole image. The format name is the one registered with the
+// image package, such as "jpeg" or "png".
+func (i Image) DecodeConfig() (image.Config, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(i.Data))
+	if err != nil {
+		return image.Config{}, "", fmt.Errorf("failed to decode image %s: %w", i.Name, err)
+	}
+	return config, format, nil
+}
+
+// Decode decodes the image data
+func (i Image) Decode() (image.Image, string, error) {
+	img, format, err := image.Decode(bytes.NewReader(i.Data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image %s: %w", i.Name, err)
+	}
+	return img, format, nil
+}
@@ -0,0 +1,174 @@
+package cbz
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Info describes the content of a comic book for inspection
+type Info struct {
+	Name        string           `json:"name"`
+	PageCount   int              `json:"pageCount"`
+	Formats     map[string]int   `json:"formats"`
+	Pages       []PageInfo       `json:"pages"`
+	Spreads     []int            `json:"spreads"`
+	Skipped     []string         `json:"skipped"`
+	TotalSize   int64            `json:"totalSize"`
+	AverageSize int64            `json:"averageSize"`
+	ComicInfo   []ComicInfoField `json:"comicInfo"`
+	Problems    []string         `json:"problems"`
+}
+
+// PageInfo describes a single page image
+type PageInfo struct {
+	Number int    `json:"number"`
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Size   int64  `json:"size"`
+	Spread bool   `json:"spread"`
+}
+
+// ComicInfoField is a field of ComicInfo.xml that is set
+type ComicInfoField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Inspect reads a CBZ file and describes its pages, including the entries
+// that ReadFile skips because they are not images
+func Inspect(filename string) (*Info, error) {
+	cbzFile, err := ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	info := Describe(cbzFile)
+
+	zipReader, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CBZ file: %w", err)
+	}
+	defer zipReader.Close()
+
+	for _, file := range zipReader.File {
+		if isComicInfoFile(file.Name) {
+			// ReadFile ignores a ComicInfo.xml it can't parse
+			if cbzFile.ComicInfo == nil {
+				info.Problems = append(info.Problems, fmt.Sprintf("%s could not be parsed", file.Name))
+			}
+			continue
+		}
+		if !file.FileInfo().IsDir() && !isImageFile(file.Name) {
+			info.Skipped = append(info.Skipped, file.Name)
+		}
+	}
+	return info, nil
+}
+
+// Describe describes the pages of a comic book. Spreads are pages wider than
+// they are tall or marked as double pages in ComicInfo.xml. Problems include
+// images that can't be decoded and images whose extension doesn't match
+// their content.
+func Describe(cbzFile *File) *Info {
+	info := &Info{
+		Name:      cbzFile.Name,
+		PageCount: len(cbzFile.Images),
+		Formats:   map[string]int{},
+		Pages:     []PageInfo{},
+		Spreads:   []int{},
+		Skipped:   []string{},
+		ComicInfo: []ComicInfoField{},
+		Problems:  []string{},
+	}
+
+	doublePages := map[int]bool{}
+	if cbzFile.ComicInfo != nil {
+		info.ComicInfo = cbzFile.ComicInfo.Fields()
+		for _, page := range cbzFile.ComicInfo.Pages {
+			if page.DoublePage {
+				doublePages[page.Image] = true
+			}
+		}
+	}
+
+	for i, image := range cbzFile.Images {
+		page := PageInfo{
+			Number: i + 1,
+			Name:   image.Name,
+			Size:   int64(len(image.Data)),
+		}
+		if image.Path != "" {
+			page.Name = image.Path
+		}
+
+		// Compare the content with the extension
+		detected := http.DetectContentType(image.Data)
+		page.Format = strings.TrimPrefix(detected, "image/")
+		if !strings.HasPrefix(detected, "image/") {
+			page.Format = "unknown"
+			info.Problems = append(info.Problems, fmt.Sprintf("page %d (%s) is not an image", page.Number, page.Name))
+		} else if detected != image.MimeType {
+			info.Problems = append(info.Problems, fmt.Sprintf("page %d (%s) is %s but has the extension of %s",
+				page.Number, page.Name, detected, image.MimeType))
+		}
+		info.Formats[page.Format]++
+
+		// Decode the whole image to find truncated or corrupt data. WebP
+		// images can't be decoded, so they are only checked by content type.
+		if config, _, err := image.DecodeConfig(); err == nil {
+			page.Width = config.Width
+			page.Height = config.Height
+			if _, _, err := image.Decode(); err != nil {
+				info.Problems = append(info.Problems, fmt.Sprintf("page %d (%s) is corrupt: %v", page.Number, page.Name, err))
+			}
+		} else if page.Format != "webp" && page.Format != "unknown" {
+			info.Problems = append(info.Problems, fmt.Sprintf("page %d (%s) is corrupt: %v", page.Number, page.Name, err))
+		}
+
+		page.Spread = page.Width > page.Height || doublePages[i]
+		if page.Spread {
+			info.Spreads = append(info.Spreads, page.Number)
+		}
+
+		info.TotalSize += page.Size
+		info.Pages = append(info.Pages, page)
+	}
+
+	if info.PageCount > 0 {
+		info.AverageSize = info.TotalSize / int64(info.PageCount)
+	} else {
+		info.Problems = append(info.Problems, "no images found")
+	}
+	return info
+}
+
+// Fields returns the fields of ComicInfo.xml that are set, in the order of
+// the ComicInfo schema. Pages are described by Describe instead.
+func (c *ComicInfo) Fields() []ComicInfoField {
+	fields := []ComicInfoField{}
+	value := reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		name := value.Type().Field(i).Name
+		if name == "XMLName" || name == "Pages" || field.IsZero() {
+			continue
+		}
+
+		var text string
+		switch field.Kind() {
+		case reflect.String:
+			text = field.String()
+		case reflect.Int:
+			text = strconv.FormatInt(field.Int(), 10)
+		default:
+			continue
+		}
+		fields = append(fields, ComicInfoField{Name: name, Value: text})
+	}
+	return fields
+}
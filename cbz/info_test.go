@@ -0,0 +1,107 @@
+package cbz
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestInspect tests the Inspect function
+func TestInspect(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var portrait, landscape bytes.Buffer
+	if err := png.Encode(&portrait, image.NewGray(image.Rect(0, 0, 20, 30))); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	if err := jpeg.Encode(&landscape, image.NewGray(image.Rect(0, 0, 40, 30)), nil); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+
+	testFile := filepath.Join(tempDir, "test.cbz")
+	createTestCBZ(t, testFile, []struct{ name, content string }{
+		{"001.png", portrait.String()},
+		{"002.png", landscape.String()},
+		{"003.jpg", landscape.String()[:40]},
+		{"004.png", portrait.String()},
+		{"readme.txt", "Scanned by nobody"},
+		{"ComicInfo.xml", `<ComicInfo><Title>Test</Title><Volume>2</Volume><Pages><Page Image="3" DoublePage="true"/></Pages></ComicInfo>`},
+	})
+
+	info, err := Inspect(testFile)
+	if err != nil {
+		t.Fatalf("Inspect failed: %v", err)
+	}
+
+	if info.PageCount != 4 || len(info.Pages) != 4 {
+		t.Fatalf("Expected 4 pages, got %d", info.PageCount)
+	}
+	if info.Formats["png"] != 2 || info.Formats["jpeg"] != 2 {
+		t.Errorf("Unexpected formats: %v", info.Formats)
+	}
+	if page := info.Pages[0]; page.Width != 20 || page.Height != 30 || page.Format != "png" || page.Size != int64(portrait.Len()) {
+		t.Errorf("Unexpected first page: %+v", page)
+	}
+
+	// Landscape pages and double pages from ComicInfo.xml are spreads
+	if len(info.Spreads) != 2 || info.Spreads[0] != 2 || info.Spreads[1] != 4 {
+		t.Errorf("Expected spreads 2 and 4, got %v", info.Spreads)
+	}
+
+	if len(info.Skipped) != 1 || info.Skipped[0] != "readme.txt" {
+		t.Errorf("Expected readme.txt to be skipped, got %v", info.Skipped)
+	}
+
+	expectedSize := int64(2*portrait.Len() + landscape.Len() + 40)
+	if info.TotalSize != expectedSize || info.AverageSize != expectedSize/4 {
+		t.Errorf("Expected total size %d, got %d (average %d)", expectedSize, info.TotalSize, info.AverageSize)
+	}
+
+	if len(info.ComicInfo) != 2 || info.ComicInfo[0] != (ComicInfoField{"Title", "Test"}) || info.ComicInfo[1] != (ComicInfoField{"Volume", "2"}) {
+		t.Errorf("Unexpected ComicInfo fields: %v", info.ComicInfo)
+	}
+
+	// The JPEG data in 002.png has the wrong extension, 003.jpg is truncated
+	if len(info.Problems) != 2 {
+		t.Fatalf("Expected 2 problems, got %v", info.Problems)
+	}
+	if !strings.Contains(info.Problems[0], "002.png") || !strings.Contains(info.Problems[0], "extension") {
+		t.Errorf("Expected extension problem for 002.png, got %q", info.Problems[0])
+	}
+	if !strings.Contains(info.Problems[1], "003.jpg") || !strings.Contains(info.Problems[1], "corrupt") {
+		t.Errorf("Expected corrupt image problem for 003.jpg, got %q", info.Problems[1])
+	}
+}
+
+// TestInspectBrokenComicInfo tests that a broken ComicInfo.xml is reported
+func TestInspectBrokenComicInfo(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	testFile := filepath.Join(tempDir, "test.cbz")
+	createTestCBZ(t, testFile, []struct{ name, content string }{
+		{"ComicInfo.xml", "<ComicInfo>"},
+	})
+
+	info, err := Inspect(testFile)
+	if err != nil {
+		t.Fatalf("Inspect failed: %v", err)
+	}
+	if len(info.Problems) != 2 || !strings.Contains(info.Problems[0], "no images") || !strings.Contains(info.Problems[1], "ComicInfo.xml") {
+		t.Errorf("Expected no images and ComicInfo.xml problems, got %v", info.Problems)
+	}
+}
@@ -0,0 +1,32 @@
+package cbz
+
+// Stage names a step of reading, converting or writing a book
+type Stage string
+
+const (
+	// StageRead reads the pages of the input file
+	StageRead Stage = "read"
+	// StageProcess transforms the page images before they are written
+	StageProcess Stage = "process"
+	// StageWrite writes the pages to the output file
+	StageWrite Stage = "write"
+)
+
+// Progress reports how far a stage has come
+type Progress struct {
+	Stage      Stage
+	Pages      int   // Pages done in the stage
+	TotalPages int   // Pages of the stage, zero if unknown
+	Bytes      int64 // Bytes written to the output so far
+}
+
+// ProgressFunc receives progress events. It is called from the goroutine
+// doing the work, so it should return quickly.
+type ProgressFunc func(Progress)
+
+// Report calls the function with a progress event, unless it is nil
+func (f ProgressFunc) Report(progress Progress) {
+	if f != nil {
+		f(progress)
+	}
+}
@@ -0,0 +1,76 @@
+package cbz
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestProgress tests the progress events of reading and writing a CBZ file
+func TestProgress(t *testing.T) {
+	file := &File{Images: makeImages("001.jpg", "002.jpg", "003.jpg")}
+
+	var events []Progress
+	var buf bytes.Buffer
+	if err := WriteContext(context.Background(), &buf, file, func(p Progress) { events = append(events, p) }); err != nil {
+		t.Fatalf("WriteContext failed: %v", err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 write events, got %+v", events)
+	}
+	last := events[len(events)-1]
+	if last.Stage != StageWrite || last.Pages != 3 || last.TotalPages != 3 || last.Bytes != int64(buf.Len()) {
+		t.Errorf("Unexpected last write event: %+v", last)
+	}
+
+	events = nil
+	if _, err := ReadContext(context.Background(), bytes.NewReader(buf.Bytes()), int64(buf.Len()), func(p Progress) { events = append(events, p) }); err != nil {
+		t.Fatalf("ReadContext failed: %v", err)
+	}
+	for i, event := range events {
+		if event.Stage != StageRead || event.Pages != i+1 || event.TotalPages != 3 {
+			t.Errorf("Unexpected read event %d: %+v", i, event)
+		}
+	}
+}
+
+// TestCancel tests that cancelled reads, writes and merges stop
+func TestCancel(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "cbz_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	file := &File{Images: makeImages("001.jpg", "002.jpg", "003.jpg")}
+	inputFile := filepath.Join(tempDir, "input.cbz")
+	if err := WriteFile(file, inputFile); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	// Cancel after the first page is written
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	outputFile := filepath.Join(tempDir, "output.cbz")
+	err = WriteFileContext(ctx, file, outputFile, func(p Progress) {
+		if p.Pages == 1 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := os.Stat(outputFile); !os.IsNotExist(err) {
+		t.Errorf("Expected no output file after cancelling, got %v", err)
+	}
+
+	if _, err := ReadFileContext(ctx, inputFile, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from ReadFileContext, got %v", err)
+	}
+	if err := MergeFilesContext(ctx, []string{inputFile, inputFile}, outputFile, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from MergeFilesContext, got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package cbz
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// SplitOptions controls how a CBZ file is split into volumes
+type SplitOptions struct {
+	MaxPages  int   // Maximum number of pages per volume, 0 for no limit
+	MaxBytes  int64 // Maximum size of the CBZ file of a volume, 0 for no limit
+	ByChapter bool  // Start a new volume at every detected chapter
+}
+
+// Chapter represents a chapter boundary inside a CBZ file
+type Chapter struct {
+	Title string
+	Start int // Index of the first image of the chapter
+}
+
+// mergedChapterPattern matches the image names written by MergeFiles
+var mergedChapterPattern = regexp.MustCompile(`^(chapter\d+)_`)
+
+// Chapters detects the chapters of a CBZ file. ComicInfo bookmarks are
+// preferred, then folders inside the archive, then the chapterXXX_ prefixes
+// written by MergeFiles. A file without any of them is a single chapter.
+func (f *File) Chapters() []Chapter {
+	if len(f.Images) == 0 {
+		return nil
+	}
+
+	// Use ComicInfo bookmarks
+	var chapters []Chapter
+	for i, image := range f.Images {
+		if image.Bookmark != "" {
+			chapters = append(chapters, Chapter{Title: image.Bookmark, Start: i})
+		}
+	}
+	if len(chapters) > 0 {
+		// Pages before the first bookmark form their own chapter
+		if chapters[0].Start > 0 {
+			chapters = append([]Chapter{{Title: "", Start: 0}}, chapters...)
+		}
+		return chapters
+	}
+
+	// Use folders, then merged chapter prefixes
+	for _, key := range []func(Image) string{imageFolder, mergedChapter} {
+		chapters = groupImages(f.Images, key)
+		if len(chapters) > 1 {
+			return chapters
+		}
+	}
+
+	return []Chapter{{Title: "", Start: 0}}
+}
+
+// zipEndSize is the size of the end of central directory record of a zip
+// archive
+const zipEndSize = 22
+
+// Split splits a CBZ file into several volumes. Each volume gets a copy of
+// the ComicInfo.xml and keeps at least one page, even if that page alone
+// exceeds MaxBytes.
+func Split(file *File, opts SplitOptions) ([]*File, error) {
+	if opts.MaxPages < 0 || opts.MaxBytes < 0 {
+		return nil, fmt.Errorf("split limits must not be negative")
+	}
+	if opts.MaxPages == 0 && opts.MaxBytes == 0 && !opts.ByChapter {
+		return nil, fmt.Errorf("no split criteria specified")
+	}
+	if len(file.Images) == 0 {
+		return nil, fmt.Errorf("no images to split in %s", file.Name)
+	}
+
+	// Group the images by chapter first, as ranges of image indexes
+	groups := [][2]int{{0, len(file.Images)}}
+	if opts.ByChapter {
+		groups = nil
+		chapters := file.Chapters()
+		for i, chapter := range chapters {
+			end := len(file.Images)
+			if i+1 < len(chapters) {
+				end = chapters[i+1].Start
+			}
+			groups = append(groups, [2]int{chapter.Start, end})
+		}
+	}
+
+	// Every volume has the zip directory end and the ComicInfo.xml, which
+	// is no larger than the one of the whole file
+	overhead := int64(zipEndSize)
+	if file.ComicInfo != nil {
+		data, err := file.ComicInfo.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		overhead += zipEntrySize("ComicInfo.xml", int64(len(data)))
+	}
+
+	// Split each group by the page and size limits
+	var parts []*File
+	for _, group := range groups {
+		start, size := group[0], overhead
+		for i := group[0]; i < group[1]; i++ {
+			image := file.Images[i]
+			imageSize := zipEntrySize(zipName(image), int64(len(image.Data)))
+			full := (opts.MaxPages > 0 && i-start >= opts.MaxPages) ||
+				(opts.MaxBytes > 0 && size+imageSize > opts.MaxBytes)
+			if i > start && full {
+				parts = append(parts, file.part(start, i))
+				start, size = i, overhead
+			}
+			size += imageSize
+		}
+		parts = append(parts, file.part(start, group[1]))
+	}
+
+	return parts, nil
+}
+
+// zipEntrySize estimates the space a file takes in a zip archive written
+// by Write: the local header, the data descriptor and the central
+// directory entry, and the deflated data. Incompressible data such as JPEG
+// images grows by a few bytes per deflate block.
+func zipEntrySize(name string, size int64) int64 {
+	return 30 + 16 + 46 + 2*int64(len(name)) + size + 5*(size/16384+1)
+}
+
+// part returns the images from start to end as a new file. Its ComicInfo.xml
+// is a copy with the page entries of those images, renumbered.
+func (f *File) part(start, end int) *File {
+	part := &File{Name: f.Name, Images: append([]Image(nil), f.Images[start:end]...)}
+	if f.ComicInfo == nil {
+		return part
+	}
+
+	comicInfo := *f.ComicInfo
+	comicInfo.Pages = nil
+	for _, page := range f.ComicInfo.Pages {
+		if page.Image >= start && page.Image < end {
+			page.Image -= start
+			comicInfo.Pages = append(comicInfo.Pages, page)
+		}
+	}
+	if comicInfo.PageCount > 0 {
+		comicInfo.PageCount = end - start
+	}
+	part.ComicInfo = &comicInfo
+	return part
+}
+
+// groupImages starts a new chapter whenever the key of an image changes
+func groupImages(images []Image, key func(Image) string) []Chapter {
+	var chapters []Chapter
+	for i, image := range images {
+		k := key(image)
+		if i == 0 || k != key(images[i-1]) {
+			chapters = append(chapters, Chapter{Title: k, Start: i})
+		}
+	}
+	return chapters
+}
+
+// imageFolder returns the folder of an image inside the archive
+func imageFolder(image Image) string {
+	dir := path.Dir(image.Path)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// mergedChapter returns the chapterXXX prefix of an image written by MergeFiles
+func mergedChapter(image Image) string {
+	match := mergedChapterPattern.FindStringSubmatch(image.Name)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
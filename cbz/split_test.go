@@ -0,0 +1,249 @@
+package cbz
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeImages creates in-memory images with the given paths
+func makeImages(paths ...string) []Image {
+	images := make([]Image, 0, len(paths))
+	for _, p := range paths {
+		images = append(images, Image{
+			Name:     filepath.Base(p),
+			Path:     p,
+			Data:     []byte("data of " + p),
+			MimeType: MimeType(p),
+		})
+	}
+	return images
+}
+
+// TestChapters tests the chapter detection
+func TestChapters(t *testing.T) {
+	tests := []struct {
+		name     string
+		images   []Image
+		expected []int
+	}{
+		{"single chapter", makeImages("001.jpg", "002.jpg"), []int{0}},
+		{"folders", makeImages("ch1/001.jpg", "ch1/002.jpg", "ch2/001.jpg"), []int{0, 2}},
+		{"merged prefixes", makeImages("chapter001_001.jpg", "chapter002_002.jpg", "chapter002_003.jpg"), []int{0, 1}},
+		{"no images", nil, nil},
+	}
+
+	for _, test := range tests {
+		file := &File{Name: "test.cbz", Images: test.images}
+		chapters := file.Chapters()
+		if len(chapters) != len(test.expected) {
+			t.Errorf("%s: expected %d chapters, got %d", test.name, len(test.expected), len(chapters))
+			continue
+		}
+		for i, chapter := range chapters {
+			if chapter.Start != test.expected[i] {
+				t.Errorf("%s: expected chapter %d to start at %d, got %d", test.name, i, test.expected[i], chapter.Start)
+			}
+		}
+	}
+
+	// Bookmarks take precedence over folders
+	images := makeImages("ch1/001.jpg", "ch1/002.jpg", "ch2/001.jpg")
+	images[1].Bookmark = "Prologue"
+	chapters := (&File{Name: "test.cbz", Images: images}).Chapters()
+	if len(chapters) != 2 || chapters[1].Start != 1 || chapters[1].Title != "Prologue" {
+		t.Errorf("Unexpected chapters from bookmarks: %+v", chapters)
+	}
+}
+
+// TestChapterFolders tests that chapter folders reusing page names are
+// read in order and found as chapters
+func TestChapterFolders(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "cbz_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The archive lists the pages by name, mixing the chapters
+	cbzPath := filepath.Join(tempDir, "test.cbz")
+	createTestCBZ(t, cbzPath, []struct{ name, content string }{
+		{"Chapter 01/001.jpg", "page 1"},
+		{"Chapter 02/001.jpg", "page 3"},
+		{"Chapter 01/002.jpg", "page 2"},
+		{"Chapter 02/002.jpg", "page 4"},
+	})
+
+	file, err := ReadFile(cbzPath)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	for i, image := range file.Images {
+		if expected := fmt.Sprintf("page %d", i+1); string(image.Data) != expected {
+			t.Errorf("Expected %s at position %d, got %s from %s", expected, i, image.Data, image.Path)
+		}
+	}
+
+	chapters := file.Chapters()
+	if len(chapters) != 2 || chapters[0].Title != "Chapter 01" || chapters[1].Title != "Chapter 02" || chapters[1].Start != 2 {
+		t.Errorf("Expected two chapters of two pages, got %+v", chapters)
+	}
+}
+
+// TestSplit tests the Split function
+func TestSplit(t *testing.T) {
+	file := &File{
+		Name:   "test.cbz",
+		Images: makeImages("ch1/001.jpg", "ch1/002.jpg", "ch1/003.jpg", "ch2/001.jpg", "ch2/002.jpg"),
+	}
+
+	tests := []struct {
+		name     string
+		opts     SplitOptions
+		expected []int
+	}{
+		{"max pages", SplitOptions{MaxPages: 2}, []int{2, 2, 1}},
+		{"by chapter", SplitOptions{ByChapter: true}, []int{3, 2}},
+		{"by chapter and max pages", SplitOptions{ByChapter: true, MaxPages: 2}, []int{2, 1, 2}},
+		{"max bytes", SplitOptions{MaxBytes: zipEndSize + 2*zipEntrySize(file.Images[0].Path, int64(len(file.Images[0].Data)))}, []int{2, 2, 1}},
+		{"page larger than max bytes", SplitOptions{MaxBytes: 1}, []int{1, 1, 1, 1, 1}},
+	}
+
+	for _, test := range tests {
+		parts, err := Split(file, test.opts)
+		if err != nil {
+			t.Errorf("%s: Split failed: %v", test.name, err)
+			continue
+		}
+		if len(parts) != len(test.expected) {
+			t.Errorf("%s: expected %d parts, got %d", test.name, len(test.expected), len(parts))
+			continue
+		}
+		for i, part := range parts {
+			if len(part.Images) != test.expected[i] {
+				t.Errorf("%s: expected part %d to have %d pages, got %d", test.name, i, test.expected[i], len(part.Images))
+			}
+		}
+	}
+
+	// Test without split criteria
+	if _, err := Split(file, SplitOptions{}); err == nil {
+		t.Errorf("Split should fail without split criteria")
+	}
+}
+
+// TestSplitSize tests that volumes stay below the size limit and keep the metadata
+func TestSplitSize(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	file := &File{
+		Name:      "test.cbz",
+		ComicInfo: &ComicInfo{Title: "Big Book", PageCount: 10, Pages: []ComicInfoPage{{Image: 6, Bookmark: "Chapter 2"}}},
+	}
+	for i := 0; i < 10; i++ {
+		data := make([]byte, 3000)
+		random.Read(data)
+		file.Images = append(file.Images, Image{Name: fmt.Sprintf("%03d.jpg", i), Path: fmt.Sprintf("%03d.jpg", i), Data: data, MimeType: "image/jpeg"})
+	}
+	file.Images[6].Bookmark = "Chapter 2"
+
+	const maxBytes = 10000
+	parts, err := Split(file, SplitOptions{MaxBytes: maxBytes})
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	if len(parts) != 4 {
+		t.Errorf("Expected 4 parts, got %d", len(parts))
+	}
+
+	for i, part := range parts {
+		var buf bytes.Buffer
+		if err := Write(&buf, part); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if buf.Len() > maxBytes {
+			t.Errorf("Expected part %d to have at most %d bytes, got %d", i, maxBytes, buf.Len())
+		}
+
+		readFile, err := Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if readFile.ComicInfo == nil || readFile.ComicInfo.Title != "Big Book" || readFile.ComicInfo.PageCount != len(part.Images) {
+			t.Errorf("Expected the metadata in part %d, got %+v", i, readFile.ComicInfo)
+		}
+	}
+
+	// The bookmark of the seventh page moves to the first page of the third part
+	if pages := parts[2].ComicInfo.Pages; len(pages) != 1 || pages[0].Image != 0 || parts[2].Images[0].Bookmark != "Chapter 2" {
+		t.Errorf("Expected the bookmark on the first page of the third part, got %+v", pages)
+	}
+	if len(parts[0].ComicInfo.Pages) != 0 {
+		t.Errorf("Expected no bookmarks in the first part, got %+v", parts[0].ComicInfo.Pages)
+	}
+}
+
+// TestWriteFile tests that WriteFile output can be read back
+func TestWriteFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "cbz_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	file := &File{
+		Name:   "test.cbz",
+		Images: makeImages("ch1/001.jpg", "ch2/001.jpg"),
+	}
+
+	outputFile := filepath.Join(tempDir, "out.cbz")
+	if err := WriteFile(file, outputFile); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	readFile, err := ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+
+	if len(readFile.Images) != 2 {
+		t.Fatalf("Expected 2 images, got %d", len(readFile.Images))
+	}
+
+	// Images with the same name in different folders must both survive
+	for i, image := range readFile.Images {
+		if image.Path != file.Images[i].Path {
+			t.Errorf("Expected image path %s, got %s", file.Images[i].Path, image.Path)
+		}
+	}
+}
+
+// TestWriteRead tests writing a CBZ file to memory and reading it back
+func TestWriteRead(t *testing.T) {
+	file := &File{
+		Images:    makeImages("001.jpg", "002.jpg"),
+		ComicInfo: &ComicInfo{Series: "Saga", Volume: 1},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, file); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	readFile, err := Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if readFile.Name != "" || len(readFile.Images) != 2 {
+		t.Fatalf("Unexpected file %q with %d images", readFile.Name, len(readFile.Images))
+	}
+	if readFile.ComicInfo == nil || readFile.ComicInfo.Series != "Saga" {
+		t.Errorf("Expected ComicInfo.xml to be read, got %+v", readFile.ComicInfo)
+	}
+
+	if _, err := Read(bytes.NewReader([]byte("not a zip")), 9); err == nil {
+		t.Error("Expected an error for data that is not a CBZ file")
+	}
+}
@@ -0,0 +1,366 @@
+package cbz2epub
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// command is a subcommand of the command line interface with its own flags
+type command struct {
+	name        string
+	usage       string
+	description string
+	// setFlags registers the flags of the command on its flag set
+	setFlags func(fs *flag.FlagSet, config *Config)
+	// validate checks the parsed configuration before the command runs
+	validate func(config Config) error
+	run      func(config Config) error
+	// noInputFiles is set for commands that take no input files
+	noInputFiles bool
+}
+
+// commands lists the subcommands in the order they are shown in the help
+var commands []*command
+
+// init registers the subcommands. The command handlers print the usage,
+// which lists the commands, so the list can't be a plain initializer.
+func init() {
+	commands = []*command{
+		{
+			name:        "convert",
+			usage:       "convert [options] file.cbz|file.pdf|directory ...",
+			description: "Convert CBZ or PDF files to EPUB, KEPUB, Kindle EPUB or PDF.",
+			setFlags: func(fs *flag.FlagSet, config *Config) {
+				fs.StringVar(&config.OutputFile, "output", "", "Output file name, used with a single input file")
+				fs.StringVar(&config.OutputDir, "outdir", "", "Output directory, mirroring the tree of input directories")
+				fs.BoolVar(&config.Flatten, "flatten", false, "Write all files directly into the output directory")
+				fs.StringVar(&config.NameTemplate, "name-template", "", "Output file name template, see the placeholders in the README")
+				fs.StringVar(&config.Format, "format", "epub", "Output format: epub, kepub, kindle or pdf")
+				fs.Var((*stringList)(&config.NamePatterns), "name-pattern", "Regular expression with named groups for metadata in file names, can be repeated")
+				fs.StringVar(&config.Panels, "panels", "", "Panel regions for panel view and guided reading: detect or grid")
+				fs.BoolVar(&config.Recursive, "recursive", false, "Process directories recursively")
+				fs.StringVar(&config.Report, "report", "", "Write a JSON report of the conversions to this file")
+				fs.BoolVar(&config.JSON, "json", false, "Write a JSON report of the conversions to stdout")
+				fs.BoolVar(&config.DryRun, "dry-run", false, "Show what would be converted without writing anything")
+				addMetadataFlags(fs, config)
+				fs.StringVar(&config.Identifier, "identifier", "", "Unique identifier of the book, such as an ISBN or a URN, for a single input file")
+				fs.BoolVar(&config.NoSidecars, "no-sidecars", false, "Ignore metadata.opf, book.json and cover.jpg next to the input files")
+				fs.BoolVar(&config.Reproducible, "reproducible", false, "Write identical EPUB files for unchanged input files")
+				fs.StringVar(&config.Overwrite, "overwrite", "always", "Overwrite policy for existing output files: always, never or newer")
+				fs.BoolVar(&config.NoProgress, "no-progress", false, "Don't show the progress of the files being converted")
+				fs.BoolVar(&config.Verbose, "verbose", false, "Enable verbose output")
+			},
+			validate: func(config Config) error {
+				format, err := convertFormat(config)
+				if err != nil {
+					return err
+				}
+				if err := checkPanels(config.Panels, format); err != nil {
+					return err
+				}
+				if !isOverwritePolicy(config.Overwrite) {
+					return fmt.Errorf("unsupported overwrite policy: %s", config.Overwrite)
+				}
+				if config.Flatten && config.OutputDir == "" {
+					return fmt.Errorf("-flatten requires -outdir")
+				}
+				if _, err := namePatterns(config); err != nil {
+					return err
+				}
+				return validateMetadata(config)
+			},
+			run: handleConvertCommand,
+		},
+		{
+			name:        "merge",
+			usage:       "merge [options] file1.cbz file2.pdf ...",
+			description: "Merge CBZ or PDF files into one CBZ file, in file name order.",
+			setFlags: func(fs *flag.FlagSet, config *Config) {
+				fs.StringVar(&config.OutputFile, "output", "merged.cbz", "Output file name")
+				fs.StringVar(&config.NameTemplate, "name-template", "", "Output file name template, filled from the first file's metadata")
+				fs.Var((*stringList)(&config.NamePatterns), "name-pattern", "Regular expression with named groups for metadata in file names, can be repeated")
+				addMetadataFlags(fs, config)
+				fs.BoolVar(&config.NoProgress, "no-progress", false, "Don't show the progress of the files being converted")
+				fs.BoolVar(&config.Verbose, "verbose", false, "Enable verbose output")
+			},
+			validate: func(config Config) error {
+				if _, err := namePatterns(config); err != nil {
+					return err
+				}
+				return validateMetadata(config)
+			},
+			run: handleMergeCommand,
+		},
+		{
+			name:        "split",
+			usage:       "split [options] file.cbz ...",
+			description: "Split CBZ or PDF files into volumes by page count, size or chapter.",
+			setFlags: func(fs *flag.FlagSet, config *Config) {
+				fs.IntVar(&config.MaxPages, "max-pages", 0, "Maximum number of pages per volume")
+				fs.Float64Var(&config.MaxSize, "max-size", 0, "Maximum size in MB per volume")
+				fs.BoolVar(&config.ByChapter, "by-chapter", false, "Split volumes at chapter boundaries")
+				fs.StringVar(&config.Format, "format", "cbz", "Output format: cbz, epub, kepub, kindle or pdf")
+				fs.StringVar(&config.SplitName, "split-name", "{name}_part{part}", "Name template for volumes ({name}, {part} and metadata placeholders)")
+				fs.BoolVar(&config.NoSidecars, "no-sidecars", false, "Ignore metadata.opf, book.json and cover.jpg next to the input files")
+				fs.BoolVar(&config.Reproducible, "reproducible", false, "Write identical EPUB files for unchanged input files")
+				fs.Var((*stringList)(&config.NamePatterns), "name-pattern", "Regular expression with named groups for metadata in file names, can be repeated")
+				fs.StringVar(&config.Panels, "panels", "", "Panel regions for panel view and guided reading: detect or grid")
+				fs.BoolVar(&config.NoProgress, "no-progress", false, "Don't show the progress of the files being converted")
+				fs.BoolVar(&config.Verbose, "verbose", false, "Enable verbose output")
+			},
+			validate: func(config Config) error {
+				if config.MaxPages < 0 || config.MaxSize < 0 {
+					return fmt.Errorf("-max-pages and -max-size must not be negative")
+				}
+				if config.MaxPages == 0 && config.MaxSize == 0 && !config.ByChapter {
+					return fmt.Errorf("one of -max-pages, -max-size or -by-chapter is required")
+				}
+				if err := checkPanels(config.Panels, strings.ToLower(config.Format)); err != nil {
+					return err
+				}
+				_, err := namePatterns(config)
+				return err
+			},
+			run: handleSplitCommand,
+		},
+		{
+			name:        "extract",
+			usage:       "extract [options] file.epub ...",
+			description: "Convert fixed-layout image EPUB files back to CBZ.",
+			setFlags: func(fs *flag.FlagSet, config *Config) {
+				fs.StringVar(&config.OutputFile, "output", "", "Output file name, used with a single input file")
+				fs.BoolVar(&config.Verbose, "verbose", false, "Enable verbose output")
+			},
+			run: handleExtractCommand,
+		},
+		{
+			name:        "info",
+			usage:       "info [options] file.cbz|file.pdf|file.epub ...",
+			description: "Show the pages, sizes, metadata and problems of comic books.",
+			setFlags: func(fs *flag.FlagSet, config *Config) {
+				fs.BoolVar(&config.JSON, "json", false, "Write the information as JSON")
+			},
+			run: handleInfoCommand,
+		},
+		{
+			name:        "watch",
+			usage:       "watch [options] directory ...",
+			description: "Convert the files and folders dropped into directories as they arrive.",
+			setFlags: func(fs *flag.FlagSet, config *Config) {
+				fs.StringVar(&config.Format, "format", "epub", "Output format: epub, kepub, kindle or pdf")
+				fs.StringVar(&config.OutputDir, "outdir", "", "Output directory (default \"converted\" in the watched directory)")
+				fs.StringVar(&config.Processed, "processed", "", "Directory for converted originals (default \"processed\" in the watched directory)")
+				fs.StringVar(&config.Failed, "failed", "", "Directory for originals that failed (default \"failed\" in the watched directory)")
+				fs.StringVar(&config.StateFile, "state", "", "State file recording the handled files (default \""+watchStateFile+"\" in the first directory)")
+				fs.BoolVar(&config.MergeFolders, "merge-folders", false, "Merge the files of each folder into one book named after the folder")
+				fs.StringVar(&config.NameTemplate, "name-template", "", "Output file name template, see the placeholders in the README")
+				fs.Var((*stringList)(&config.NamePatterns), "name-pattern", "Regular expression with named groups for metadata in file names, can be repeated")
+				fs.StringVar(&config.Panels, "panels", "", "Panel regions for panel view and guided reading: detect or grid")
+				fs.BoolVar(&config.NoSidecars, "no-sidecars", false, "Ignore metadata.opf, book.json and cover.jpg next to the input files")
+				fs.BoolVar(&config.Reproducible, "reproducible", false, "Write identical EPUB files for unchanged input files")
+				fs.DurationVar(&config.Settle, "settle", 5*time.Second, "Time files must stop changing before they are converted")
+				fs.DurationVar(&config.Interval, "interval", 10*time.Second, "Time between scans of the directories")
+				fs.BoolVar(&config.Poll, "poll", false, "Scan the directories every interval instead of using inotify")
+				fs.BoolVar(&config.Once, "once", false, "Convert the files found at the start and exit")
+				fs.BoolVar(&config.Verbose, "verbose", false, "Enable verbose output")
+			},
+			validate: func(config Config) error {
+				format, err := convertFormat(config)
+				if err != nil {
+					return err
+				}
+				if err := checkPanels(config.Panels, format); err != nil {
+					return err
+				}
+				if config.Settle < 0 {
+					return fmt.Errorf("-settle must not be negative")
+				}
+				if config.Interval <= 0 {
+					return fmt.Errorf("-interval must be positive")
+				}
+				_, err = namePatterns(config)
+				return err
+			},
+			run: handleWatchCommand,
+		},
+		{
+			name:        "serve",
+			usage:       "serve [options]",
+			description: "Run an HTTP service that converts uploaded CBZ files.",
+			setFlags: func(fs *flag.FlagSet, config *Config) {
+				fs.StringVar(&config.Listen, "listen", "localhost:8080", "Address the service listens on")
+				fs.Float64Var(&config.MaxUpload, "max-upload", 500, "Maximum size in MB of an uploaded file")
+				fs.IntVar(&config.Concurrency, "concurrency", 0, "Maximum number of conversions running at the same time (default the number of CPUs)")
+				fs.IntVar(&config.MaxJobs, "max-jobs", 100, "Maximum number of jobs kept, queued, running or done")
+				fs.DurationVar(&config.JobTTL, "job-ttl", time.Hour, "Time the result of a job is kept after it finished")
+				fs.DurationVar(&config.UploadTimeout, "upload-timeout", 10*time.Minute, "Maximum time to receive an uploaded file")
+				fs.StringVar(&config.Format, "format", "epub", "Default output format: epub, kepub, kindle or pdf")
+				fs.StringVar(&config.Panels, "panels", "", "Default panel regions for panel view and guided reading: detect or grid")
+				fs.BoolVar(&config.Reproducible, "reproducible", false, "Write identical EPUB files for unchanged uploads")
+				fs.BoolVar(&config.Verbose, "verbose", false, "Log every request")
+			},
+			validate: func(config Config) error {
+				format, err := convertFormat(config)
+				if err != nil {
+					return err
+				}
+				if err := checkPanels(config.Panels, format); err != nil {
+					return err
+				}
+				if config.Concurrency < 0 {
+					return fmt.Errorf("-concurrency must not be negative")
+				}
+				if config.MaxUpload <= 0 || config.MaxJobs <= 0 || config.JobTTL <= 0 || config.UploadTimeout <= 0 {
+					return fmt.Errorf("-max-upload, -max-jobs, -job-ttl and -upload-timeout must be positive")
+				}
+				return nil
+			},
+			run:          handleServeCommand,
+			noInputFiles: true,
+		},
+	}
+}
+
+// findCommand returns the subcommand with the given name, or nil
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// runCommand parses the arguments of a subcommand and runs it. The first
+// argument is the command name.
+func runCommand(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return nil
+	}
+
+	// Show the help of the tool or of a single command
+	if isHelp(args[0]) {
+		if len(args) > 1 {
+			cmd := findCommand(args[1])
+			if cmd == nil {
+				return fmt.Errorf("unknown command: %s", args[1])
+			}
+			cmd.newFlagSet(&Config{}, os.Stdout).Usage()
+			return nil
+		}
+		printUsage()
+		return nil
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		printUsage()
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+
+	config, err := cmd.parse(args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return cmd.run(config)
+}
+
+// newFlagSet returns the flag set of the command, storing the flag values
+// in config and writing errors and help to output
+func (cmd *command) newFlagSet(config *Config, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	cmd.setFlags(fs, config)
+	fs.Usage = func() {
+		fmt.Fprintf(output, "Usage:\n  cbz2epub %s\n\n%s\n\nOptions:\n", cmd.usage, cmd.description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses and validates the arguments of the command. Flags may be
+// given before, between or after the input files; arguments after "--" are
+// always input files.
+func (cmd *command) parse(args []string, output io.Writer) (Config, error) {
+	config := Config{}
+	fs := cmd.newFlagSet(&config, output)
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return Config{}, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			break
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			config.InputFiles = append(config.InputFiles, rest...)
+			break
+		}
+		config.InputFiles = append(config.InputFiles, rest[0])
+		args = rest[1:]
+	}
+
+	// Without input files, a recursive conversion processes the current directory
+	if len(config.InputFiles) == 0 && config.Recursive {
+		files, err := findInputFiles(".")
+		if err == nil {
+			config.InputFiles = files
+		}
+	}
+
+	if cmd.noInputFiles && len(config.InputFiles) > 0 {
+		fs.Usage()
+		return Config{}, fmt.Errorf("unexpected arguments: %s", strings.Join(config.InputFiles, " "))
+	}
+	if len(config.InputFiles) == 0 && !cmd.noInputFiles {
+		fs.Usage()
+		return Config{}, fmt.Errorf("no input files specified")
+	}
+	if cmd.validate != nil {
+		if err := cmd.validate(config); err != nil {
+			return Config{}, err
+		}
+	}
+	return config, nil
+}
+
+// legacyCommands returns the names of the deprecated command flags set in
+// a configuration parsed by parseFlags
+func legacyCommands(config Config) []string {
+	var names []string
+	for _, c := range []struct {
+		name string
+		set  bool
+	}{
+		{"merge", config.Merge},
+		{"split", config.Split},
+		{"extract", config.Extract},
+		{"convert", config.Convert},
+	} {
+		if c.set {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+// isLegacyArgs checks if the arguments use the deprecated flag style, where
+// the command is selected with a flag such as -convert
+func isLegacyArgs(args []string) bool {
+	return len(args) > 0 && strings.HasPrefix(args[0], "-") && !isHelp(args[0])
+}
+
+// isHelp checks if an argument asks for the help of the tool
+func isHelp(arg string) bool {
+	return arg == "help" || arg == "-h" || arg == "-help" || arg == "--help"
+}
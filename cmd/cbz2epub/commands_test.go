@@ -0,0 +1,259 @@
+package cbz2epub
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestCommandParse tests parsing the arguments of the subcommands
+func TestCommandParse(t *testing.T) {
+	testCases := []struct {
+		name        string
+		command     string
+		args        []string
+		expected    Config
+		expectError bool
+	}{
+		{
+			name:    "convert with flags before files",
+			command: "convert",
+			args:    []string{"-format", "kepub", "-output", "out.epub", "test.cbz"},
+			expected: Config{
+				Format:     "kepub",
+				OutputFile: "out.epub",
+				InputFiles: []string{"test.cbz"},
+			},
+		},
+		{
+			name:    "convert with flags after files",
+			command: "convert",
+			args:    []string{"a.cbz", "-format", "pdf", "b.cbz", "-verbose"},
+			expected: Config{
+				Format:     "pdf",
+				Verbose:    true,
+				InputFiles: []string{"a.cbz", "b.cbz"},
+			},
+		},
+		{
+			name:    "convert with files after double dash",
+			command: "convert",
+			args:    []string{"-format", "epub", "--", "-odd.cbz", "-verbose"},
+			expected: Config{
+				Format:     "epub",
+				InputFiles: []string{"-odd.cbz", "-verbose"},
+			},
+		},
+		{
+			name:        "convert with unsupported format",
+			command:     "convert",
+			args:        []string{"-format", "mobi", "test.cbz"},
+			expectError: true,
+		},
+		{
+			name:    "watch with rules",
+			command: "watch",
+			args:    []string{"-format", "kepub", "-merge-folders", "-settle", "30s", "/srv/inbox"},
+			expected: Config{
+				Format:       "kepub",
+				MergeFolders: true,
+				Settle:       30 * time.Second,
+				Interval:     10 * time.Second,
+				InputFiles:   []string{"/srv/inbox"},
+			},
+		},
+		{
+			name:        "watch with negative settle time",
+			command:     "watch",
+			args:        []string{"-settle", "-1s", "/srv/inbox"},
+			expectError: true,
+		},
+		{
+			name:    "serve with defaults",
+			command: "serve",
+			args:    []string{"-listen", ":8080"},
+			expected: Config{
+				Listen:        ":8080",
+				MaxUpload:     500,
+				MaxJobs:       100,
+				JobTTL:        time.Hour,
+				UploadTimeout: 10 * time.Minute,
+				Format:        "epub",
+			},
+		},
+		{
+			name:        "serve rejects input files",
+			command:     "serve",
+			args:        []string{"book.cbz"},
+			expectError: true,
+		},
+		{
+			name:        "convert with unsupported panel mode",
+			command:     "convert",
+			args:        []string{"-panels", "magic", "test.cbz"},
+			expectError: true,
+		},
+		{
+			name:        "convert without input files",
+			command:     "convert",
+			args:        []string{"-format", "epub"},
+			expectError: true,
+		},
+		{
+			name:        "convert with unsupported overwrite policy",
+			command:     "convert",
+			args:        []string{"-overwrite", "sometimes", "test.cbz"},
+			expectError: true,
+		},
+		{
+			name:    "convert with repeated name patterns",
+			command: "convert",
+			args:    []string{"-name-pattern", `^(?P<series>.+) Book (?P<volume>\d+)$`, "-name-pattern", `^(?P<title>.+)$`, "test.cbz"},
+			expected: Config{
+				NamePatterns: []string{`^(?P<series>.+) Book (?P<volume>\d+)$`, `^(?P<title>.+)$`},
+				InputFiles:   []string{"test.cbz"},
+			},
+		},
+		{
+			name:        "convert with invalid name pattern",
+			command:     "convert",
+			args:        []string{"-name-pattern", `(?P<series>.+`, "test.cbz"},
+			expectError: true,
+		},
+		{
+			name:        "convert with name pattern without named groups",
+			command:     "convert",
+			args:        []string{"-name-pattern", `^(.+) v(\d+)$`, "test.cbz"},
+			expectError: true,
+		},
+		{
+			name:    "merge with default output",
+			command: "merge",
+			args:    []string{"a.cbz", "b.cbz"},
+			expected: Config{
+				OutputFile: "merged.cbz",
+				InputFiles: []string{"a.cbz", "b.cbz"},
+			},
+		},
+		{
+			name:        "merge rejects convert flags",
+			command:     "merge",
+			args:        []string{"-format", "pdf", "a.cbz", "b.cbz"},
+			expectError: true,
+		},
+		{
+			name:    "split by pages",
+			command: "split",
+			args:    []string{"-max-pages", "100", "big.cbz"},
+			expected: Config{
+				MaxPages:   100,
+				Format:     "cbz",
+				SplitName:  "{name}_part{part}",
+				InputFiles: []string{"big.cbz"},
+			},
+		},
+		{
+			name:        "split without criteria",
+			command:     "split",
+			args:        []string{"big.cbz"},
+			expectError: true,
+		},
+		{
+			name:    "info as json",
+			command: "info",
+			args:    []string{"-json", "a.cbz", "b.epub"},
+			expected: Config{
+				JSON:       true,
+				InputFiles: []string{"a.cbz", "b.epub"},
+			},
+		},
+		{
+			name:        "extract rejects recursive",
+			command:     "extract",
+			args:        []string{"-recursive", "book.epub"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := findCommand(tc.command)
+			if cmd == nil {
+				t.Fatalf("Command %s not found", tc.command)
+			}
+
+			config, err := cmd.parse(tc.args, io.Discard)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			// Convert uses the default format and overwrite policy
+			if tc.command == "convert" {
+				if tc.expected.Format == "" {
+					tc.expected.Format = "epub"
+				}
+				tc.expected.Overwrite = "always"
+			}
+			if !reflect.DeepEqual(config, tc.expected) {
+				t.Errorf("Expected config %+v, got %+v", tc.expected, config)
+			}
+		})
+	}
+
+	// Asking for help is not an error of the command
+	_, err := findCommand("convert").parse([]string{"-h"}, io.Discard)
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("Expected flag.ErrHelp, got %v", err)
+	}
+}
+
+// TestRunCommand tests dispatching the subcommands
+func TestRunCommand(t *testing.T) {
+	testCases := []struct {
+		name        string
+		args        []string
+		expectError bool
+	}{
+		{name: "no arguments", args: nil},
+		{name: "help", args: []string{"help"}},
+		{name: "command help", args: []string{"help", "split"}},
+		{name: "help for unknown command", args: []string{"help", "frobnicate"}, expectError: true},
+		{name: "unknown command", args: []string{"frobnicate"}, expectError: true},
+		{name: "missing input file", args: []string{"convert", "nonexistent.cbz"}, expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := runCommand(tc.args)
+			if tc.expectError && err == nil {
+				t.Errorf("Expected error, got nil")
+			} else if !tc.expectError && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+// TestLegacyArgs tests detecting and validating the deprecated flag style
+func TestLegacyArgs(t *testing.T) {
+	if !isLegacyArgs([]string{"-convert", "test.cbz"}) {
+		t.Errorf("Expected -convert to use the deprecated flag style")
+	}
+	if isLegacyArgs([]string{"convert", "test.cbz"}) || isLegacyArgs([]string{"-h"}) || isLegacyArgs(nil) {
+		t.Errorf("Expected subcommands and help not to use the deprecated flag style")
+	}
+
+	names := legacyCommands(Config{Merge: true, Convert: true})
+	if !reflect.DeepEqual(names, []string{"merge", "convert"}) {
+		t.Errorf("Expected merge and convert commands, got %v", names)
+	}
+}
@@ -0,0 +1,160 @@
+package cbz2epub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cbz2epub/cbz"
+	"cbz2epub/epub"
+	"cbz2epub/pdf"
+)
+
+// handleInfoCommand handles the info command
+func handleInfoCommand(config Config) error {
+	if len(config.InputFiles) == 0 {
+		log.Println("No input files specified")
+		printUsage()
+		return fmt.Errorf("no input files specified")
+	}
+
+	var infoError error
+	infos := []*cbz.Info{}
+
+	// Inspect each input file
+	for _, inputFile := range config.InputFiles {
+		info, err := inspectBook(inputFile)
+		if err != nil {
+			log.Printf("Error inspecting %s: %v\n", inputFile, err)
+			infoError = err
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	if config.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(infos); err != nil {
+			return fmt.Errorf("failed to write JSON: %w", err)
+		}
+		return infoError
+	}
+
+	for i, info := range infos {
+		if i > 0 {
+			fmt.Println()
+		}
+		printInfo(os.Stdout, info)
+	}
+	return infoError
+}
+
+// inspectBook describes a CBZ, PDF or EPUB file. Only CBZ files list the
+// entries that are skipped because they are not images. PDF pages that
+// can't be converted are listed as problems.
+func inspectBook(inputFile string) (*cbz.Info, error) {
+	switch strings.ToLower(filepath.Ext(inputFile)) {
+	case ".cbz":
+		return cbz.Inspect(inputFile)
+	case ".epub":
+		cbzFile, err := epub.ReadFile(inputFile)
+		if err != nil {
+			return nil, err
+		}
+		return cbz.Describe(cbzFile), nil
+	case ".pdf":
+		cbzFile, err := pdf.ReadFile(inputFile)
+		var pageErr *pdf.PageError
+		if err != nil && (!errors.As(err, &pageErr) || cbzFile == nil) {
+			return nil, err
+		}
+		info := cbz.Describe(cbzFile)
+		if pageErr != nil {
+			for i, page := range pageErr.Pages {
+				info.Problems = append(info.Problems, fmt.Sprintf("PDF page %d is skipped: %s", page, pageErr.Reasons[i]))
+			}
+		}
+		return info, nil
+	default:
+		cbzFile, err := readBook(inputFile, nil)
+		if err != nil {
+			return nil, err
+		}
+		return cbz.Describe(cbzFile), nil
+	}
+}
+
+// printInfo writes a human-readable description of a comic book
+func printInfo(w io.Writer, info *cbz.Info) {
+	formats := make([]string, 0, len(info.Formats))
+	for format, count := range info.Formats {
+		formats = append(formats, fmt.Sprintf("%s: %d", format, count))
+	}
+	sort.Strings(formats)
+
+	fmt.Fprintf(w, "File:         %s\n", info.Name)
+	fmt.Fprintf(w, "Pages:        %d", info.PageCount)
+	if len(formats) > 0 {
+		fmt.Fprintf(w, " (%s)", strings.Join(formats, ", "))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Total size:   %s (average %s per page)\n", formatBytes(info.TotalSize), formatBytes(info.AverageSize))
+
+	if len(info.Spreads) > 0 {
+		pages := make([]string, len(info.Spreads))
+		for i, number := range info.Spreads {
+			pages[i] = fmt.Sprint(number)
+		}
+		fmt.Fprintf(w, "Spreads:      %s\n", strings.Join(pages, ", "))
+	}
+	if len(info.Skipped) > 0 {
+		fmt.Fprintf(w, "Skipped:      %s\n", strings.Join(info.Skipped, ", "))
+	}
+
+	if len(info.ComicInfo) > 0 {
+		fmt.Fprintln(w, "ComicInfo:")
+		for _, field := range info.ComicInfo {
+			fmt.Fprintf(w, "  %-12s%s\n", field.Name+":", field.Value)
+		}
+	}
+
+	if len(info.Pages) > 0 {
+		fmt.Fprintln(w, "Page list:")
+		for _, page := range info.Pages {
+			spread := ""
+			if page.Spread {
+				spread = "  spread"
+			}
+			fmt.Fprintf(w, "  %4d  %-30s %-5s %5dx%-5d %10s%s\n",
+				page.Number, page.Name, page.Format, page.Width, page.Height, formatBytes(page.Size), spread)
+		}
+	}
+
+	if len(info.Problems) > 0 {
+		fmt.Fprintln(w, "Problems:")
+		for _, problem := range info.Problems {
+			fmt.Fprintf(w, "  - %s\n", problem)
+		}
+	}
+}
+
+// formatBytes formats a size in bytes with a binary unit
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[exp])
+}
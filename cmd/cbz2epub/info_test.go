@@ -0,0 +1,117 @@
+package cbz2epub
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cbz2epub/cbz"
+	"cbz2epub/pdf"
+)
+
+// TestPrintInfo tests the printInfo function
+func TestPrintInfo(t *testing.T) {
+	info := &cbz.Info{
+		Name:        "test.cbz",
+		PageCount:   2,
+		Formats:     map[string]int{"png": 1, "jpeg": 1},
+		Pages:       []cbz.PageInfo{{Number: 1, Name: "001.jpg", Format: "jpeg", Width: 800, Height: 1200, Size: 2048}, {Number: 2, Name: "002.png", Format: "png", Width: 1600, Height: 1200, Size: 4096, Spread: true}},
+		Spreads:     []int{2},
+		Skipped:     []string{"readme.txt"},
+		TotalSize:   6144,
+		AverageSize: 3072,
+		ComicInfo:   []cbz.ComicInfoField{{Name: "Title", Value: "Test"}},
+		Problems:    []string{"page 2 (002.png) is corrupt"},
+	}
+
+	var out bytes.Buffer
+	printInfo(&out, info)
+
+	for _, s := range []string{
+		"Pages:        2 (jpeg: 1, png: 1)",
+		"Total size:   6.0 KiB (average 3.0 KiB per page)",
+		"Spreads:      2",
+		"Skipped:      readme.txt",
+		"  Title:      Test",
+		"800x1200",
+		"spread",
+		"  - page 2 (002.png) is corrupt",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("Output does not contain %q:\n%s", s, out.String())
+		}
+	}
+}
+
+// TestInspectPDF tests that skipped PDF pages are reported as problems
+func TestInspectPDF(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a test PDF file with two JPEG pages
+	var jpegData bytes.Buffer
+	if err := jpeg.Encode(&jpegData, image.NewRGBA(image.Rect(0, 0, 10, 10)), nil); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	testPDF := filepath.Join(tempDir, "test.pdf")
+	cbzFile := &cbz.File{
+		Name: testPDF,
+		Images: []cbz.Image{
+			{Name: "001.jpg", Data: jpegData.Bytes(), MimeType: "image/jpeg"},
+			{Name: "002.jpg", Data: jpegData.Bytes(), MimeType: "image/jpeg"},
+		},
+	}
+	if err := pdf.ConvertFromCBZ(cbzFile, testPDF); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	// Make the second page draw an image that doesn't exist
+	data, err := os.ReadFile(testPDF)
+	if err != nil {
+		t.Fatalf("Failed to read test PDF: %v", err)
+	}
+	last := bytes.LastIndex(data, []byte("/Im0 Do"))
+	copy(data[last:], "/Im9 Do")
+	if err := os.WriteFile(testPDF, data, 0644); err != nil {
+		t.Fatalf("Failed to write test PDF: %v", err)
+	}
+
+	info, err := inspectBook(testPDF)
+	if err != nil {
+		t.Fatalf("inspectBook failed: %v", err)
+	}
+	if info.PageCount != 1 {
+		t.Errorf("Expected 1 page, got %d", info.PageCount)
+	}
+	if len(info.Problems) != 1 || !strings.HasPrefix(info.Problems[0], "PDF page 2 is skipped: ") {
+		t.Errorf("Expected the skipped page as a problem, got %v", info.Problems)
+	}
+}
+
+// TestFormatBytes tests the formatBytes function
+func TestFormatBytes(t *testing.T) {
+	testCases := []struct {
+		size     int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+		{3 * 1024 * 1024 * 1024, "3.0 GiB"},
+	}
+
+	for _, tc := range testCases {
+		if got := formatBytes(tc.size); got != tc.expected {
+			t.Errorf("formatBytes(%d) = %q, expected %q", tc.size, got, tc.expected)
+		}
+	}
+}
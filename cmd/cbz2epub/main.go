@@ -1,26 +1,85 @@
 package cbz2epub
 
 import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"image"
+	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"cbz2epub/cbz"
 	"cbz2epub/epub"
+	"cbz2epub/panel"
+	"cbz2epub/pdf"
+	"cbz2epub/util"
 )
 
 // Config holds the application configuration
 type Config struct {
-	Merge      bool
-	Convert    bool
-	OutputFile string
-	Verbose    bool
-	Recursive  bool
-	InputFiles []string
+	Merge         bool
+	Convert       bool
+	Split         bool
+	Extract       bool
+	OutputFile    string
+	Verbose       bool
+	Recursive     bool
+	Format        string
+	MaxPages      int
+	MaxSize       float64
+	ByChapter     bool
+	SplitName     string
+	Panels        string
+	JSON          bool
+	Report        string
+	DryRun        bool
+	Overwrite     string
+	OutputDir     string
+	Flatten       bool
+	NameTemplate  string
+	NamePatterns  []string
+	NoSidecars    bool
+	Reproducible  bool
+	Identifier    string
+	Title         string
+	Author        string
+	Language      string
+	Series        string
+	SeriesIndex   string
+	Publisher     string
+	Description   string
+	Tags          string
+	ISBN          string
+	NoProgress    bool
+	Processed     string
+	Failed        string
+	StateFile     string
+	MergeFolders  bool
+	Settle        time.Duration
+	Interval      time.Duration
+	Poll          bool
+	Once          bool
+	Listen        string
+	MaxUpload     float64
+	Concurrency   int
+	MaxJobs       int
+	JobTTL        time.Duration
+	UploadTimeout time.Duration
+	InputFiles    []string
+
+	// progress shows the progress of the running command
+	progress *progressDisplay
 }
 
 // Execute runs the application
@@ -29,12 +88,34 @@ func Execute() error {
 	log.SetPrefix("[CBZ2EPUB] ")
 	log.SetFlags(log.LstdFlags)
 
+	// Don't leave partly written output files behind when interrupted
+	util.CleanupOnInterrupt()
+
+	// Run a subcommand unless the deprecated flag style is used
+	args := os.Args[1:]
+	if !isLegacyArgs(args) {
+		return runCommand(args)
+	}
+
 	// Parse command line flags
 	config := parseFlags()
 
+	// Only one command can run at a time
+	names := legacyCommands(config)
+	if len(names) > 1 {
+		return fmt.Errorf("the -%s flags cannot be combined, run one command at a time", strings.Join(names, ", -"))
+	}
+	if len(names) == 1 {
+		log.Printf("Warning: the -%s flag is deprecated, use \"cbz2epub %s\" instead\n", names[0], names[0])
+	}
+
 	// Process commands
 	if config.Merge {
 		return handleMergeCommand(config)
+	} else if config.Split {
+		return handleSplitCommand(config)
+	} else if config.Extract {
+		return handleExtractCommand(config)
 	} else if config.Convert {
 		return handleConvertCommand(config)
 	} else {
@@ -43,14 +124,23 @@ func Execute() error {
 	}
 }
 
-// parseFlags parses command line flags and returns a Config
+// parseFlags parses the deprecated command line flags, where the command is
+// selected with a flag such as -convert, and returns a Config
 func parseFlags() Config {
 	// Define command line flags
 	mergeCmd := flag.Bool("merge", false, "Merge multiple CBZ files into one")
 	convertCmd := flag.Bool("convert", false, "Convert CBZ to EPUB")
+	splitCmd := flag.Bool("split", false, "Split a CBZ file into volumes")
+	extractCmd := flag.Bool("extract", false, "Convert EPUB back to CBZ")
 	outputFile := flag.String("output", "", "Output file name")
 	verbose := flag.Bool("verbose", false, "Enable verbose output")
 	recursive := flag.Bool("recursive", false, "Process directories recursively")
+	format := flag.String("format", "", "Output format: epub, kepub, kindle or pdf (also cbz for split)")
+	maxPages := flag.Int("max-pages", 0, "Maximum number of pages per split volume")
+	maxSize := flag.Float64("max-size", 0, "Maximum size in MB per split volume")
+	byChapter := flag.Bool("by-chapter", false, "Split volumes at chapter boundaries")
+	splitName := flag.String("split-name", "{name}_part{part}", "Name template for split volumes ({name}, {part})")
+	panels := flag.String("panels", "", "Panel regions for panel view and guided reading: detect or grid")
 
 	flag.Parse()
 
@@ -59,8 +149,8 @@ func parseFlags() Config {
 
 	// If no input files specified, check if we should process current directory
 	if len(inputFiles) == 0 && *recursive {
-		// Get all CBZ files in current directory
-		files, err := filepath.Glob("*.cbz")
+		// Get all input files in current directory
+		files, err := findInputFiles(".")
 		if err == nil && len(files) > 0 {
 			inputFiles = files
 		}
@@ -69,9 +159,17 @@ func parseFlags() Config {
 	return Config{
 		Merge:      *mergeCmd,
 		Convert:    *convertCmd,
+		Split:      *splitCmd,
+		Extract:    *extractCmd,
 		OutputFile: *outputFile,
 		Verbose:    *verbose,
 		Recursive:  *recursive,
+		Format:     *format,
+		MaxPages:   *maxPages,
+		MaxSize:    *maxSize,
+		ByChapter:  *byChapter,
+		SplitName:  *splitName,
+		Panels:     *panels,
 		InputFiles: inputFiles,
 	}
 }
@@ -97,9 +195,35 @@ func handleMergeCommand(config Config) error {
 		log.Printf("Merging %d files into %s\n", len(config.InputFiles), outputFile)
 	}
 
-	// Merge files
-	err := cbz.MergeFiles(config.InputFiles, outputFile)
+	config.progress = newProgressDisplay(len(config.InputFiles), config)
+	defer config.progress.close()
+
+	// The first file gives the metadata and the name of the merged file
+	config.progress.startFile(config.InputFiles[0])
+	first, err := loadBook(config.InputFiles[0], config)
 	if err != nil {
+		log.Printf("Error merging CBZ files: %v", err)
+		return fmt.Errorf("failed to read input file %s: %w", config.InputFiles[0], err)
+	}
+	if config.NameTemplate != "" {
+		fields := templateFields(config.InputFiles[0], first.ComicInfo)
+		outputFile = filepath.Join(filepath.Dir(outputFile), expandName(config.NameTemplate, fields, ".cbz"))
+		if config.Verbose {
+			log.Printf("Writing merged file to %s\n", outputFile)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// Merge the files one at a time, so only one is held in memory
+	ctx := context.Background()
+	merger, err := cbz.NewMerger(outputFile, config.progress.update)
+	if err != nil {
+		return err
+	}
+	defer merger.Abort()
+	if err := mergeBooks(ctx, merger, first, config.InputFiles[1:], config); err != nil {
 		log.Printf("Error merging CBZ files: %v", err)
 		return err
 	}
@@ -108,6 +232,168 @@ func handleMergeCommand(config Config) error {
 	return nil
 }
 
+// mergeBooks adds a book and then the input files to a merged file, reading
+// one input file at a time, and commits it with the metadata of the book
+func mergeBooks(ctx context.Context, merger *cbz.Merger, first *cbz.File, inputFiles []string, config Config) error {
+	comicInfo := mergedComicInfo(first)
+	if err := merger.Add(ctx, first); err != nil {
+		return err
+	}
+	for _, inputFile := range inputFiles {
+		config.progress.startFile(inputFile)
+		cbzFile, err := loadBook(inputFile, config)
+		if err != nil {
+			return fmt.Errorf("failed to read input file %s: %w", inputFile, err)
+		}
+		if err := merger.Add(ctx, cbzFile); err != nil {
+			return err
+		}
+	}
+	if comicInfo != nil {
+		comicInfo.PageCount = merger.Pages()
+	}
+	return merger.Commit(comicInfo)
+}
+
+// handleSplitCommand handles the split command
+func handleSplitCommand(config Config) error {
+	if len(config.InputFiles) == 0 {
+		log.Println("No input files specified")
+		printUsage()
+		return fmt.Errorf("no input files specified")
+	}
+
+	format := strings.ToLower(config.Format)
+	if format == "" {
+		format = "cbz"
+	}
+	if format != "cbz" && format != "epub" && format != "kepub" && format != "kindle" && format != "pdf" {
+		return fmt.Errorf("unsupported split format: %s", config.Format)
+	}
+	if err := checkPanels(config.Panels, format); err != nil {
+		return err
+	}
+
+	opts := cbz.SplitOptions{
+		MaxPages:  config.MaxPages,
+		MaxBytes:  int64(config.MaxSize * 1024 * 1024),
+		ByChapter: config.ByChapter,
+	}
+
+	var splitError error
+	config.progress = newProgressDisplay(len(config.InputFiles), config)
+	defer config.progress.close()
+
+	// Process each input file
+	for _, inputFile := range config.InputFiles {
+		config.progress.startFile(inputFile)
+		cbzFile, err := loadBook(inputFile, config)
+		if err != nil {
+			log.Printf("Error reading %s: %v\n", inputFile, err)
+			splitError = err
+			continue
+		}
+
+		if err := addPanels(cbzFile, config.Panels); err != nil {
+			log.Printf("Error finding panels in %s: %v\n", inputFile, err)
+			splitError = err
+			continue
+		}
+
+		parts, err := cbz.Split(cbzFile, opts)
+		if err != nil {
+			log.Printf("Error splitting %s: %v\n", inputFile, err)
+			splitError = err
+			continue
+		}
+
+		if config.Verbose {
+			log.Printf("Splitting %s into %d volumes\n", inputFile, len(parts))
+		}
+
+		for i, part := range parts {
+			outputFile := splitOutputName(inputFile, config.SplitName, formatExtension(format), cbzFile.ComicInfo, i+1, len(parts))
+			part.Name = outputFile
+
+			if config.Verbose {
+				log.Printf("Writing %d pages to %s\n", len(part.Images), outputFile)
+			}
+
+			err = writeBook(part, outputFile, format, epub.Options{Reproducible: config.Reproducible, Progress: config.progress.update})
+			if err != nil {
+				log.Printf("Error writing %s: %v\n", outputFile, err)
+				splitError = err
+				break
+			}
+		}
+		if err != nil {
+			continue
+		}
+
+		log.Printf("Successfully split %s into %d volumes\n", inputFile, len(parts))
+	}
+
+	return splitError
+}
+
+// splitOutputName builds the output path of a split volume from the name
+// template. The part number is zero-padded to the width of the total count.
+func splitOutputName(inputFile, template, ext string, comicInfo *cbz.ComicInfo, part, total int) string {
+	if template == "" {
+		template = "{name}_part{part}"
+	}
+
+	width := len(strconv.Itoa(total))
+	if width < 2 {
+		width = 2
+	}
+
+	fields := templateFields(inputFile, comicInfo)
+	fields["part"] = fmt.Sprintf("%0*d", width, part)
+	return filepath.Join(filepath.Dir(inputFile), expandName(template, fields, ext))
+}
+
+// handleExtractCommand handles the extract command
+func handleExtractCommand(config Config) error {
+	if len(config.InputFiles) == 0 {
+		log.Println("No input files specified")
+		printUsage()
+		return fmt.Errorf("no input files specified")
+	}
+
+	var extractionError error
+
+	// Process each input file
+	for _, inputFile := range config.InputFiles {
+		if !strings.HasSuffix(strings.ToLower(inputFile), ".epub") {
+			log.Printf("Skipping non-EPUB file: %s\n", inputFile)
+			continue
+		}
+
+		// Set output file name
+		outputFile := config.OutputFile
+		if outputFile == "" || len(config.InputFiles) > 1 {
+			outputFile = strings.TrimSuffix(inputFile, filepath.Ext(inputFile)) + ".cbz"
+		}
+
+		if config.Verbose {
+			log.Printf("Extracting %s to %s\n", inputFile, outputFile)
+		}
+
+		// Extract file
+		err := epub.ExtractFile(inputFile, outputFile)
+		if err != nil {
+			log.Printf("Error extracting %s: %v\n", inputFile, err)
+			extractionError = err
+			continue
+		}
+
+		log.Printf("Successfully extracted %s to %s\n", inputFile, outputFile)
+	}
+
+	return extractionError
+}
+
 // handleConvertCommand handles the convert command
 func handleConvertCommand(config Config) error {
 	if len(config.InputFiles) == 0 {
@@ -116,47 +402,86 @@ func handleConvertCommand(config Config) error {
 		return fmt.Errorf("no input files specified")
 	}
 
+	format, err := convertFormat(config)
+	if err != nil {
+		return err
+	}
+	if err := checkPanels(config.Panels, format); err != nil {
+		return err
+	}
+	if !isOverwritePolicy(config.Overwrite) {
+		return fmt.Errorf("unsupported overwrite policy: %s", config.Overwrite)
+	}
+
 	var conversionError error
+	report := newConversionReport()
+	config.progress = newProgressDisplay(countInputFiles(config), config)
+	defer config.progress.close()
 
 	// Process each input file
 	for _, inputFile := range config.InputFiles {
 		// Check if it's a directory
+		start := time.Now()
 		fileInfo, err := os.Stat(inputFile)
 		if err != nil {
 			log.Printf("Error accessing %s: %v\n", inputFile, err)
+			report.add(inputFile, "", 0, start, err)
 			conversionError = err
 			continue
 		}
 
 		if fileInfo.IsDir() {
 			if config.Recursive {
-				if err := processDirectory(inputFile, config); err != nil {
+				if err := processDirectory(inputFile, inputFile, config, report); err != nil {
 					conversionError = err
 				}
 			} else {
 				log.Printf("Skipping directory %s (use -recursive to process directories)\n", inputFile)
+				report.skip(inputFile, "directory without -recursive")
 			}
 			continue
 		}
 
 		// Process single file
-		if !strings.HasSuffix(strings.ToLower(inputFile), ".cbz") {
+		if !isInputFile(inputFile) {
 			log.Printf("Skipping non-CBZ file: %s\n", inputFile)
+			report.skip(inputFile, "not a CBZ or PDF file")
 			continue
 		}
+		config.progress.startFile(inputFile)
 
 		// Set output file name
 		outputFile := config.OutputFile
 		if outputFile == "" || len(config.InputFiles) > 1 {
-			outputFile = strings.TrimSuffix(inputFile, ".cbz") + ".epub"
+			outputFile = outputPath(inputFile, "", format, config)
+		}
+		if err := report.claim(inputFile, outputFile); err != nil {
+			log.Printf("Error converting %s: %v\n", inputFile, err)
+			report.add(inputFile, outputFile, 0, start, err)
+			conversionError = err
+			continue
+		}
+
+		// Keep existing output files according to the overwrite policy
+		if reason := skipReason(inputFile, outputFile, config.Overwrite); reason != "" {
+			log.Printf("Skipping %s: %s\n", inputFile, reason)
+			report.skip(inputFile, reason)
+			continue
 		}
 
 		if config.Verbose {
 			log.Printf("Converting %s to %s\n", inputFile, outputFile)
 		}
 
+		if config.DryRun {
+			overwrite, err := checkConversion(inputFile, outputFile)
+			report.plan(inputFile, outputFile, overwrite, err)
+			continue
+		}
+
 		// Convert file
-		err = epub.ConvertFile(inputFile, outputFile)
+		pages, err := convertFile(inputFile, outputFile, format, config)
+		report.add(inputFile, outputFile, pages, start, err)
 		if err != nil {
 			log.Printf("Error converting %s: %v\n", inputFile, err)
 			conversionError = err
@@ -166,38 +491,80 @@ func handleConvertCommand(config Config) error {
 		log.Printf("Successfully converted %s to %s\n", inputFile, outputFile)
 	}
 
+	// A dry run only shows the plan, it doesn't write a report file
+	if config.DryRun {
+		if config.JSON {
+			return report.encode(os.Stdout)
+		}
+		report.printPlan(os.Stdout)
+		return nil
+	}
+
+	if err := report.write(config); err != nil {
+		return err
+	}
+
 	return conversionError
 }
 
-// processDirectory processes all CBZ files in a directory
-func processDirectory(dirPath string, config Config) error {
+// processDirectory processes all input files in a directory, recording the
+// results in the report. The root directory is the directory given on the
+// command line, whose tree is mirrored in the output directory.
+func processDirectory(dirPath, rootDir string, config Config, report *conversionReport) error {
 	if config.Verbose {
 		log.Printf("Processing directory: %s\n", dirPath)
 	}
 
+	format, err := convertFormat(config)
+	if err != nil {
+		return err
+	}
+
 	var processingError error
 
-	// Find all CBZ files in the directory
-	files, err := filepath.Glob(filepath.Join(dirPath, "*.cbz"))
+	// Find all input files in the directory
+	files, err := findInputFiles(dirPath)
 	if err != nil {
-		log.Printf("Error finding CBZ files in %s: %v\n", dirPath, err)
+		log.Printf("Error finding input files in %s: %v\n", dirPath, err)
 		return err
 	}
 
+	// Subdirectories are still processed when there are no files
 	if len(files) == 0 {
-		log.Printf("No CBZ files found in %s\n", dirPath)
-		return nil
+		log.Printf("No CBZ or PDF files found in %s\n", dirPath)
 	}
 
 	// Process each file
 	for _, file := range files {
-		outputFile := strings.TrimSuffix(file, ".cbz") + ".epub"
+		config.progress.startFile(file)
+		outputFile := outputPath(file, rootDir, format, config)
+		if err := report.claim(file, outputFile); err != nil {
+			log.Printf("Error converting %s: %v\n", file, err)
+			report.add(file, outputFile, 0, time.Now(), err)
+			processingError = err
+			continue
+		}
+
+		// Keep existing output files according to the overwrite policy
+		if reason := skipReason(file, outputFile, config.Overwrite); reason != "" {
+			log.Printf("Skipping %s: %s\n", file, reason)
+			report.skip(file, reason)
+			continue
+		}
 
 		if config.Verbose {
 			log.Printf("Converting %s to %s\n", file, outputFile)
 		}
 
-		err := epub.ConvertFile(file, outputFile)
+		if config.DryRun {
+			overwrite, err := checkConversion(file, outputFile)
+			report.plan(file, outputFile, overwrite, err)
+			continue
+		}
+
+		start := time.Now()
+		pages, err := convertFile(file, outputFile, format, config)
+		report.add(file, outputFile, pages, start, err)
 		if err != nil {
 			log.Printf("Error converting %s: %v\n", file, err)
 			processingError = err
@@ -217,7 +584,7 @@ func processDirectory(dirPath string, config Config) error {
 
 		for _, subdir := range subdirs {
 			if subdir.IsDir() {
-				if err := processDirectory(filepath.Join(dirPath, subdir.Name()), config); err != nil && processingError == nil {
+				if err := processDirectory(filepath.Join(dirPath, subdir.Name()), rootDir, config, report); err != nil && processingError == nil {
 					processingError = err
 				}
 			}
@@ -227,13 +594,329 @@ func processDirectory(dirPath string, config Config) error {
 	return processingError
 }
 
+// outputPath returns the output file of an input file, named after the name
+// template. Files found in the root directory keep their path relative to it
+// under the output directory, unless the output is flattened.
+func outputPath(inputFile, rootDir, format string, config Config) string {
+	template := config.NameTemplate
+	if template == "" {
+		template = "{name}"
+	}
+
+	// Input files without readable metadata only get the file name fields,
+	// their conversion reports the error
+	var comicInfo *cbz.ComicInfo
+	if usesMetadata(template) {
+		comicInfo, _ = readMetadata(inputFile, config)
+	}
+	name := expandName(template, templateFields(inputFile, comicInfo), formatExtension(format))
+
+	dir := filepath.Dir(inputFile)
+	if config.OutputDir != "" {
+		dir = config.OutputDir
+		if rootDir != "" && !config.Flatten {
+			if rel, err := filepath.Rel(rootDir, filepath.Dir(inputFile)); err == nil {
+				dir = filepath.Join(config.OutputDir, rel)
+			}
+		}
+	}
+	return filepath.Join(dir, name)
+}
+
+// convertFormat returns the output format of the convert command
+func convertFormat(config Config) (string, error) {
+	format := strings.ToLower(config.Format)
+	if format == "" {
+		return "epub", nil
+	}
+	if format != "epub" && format != "kepub" && format != "kindle" && format != "pdf" {
+		return "", fmt.Errorf("unsupported output format: %s", config.Format)
+	}
+	return format, nil
+}
+
+// formatExtension returns the file extension of an output format. Kobo
+// devices only use their own renderer for files named .kepub.epub.
+func formatExtension(format string) string {
+	switch format {
+	case "kepub":
+		return ".kepub.epub"
+	case "kindle":
+		return ".epub"
+	}
+	return "." + format
+}
+
+// convertFile converts a CBZ or PDF file to the given output format,
+// adding panel regions with the given panel mode. It returns the number of
+// pages read from the input file.
+func convertFile(inputFile, outputFile, format string, config Config) (int, error) {
+	if filepath.Clean(inputFile) == filepath.Clean(outputFile) {
+		return 0, fmt.Errorf("output file %s would overwrite the input file", outputFile)
+	}
+
+	// Read the input file
+	cbzFile, err := loadBook(inputFile, config)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", inputFile, err)
+	}
+	pages := len(cbzFile.Images)
+
+	// Output directories are created as needed
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		return pages, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// Find the panels used for Kindle panel view
+	if err := addPanels(cbzFile, config.Panels); err != nil {
+		return pages, fmt.Errorf("failed to find panels in %s: %w", inputFile, err)
+	}
+
+	// Record the source in the output, so later runs can tell if it changed
+	sourceHash, err := fileHash(inputFile)
+	if err != nil {
+		return pages, err
+	}
+
+	// Convert to the output format
+	err = writeBook(cbzFile, outputFile, format, epub.Options{
+		SourceHash:   sourceHash,
+		Reproducible: config.Reproducible,
+		Identifier:   bookIdentifier(config),
+		Progress:     config.progress.update,
+	})
+	if err != nil {
+		return pages, fmt.Errorf("failed to convert to %s: %w", strings.ToUpper(format), err)
+	}
+
+	return pages, nil
+}
+
+// checkConversion checks that an input file can be opened and converted to
+// the output file without converting it. It reports whether the output file
+// already exists.
+func checkConversion(inputFile, outputFile string) (bool, error) {
+	if filepath.Clean(inputFile) == filepath.Clean(outputFile) {
+		return false, fmt.Errorf("output file %s would overwrite the input file", outputFile)
+	}
+
+	if strings.ToLower(filepath.Ext(inputFile)) == ".pdf" {
+		file, err := os.Open(inputFile)
+		if err != nil {
+			return false, fmt.Errorf("failed to open PDF file: %w", err)
+		}
+		header := make([]byte, 5)
+		_, err = io.ReadFull(file, header)
+		file.Close()
+		if err != nil || string(header) != "%PDF-" {
+			return false, fmt.Errorf("%s is not a PDF file", inputFile)
+		}
+	} else {
+		zipReader, err := zip.OpenReader(inputFile)
+		if err != nil {
+			return false, fmt.Errorf("failed to open CBZ file: %w", err)
+		}
+		zipReader.Close()
+	}
+
+	_, err := os.Stat(outputFile)
+	return err == nil, nil
+}
+
+// readBook reads a CBZ file or an image-based PDF file, reporting the
+// pages read to progress. PDF pages that don't consist of a single image
+// are logged and skipped.
+func readBook(inputFile string, progress cbz.ProgressFunc) (*cbz.File, error) {
+	if strings.ToLower(filepath.Ext(inputFile)) != ".pdf" {
+		return cbz.ReadFileContext(context.Background(), inputFile, progress)
+	}
+
+	cbzFile, err := pdf.ReadFileContext(context.Background(), inputFile, progress)
+	var pageErr *pdf.PageError
+	if errors.As(err, &pageErr) && cbzFile != nil {
+		log.Printf("Warning: %s: %v\n", inputFile, err)
+		return cbzFile, nil
+	}
+	return cbzFile, err
+}
+
+// countInputFiles counts the input files the convert command converts:
+// the CBZ and PDF files given on the command line, and the ones in
+// directories if they are processed recursively
+func countInputFiles(config Config) int {
+	count := 0
+	for _, inputFile := range config.InputFiles {
+		info, err := os.Stat(inputFile)
+		switch {
+		case err != nil:
+		case !info.IsDir():
+			if isInputFile(inputFile) {
+				count++
+			}
+		case config.Recursive:
+			filepath.WalkDir(inputFile, func(path string, entry fs.DirEntry, err error) error {
+				if err == nil && !entry.IsDir() && isInputFile(path) {
+					count++
+				}
+				return nil
+			})
+		}
+	}
+	return count
+}
+
+// isInputFile checks if a file can be read by readBook
+func isInputFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".cbz" || ext == ".pdf"
+}
+
+// findInputFiles returns the CBZ and PDF files in a directory, sorted by name
+func findInputFiles(dirPath string) ([]string, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && isInputFile(entry.Name()) {
+			files = append(files, filepath.Join(dirPath, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// writeBook writes the images of a CBZ file in the given output format.
+// EPUB files are written with the options, with the profile of the format;
+// the other formats only use the progress of the options.
+func writeBook(cbzFile *cbz.File, outputFile, format string, opts epub.Options) error {
+	ctx := context.Background()
+	switch format {
+	case "epub":
+		return epub.ConvertContext(ctx, cbzFile, outputFile, opts)
+	case "kepub":
+		opts.Profile = epub.ProfileKobo
+		return epub.ConvertContext(ctx, cbzFile, outputFile, opts)
+	case "kindle":
+		opts.Profile = epub.ProfileKindle
+		return epub.ConvertContext(ctx, cbzFile, outputFile, opts)
+	case "pdf":
+		return pdf.ConvertContext(ctx, cbzFile, outputFile, opts.Progress)
+	default:
+		return cbz.WriteFileContext(ctx, cbzFile, outputFile, opts.Progress)
+	}
+}
+
+// isOverwritePolicy checks if an overwrite policy is supported by skipReason
+func isOverwritePolicy(policy string) bool {
+	return policy == "" || policy == "always" || policy == "never" || policy == "newer"
+}
+
+// skipReason returns why an input file is not converted under the overwrite
+// policy, or an empty string if it is converted. The newer policy skips
+// inputs whose output file is newer, or whose output file was converted from
+// the same content according to its recorded source hash.
+func skipReason(inputFile, outputFile, policy string) string {
+	if policy == "" || policy == "always" {
+		return ""
+	}
+	outputInfo, err := os.Stat(outputFile)
+	if err != nil {
+		return ""
+	}
+	if policy == "never" {
+		return "output file exists"
+	}
+
+	inputInfo, err := os.Stat(inputFile)
+	if err != nil {
+		return ""
+	}
+	if !outputInfo.ModTime().Before(inputInfo.ModTime()) {
+		return "output file is newer than the input file"
+	}
+
+	// Only EPUB files record the source hash
+	if !strings.HasSuffix(strings.ToLower(outputFile), ".epub") {
+		return ""
+	}
+	recorded, err := epub.SourceHash(outputFile)
+	if err != nil || recorded == "" {
+		return ""
+	}
+	if hash, err := fileHash(inputFile); err == nil && hash == recorded {
+		return "output file was converted from the same content"
+	}
+	return ""
+}
+
+// fileHash returns the SHA-256 hash of a file's content
+func fileHash(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// checkPanels checks the panel mode for an output format. Only Kindle and
+// KEPUB output use the panels, so other formats can't have a panel mode.
+func checkPanels(mode, format string) error {
+	if !isPanelMode(mode) {
+		return fmt.Errorf("unsupported panel mode: %s", mode)
+	}
+	if mode != "" && format != "kepub" && format != "kindle" {
+		return fmt.Errorf("-panels is only supported for the kepub and kindle formats, not %s", format)
+	}
+	return nil
+}
+
+// isPanelMode checks if a panel mode is supported by addPanels
+func isPanelMode(mode string) bool {
+	return mode == "" || mode == "none" || mode == "detect" || mode == "grid"
+}
+
+// addPanels sets the panel regions of every page. The detect mode finds the
+// panels from the gutters of each page, the grid mode divides each page into
+// four quarters in reading order.
+func addPanels(cbzFile *cbz.File, mode string) error {
+	switch mode {
+	case "detect":
+		for i := range cbzFile.Images {
+			img, _, err := cbzFile.Images[i].Decode()
+			if err != nil {
+				return err
+			}
+			cbzFile.Images[i].Panels = panel.Detect(img, cbzFile.RightToLeft())
+		}
+	case "grid":
+		for i := range cbzFile.Images {
+			config, _, err := cbzFile.Images[i].DecodeConfig()
+			if err != nil {
+				return err
+			}
+			bounds := image.Rect(0, 0, config.Width, config.Height)
+			cbzFile.Images[i].Panels = panel.Grid(bounds, 2, 2, cbzFile.RightToLeft())
+		}
+	}
+	return nil
+}
+
 // printUsage prints the usage information
 func printUsage() {
 	fmt.Println("CBZ2EPUB - A tool for merging CBZ files and converting them to EPUB")
 	fmt.Println("\nUsage:")
-	fmt.Println("  cbz2epub -merge [-output filename.cbz] file1.cbz file2.cbz ...")
-	fmt.Println("  cbz2epub -convert [-output filename.epub] file.cbz")
-	fmt.Println("  cbz2epub -convert -recursive [directory]")
-	fmt.Println("\nOptions:")
-	flag.PrintDefaults()
+	fmt.Println("  cbz2epub <command> [options] file ...")
+	fmt.Println("\nCommands:")
+	for _, cmd := range commands {
+		fmt.Printf("  %-10s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Println("\nRun \"cbz2epub help <command>\" for the options of a command.")
+	fmt.Println("The old flag style, such as \"cbz2epub -convert file.cbz\", still works but is deprecated.")
 }
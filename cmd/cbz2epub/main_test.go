@@ -2,10 +2,20 @@ package cbz2epub
 
 import (
 	"archive/zip"
+	"bytes"
 	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"cbz2epub/cbz"
+	"cbz2epub/epub"
+	"cbz2epub/pdf"
 )
 
 // TestParseFlags tests the parseFlags function
@@ -97,6 +107,16 @@ func TestParseFlags(t *testing.T) {
 				InputFiles: []string{"directory"},
 			},
 		},
+		{
+			name: "split command",
+			args: []string{"cbz2epub", "-split", "-max-pages", "50", "-format", "epub", "file.cbz"},
+			expectedConfig: Config{
+				Split:      true,
+				Format:     "epub",
+				MaxPages:   50,
+				InputFiles: []string{"file.cbz"},
+			},
+		},
 		{
 			name: "no command",
 			args: []string{"cbz2epub"},
@@ -137,6 +157,15 @@ func TestParseFlags(t *testing.T) {
 			if config.Recursive != tc.expectedConfig.Recursive {
 				t.Errorf("Expected Recursive=%v, got %v", tc.expectedConfig.Recursive, config.Recursive)
 			}
+			if config.Split != tc.expectedConfig.Split {
+				t.Errorf("Expected Split=%v, got %v", tc.expectedConfig.Split, config.Split)
+			}
+			if config.Format != tc.expectedConfig.Format {
+				t.Errorf("Expected Format=%v, got %v", tc.expectedConfig.Format, config.Format)
+			}
+			if config.MaxPages != tc.expectedConfig.MaxPages {
+				t.Errorf("Expected MaxPages=%v, got %v", tc.expectedConfig.MaxPages, config.MaxPages)
+			}
 			if len(config.InputFiles) != len(tc.expectedConfig.InputFiles) {
 				t.Errorf("Expected %d input files, got %d", len(tc.expectedConfig.InputFiles), len(config.InputFiles))
 			} else {
@@ -353,6 +382,65 @@ func TestHandleConvertCommand(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "convert to pdf",
+			config: Config{
+				Convert:    true,
+				Format:     "pdf",
+				OutputFile: filepath.Join(tempDir, "test.pdf"),
+				InputFiles: []string{testFile},
+			},
+			expectError: true, // The fake image data can't be embedded in a PDF
+		},
+		{
+			name: "convert to kepub",
+			config: Config{
+				Convert:    true,
+				Format:     "kepub",
+				InputFiles: []string{testFile},
+			},
+			expectError: false,
+		},
+		{
+			name: "convert to kindle",
+			config: Config{
+				Convert:    true,
+				Format:     "kindle",
+				OutputFile: filepath.Join(tempDir, "kindle.epub"),
+				InputFiles: []string{testFile},
+			},
+			expectError: false,
+		},
+		{
+			name: "convert to kindle with grid panels",
+			config: Config{
+				Convert:    true,
+				Format:     "kindle",
+				Panels:     "grid",
+				OutputFile: filepath.Join(tempDir, "kindle.epub"),
+				InputFiles: []string{testFile},
+			},
+			expectError: true, // The fake image data has no size to divide
+		},
+		{
+			name: "convert with unsupported panel mode",
+			config: Config{
+				Convert:    true,
+				Format:     "kindle",
+				Panels:     "magic",
+				InputFiles: []string{testFile},
+			},
+			expectError: true,
+		},
+		{
+			name: "convert with unsupported format",
+			config: Config{
+				Convert:    true,
+				Format:     "mobi",
+				InputFiles: []string{testFile},
+			},
+			expectError: true,
+		},
 		{
 			name: "convert with directory",
 			config: Config{
@@ -385,6 +473,317 @@ func TestHandleConvertCommand(t *testing.T) {
 			}
 		})
 	}
+
+	// A failed conversion must not leave a partly written file
+	if _, err := os.Stat(filepath.Join(tempDir, "test.pdf")); !os.IsNotExist(err) {
+		t.Errorf("Failed PDF conversion left an output file")
+	}
+
+	// Check that the KEPUB output has the name Kobo devices expect
+	if _, err := os.Stat(filepath.Join(tempDir, "test.kepub.epub")); os.IsNotExist(err) {
+		t.Errorf("KEPUB output file does not exist")
+	}
+}
+
+// TestHandleSplitCommand tests the handleSplitCommand function
+func TestHandleSplitCommand(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a test CBZ file with five pages
+	testFile := filepath.Join(tempDir, "test.cbz")
+	zipFile, err := os.Create(testFile)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	zipWriter := zip.NewWriter(zipFile)
+	for i := 1; i <= 5; i++ {
+		writer, err := zipWriter.Create(fmt.Sprintf("image%d.jpg", i))
+		if err != nil {
+			t.Fatalf("Failed to create image in test zip: %v", err)
+		}
+		if _, err := writer.Write([]byte("fake image data")); err != nil {
+			t.Fatalf("Failed to write image data in test zip: %v", err)
+		}
+	}
+	zipWriter.Close()
+	zipFile.Close()
+
+	// Test cases
+	testCases := []struct {
+		name          string
+		config        Config
+		expectError   bool
+		expectedFiles []string
+	}{
+		{
+			name: "split to cbz",
+			config: Config{
+				Split:      true,
+				MaxPages:   2,
+				InputFiles: []string{testFile},
+			},
+			expectedFiles: []string{"test_part01.cbz", "test_part02.cbz", "test_part03.cbz"},
+		},
+		{
+			name: "split to epub with name template",
+			config: Config{
+				Split:      true,
+				Format:     "epub",
+				MaxPages:   3,
+				SplitName:  "vol{part}",
+				InputFiles: []string{testFile},
+			},
+			expectedFiles: []string{"vol01.epub", "vol02.epub"},
+		},
+		{
+			name: "split without criteria",
+			config: Config{
+				Split:      true,
+				InputFiles: []string{testFile},
+			},
+			expectError: true,
+		},
+		{
+			name: "split with unsupported format",
+			config: Config{
+				Split:      true,
+				Format:     "mobi",
+				MaxPages:   2,
+				InputFiles: []string{testFile},
+			},
+			expectError: true,
+		},
+		{
+			name: "split with no input files",
+			config: Config{
+				Split:    true,
+				MaxPages: 2,
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := handleSplitCommand(tc.config)
+			if tc.expectError && err == nil {
+				t.Errorf("Expected error, got nil")
+			} else if !tc.expectError && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+
+			for _, file := range tc.expectedFiles {
+				if _, err := os.Stat(filepath.Join(tempDir, file)); os.IsNotExist(err) {
+					t.Errorf("Output file does not exist: %s", file)
+				}
+			}
+		})
+	}
+}
+
+// TestHandleExtractCommand tests the handleExtractCommand function
+func TestHandleExtractCommand(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a test EPUB file from a CBZ file
+	testEPUB := filepath.Join(tempDir, "test.epub")
+	cbzFile := &cbz.File{
+		Name:   filepath.Join(tempDir, "test.cbz"),
+		Images: []cbz.Image{{Name: "image.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}},
+	}
+	if err := epub.ConvertFromCBZ(cbzFile, testEPUB); err != nil {
+		t.Fatalf("Failed to create test EPUB: %v", err)
+	}
+
+	// Test cases
+	testCases := []struct {
+		name        string
+		config      Config
+		expectError bool
+		expectedOut string
+	}{
+		{
+			name: "extract with output",
+			config: Config{
+				Extract:    true,
+				OutputFile: filepath.Join(tempDir, "out.cbz"),
+				InputFiles: []string{testEPUB},
+			},
+			expectedOut: filepath.Join(tempDir, "out.cbz"),
+		},
+		{
+			name: "extract without output",
+			config: Config{
+				Extract:    true,
+				InputFiles: []string{testEPUB},
+			},
+			expectedOut: filepath.Join(tempDir, "test.cbz"),
+		},
+		{
+			name: "extract with no input files",
+			config: Config{
+				Extract:    true,
+				InputFiles: []string{},
+			},
+			expectError: true,
+		},
+		{
+			name: "extract with non-existent input file",
+			config: Config{
+				Extract:    true,
+				InputFiles: []string{filepath.Join(tempDir, "nonexistent.epub")},
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := handleExtractCommand(tc.config)
+			if tc.expectError && err == nil {
+				t.Errorf("Expected error, got nil")
+			} else if !tc.expectError && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+
+			if tc.expectedOut != "" {
+				if _, err := os.Stat(tc.expectedOut); os.IsNotExist(err) {
+					t.Errorf("Output file does not exist: %s", tc.expectedOut)
+				}
+			}
+		})
+	}
+}
+
+// TestReadBook tests reading CBZ and PDF input files
+func TestReadBook(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a test PDF file with a JPEG page
+	var jpegData bytes.Buffer
+	if err := jpeg.Encode(&jpegData, image.NewRGBA(image.Rect(0, 0, 10, 10)), nil); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	testPDF := filepath.Join(tempDir, "test.pdf")
+	cbzFile := &cbz.File{
+		Name:   testPDF,
+		Images: []cbz.Image{{Name: "image.jpg", Data: jpegData.Bytes(), MimeType: "image/jpeg"}},
+	}
+	if err := pdf.ConvertFromCBZ(cbzFile, testPDF); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	// Read the PDF file
+	book, err := readBook(testPDF, nil)
+	if err != nil {
+		t.Fatalf("readBook failed: %v", err)
+	}
+	if len(book.Images) != 1 || !bytes.Equal(book.Images[0].Data, jpegData.Bytes()) {
+		t.Errorf("Unexpected images read from PDF")
+	}
+
+	// Convert the PDF file to EPUB
+	if _, err := convertFile(testPDF, filepath.Join(tempDir, "test.epub"), "epub", Config{}); err != nil {
+		t.Errorf("convertFile failed: %v", err)
+	}
+
+	// Converting to the input file itself must fail
+	if _, err := convertFile(testPDF, testPDF, "pdf", Config{}); err == nil {
+		t.Errorf("convertFile should fail when the output is the input file")
+	}
+
+	// Merge the PDF file with itself
+	config := Config{
+		Merge:      true,
+		OutputFile: filepath.Join(tempDir, "merged.cbz"),
+		InputFiles: []string{testPDF, testPDF},
+	}
+	if err := handleMergeCommand(config); err != nil {
+		t.Errorf("handleMergeCommand failed: %v", err)
+	}
+}
+
+// TestAddPanels tests the addPanels function
+func TestAddPanels(t *testing.T) {
+	// Create a page with two panels stacked on top of each other
+	page := image.NewRGBA(image.Rect(0, 0, 200, 300))
+	draw.Draw(page, page.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(page, image.Rect(10, 10, 190, 140), image.Black, image.Point{}, draw.Src)
+	draw.Draw(page, image.Rect(10, 160, 190, 290), image.Black, image.Point{}, draw.Src)
+	var jpegData bytes.Buffer
+	if err := jpeg.Encode(&jpegData, page, nil); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+
+	testCases := []struct {
+		mode     string
+		expected int
+	}{
+		{mode: "", expected: 0},
+		{mode: "detect", expected: 2},
+		{mode: "grid", expected: 4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.mode, func(t *testing.T) {
+			cbzFile := &cbz.File{
+				Name:   "test.cbz",
+				Images: []cbz.Image{{Name: "001.jpg", Data: jpegData.Bytes(), MimeType: "image/jpeg"}},
+			}
+			if err := addPanels(cbzFile, tc.mode); err != nil {
+				t.Fatalf("addPanels failed: %v", err)
+			}
+			if len(cbzFile.Images[0].Panels) != tc.expected {
+				t.Errorf("Expected %d panels, got %v", tc.expected, cbzFile.Images[0].Panels)
+			}
+		})
+	}
+
+	// Undecodable images can't be searched for panels
+	cbzFile := &cbz.File{
+		Name:   "test.cbz",
+		Images: []cbz.Image{{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}},
+	}
+	if err := addPanels(cbzFile, "detect"); err == nil {
+		t.Errorf("Expected error for undecodable image, got nil")
+	}
+}
+
+// TestCheckPanels tests that panels are only used with formats that show them
+func TestCheckPanels(t *testing.T) {
+	testCases := []struct {
+		mode        string
+		format      string
+		expectError bool
+	}{
+		{mode: "", format: "epub"},
+		{mode: "detect", format: "kindle"},
+		{mode: "grid", format: "kepub"},
+		{mode: "detect", format: "epub", expectError: true},
+		{mode: "grid", format: "pdf", expectError: true},
+		{mode: "grid", format: "cbz", expectError: true},
+		{mode: "magic", format: "kindle", expectError: true},
+	}
+	for _, tc := range testCases {
+		if err := checkPanels(tc.mode, tc.format); (err != nil) != tc.expectError {
+			t.Errorf("checkPanels(%q, %q) returned %v, expected error %t", tc.mode, tc.format, err, tc.expectError)
+		}
+	}
 }
 
 // TestExecute is a placeholder test for the Execute function
@@ -396,3 +795,220 @@ func TestExecute(t *testing.T) {
 	// The actual functionality is tested in other tests
 	t.Skip("Skipping TestExecute as it requires complex mocking")
 }
+
+// TestSkipReason tests the overwrite policies
+func TestSkipReason(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputFile := filepath.Join(tempDir, "test.cbz")
+	cbzFile := &cbz.File{
+		Name:   inputFile,
+		Images: []cbz.Image{{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}},
+	}
+	if err := cbz.WriteFile(cbzFile, inputFile); err != nil {
+		t.Fatalf("Failed to create test CBZ file: %v", err)
+	}
+	outputFile := filepath.Join(tempDir, "test.epub")
+
+	// Without an output file nothing is skipped
+	for _, policy := range []string{"always", "never", "newer"} {
+		if reason := skipReason(inputFile, outputFile, policy); reason != "" {
+			t.Errorf("Expected no skip with policy %s and no output file, got %q", policy, reason)
+		}
+	}
+
+	if _, err := convertFile(inputFile, outputFile, "epub", Config{}); err != nil {
+		t.Fatalf("convertFile failed: %v", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(inputFile, old, old); err != nil {
+		t.Fatalf("Failed to change file times: %v", err)
+	}
+
+	testCases := []struct {
+		name       string
+		policy     string
+		inputTime  time.Time
+		changeData bool
+		expectSkip bool
+	}{
+		{name: "always", policy: "always", expectSkip: false},
+		{name: "never", policy: "never", expectSkip: true},
+		{name: "newer output", policy: "newer", expectSkip: true},
+		{name: "newer input with same content", policy: "newer", inputTime: time.Now().Add(time.Hour), expectSkip: true},
+		{name: "newer input with changed content", policy: "newer", inputTime: time.Now().Add(time.Hour), changeData: true, expectSkip: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.changeData {
+				cbzFile.Images[0].Data = []byte("changed image data")
+				if err := cbz.WriteFile(cbzFile, inputFile); err != nil {
+					t.Fatalf("Failed to rewrite test CBZ file: %v", err)
+				}
+			}
+			if !tc.inputTime.IsZero() {
+				if err := os.Chtimes(inputFile, tc.inputTime, tc.inputTime); err != nil {
+					t.Fatalf("Failed to change file times: %v", err)
+				}
+			}
+
+			reason := skipReason(inputFile, outputFile, tc.policy)
+			if tc.expectSkip && reason == "" {
+				t.Errorf("Expected the input to be skipped")
+			} else if !tc.expectSkip && reason != "" {
+				t.Errorf("Expected the input to be converted, got %q", reason)
+			}
+		})
+	}
+}
+
+// TestOutputPath tests the outputPath function
+func TestOutputPath(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		rootDir  string
+		format   string
+		config   Config
+		expected string
+	}{
+		{
+			name:     "next to the input",
+			input:    filepath.Join("library", "series", "vol1.cbz"),
+			rootDir:  "library",
+			format:   "epub",
+			expected: filepath.Join("library", "series", "vol1.epub"),
+		},
+		{
+			name:     "mirrored tree",
+			input:    filepath.Join("library", "series", "vol1.cbz"),
+			rootDir:  "library",
+			format:   "kepub",
+			config:   Config{OutputDir: "out"},
+			expected: filepath.Join("out", "series", "vol1.kepub.epub"),
+		},
+		{
+			name:     "flattened",
+			input:    filepath.Join("library", "series", "vol1.cbz"),
+			rootDir:  "library",
+			format:   "epub",
+			config:   Config{OutputDir: "out", Flatten: true},
+			expected: filepath.Join("out", "vol1.epub"),
+		},
+		{
+			name:     "single file",
+			input:    filepath.Join("library", "series", "vol1.pdf"),
+			format:   "epub",
+			config:   Config{OutputDir: "out"},
+			expected: filepath.Join("out", "vol1.epub"),
+		},
+		{
+			name:     "name template",
+			input:    filepath.Join("library", "vol1.cbz"),
+			rootDir:  "library",
+			format:   "pdf",
+			config:   Config{OutputDir: "out", NameTemplate: "{name} (print)"},
+			expected: filepath.Join("out", "vol1 (print).pdf"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := outputPath(tc.input, tc.rootDir, tc.format, tc.config); got != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+// TestConvertToOutputDir tests converting a directory tree into an output directory
+func TestConvertToOutputDir(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a library where only the subdirectories contain files
+	library := filepath.Join(tempDir, "library")
+	for _, name := range []string{filepath.Join("a", "vol1.cbz"), filepath.Join("b", "vol1.cbz")} {
+		path := filepath.Join(library, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create test directory: %v", err)
+		}
+		cbzFile := &cbz.File{
+			Name:   path,
+			Images: []cbz.Image{{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}},
+		}
+		if err := cbz.WriteFile(cbzFile, path); err != nil {
+			t.Fatalf("Failed to create test CBZ file: %v", err)
+		}
+	}
+
+	// The tree is mirrored in the output directory
+	outDir := filepath.Join(tempDir, "out")
+	config := Config{Convert: true, Recursive: true, OutputDir: outDir, InputFiles: []string{library}}
+	if err := handleConvertCommand(config); err != nil {
+		t.Fatalf("handleConvertCommand failed: %v", err)
+	}
+	for _, name := range []string{filepath.Join("a", "vol1.epub"), filepath.Join("b", "vol1.epub")} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+			t.Errorf("Expected output file %s: %v", name, err)
+		}
+	}
+	if matches, _ := filepath.Glob(filepath.Join(library, "*", "*.epub")); len(matches) != 0 {
+		t.Errorf("Expected no output files in the library, got %v", matches)
+	}
+
+	// Flattening files with the same name is an error instead of overwriting
+	config.OutputDir = filepath.Join(tempDir, "flat")
+	config.Flatten = true
+	if err := handleConvertCommand(config); err == nil {
+		t.Errorf("Expected error for conflicting output files, got nil")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "flat", "vol1.epub")); err != nil {
+		t.Errorf("Expected the first file to be converted: %v", err)
+	}
+}
+
+// TestConvertReproducible tests that converting an unchanged file again gives the same output
+func TestConvertReproducible(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputFile := filepath.Join(tempDir, "Series v01.cbz")
+	cbzFile := &cbz.File{
+		Name:   inputFile,
+		Images: []cbz.Image{{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}},
+	}
+	if err := cbz.WriteFile(cbzFile, inputFile); err != nil {
+		t.Fatalf("Failed to create test CBZ file: %v", err)
+	}
+
+	var outputs [][]byte
+	for _, name := range []string{"first.epub", "second.epub"} {
+		outputFile := filepath.Join(tempDir, name)
+		if _, err := convertFile(inputFile, outputFile, "kindle", Config{Reproducible: true}); err != nil {
+			t.Fatalf("convertFile failed: %v", err)
+		}
+		data, err := os.ReadFile(outputFile)
+		if err != nil {
+			t.Fatalf("Failed to read output file: %v", err)
+		}
+		outputs = append(outputs, data)
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Errorf("Expected identical output files")
+	}
+}
@@ -0,0 +1,149 @@
+package cbz2epub
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cbz2epub/cbz"
+	"cbz2epub/util"
+)
+
+// stringList is a flag that can be given several times
+type stringList []string
+
+// String returns the values of the flag
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+// Set adds a value of the flag
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// namePatterns compiles the file name patterns of the configuration. Every
+// pattern needs at least one named group to fill the metadata.
+func namePatterns(config Config) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, expr := range config.NamePatterns {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name pattern %q: %w", expr, err)
+		}
+		named := false
+		for _, name := range pattern.SubexpNames() {
+			named = named || name != ""
+		}
+		if !named {
+			return nil, fmt.Errorf("name pattern %q has no named groups such as (?P<series>...)", expr)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// loadBook reads an input file and its sidecar files, fills the metadata
+// still missing from the file name and applies the metadata overrides
+func loadBook(inputFile string, config Config) (*cbz.File, error) {
+	patterns, err := namePatterns(config)
+	if err != nil {
+		return nil, err
+	}
+	cbzFile, err := readBook(inputFile, config.progress.update)
+	if err != nil {
+		return nil, err
+	}
+	if !config.NoSidecars {
+		applySidecars(cbzFile, inputFile, true)
+	}
+	cbzFile.ApplyFileName(patterns)
+	applyOverrides(cbzFile, config)
+	return cbzFile, nil
+}
+
+// mergedComicInfo returns the metadata of a book merged from files: the
+// metadata of the first file, which already has the sidecars and the
+// overrides of the command line, without the page entries of that file
+func mergedComicInfo(first *cbz.File) *cbz.ComicInfo {
+	if first.ComicInfo == nil {
+		return nil
+	}
+	comicInfo := *first.ComicInfo
+	comicInfo.Pages = nil
+	return &comicInfo
+}
+
+// applyOverrides replaces the metadata of a book with the metadata set on
+// the command line
+func applyOverrides(cbzFile *cbz.File, config Config) {
+	overrides := metadataOverrides(config)
+	if overrides == nil {
+		return
+	}
+	if cbzFile.ComicInfo == nil {
+		cbzFile.ComicInfo = &cbz.ComicInfo{}
+	}
+	cbzFile.ComicInfo.Override(overrides)
+}
+
+// addMetadataFlags registers the flags that override the book metadata
+func addMetadataFlags(fs *flag.FlagSet, config *Config) {
+	fs.StringVar(&config.Title, "title", "", "Book title")
+	fs.StringVar(&config.Author, "author", "", "Authors, separated by commas")
+	fs.StringVar(&config.Language, "language", "", "Language code, such as en or ja")
+	fs.StringVar(&config.Series, "series", "", "Series name")
+	fs.StringVar(&config.SeriesIndex, "series-index", "", "Position of the book in its series")
+	fs.StringVar(&config.Publisher, "publisher", "", "Publisher")
+	fs.StringVar(&config.Description, "description", "", "Book description")
+	fs.StringVar(&config.Tags, "tags", "", "Tags, separated by commas")
+	fs.StringVar(&config.ISBN, "isbn", "", "ISBN of the book")
+}
+
+// validateMetadata checks the metadata overrides of the configuration
+func validateMetadata(config Config) error {
+	if config.SeriesIndex != "" {
+		if _, err := strconv.ParseFloat(config.SeriesIndex, 64); err != nil {
+			return fmt.Errorf("invalid series index: %s", config.SeriesIndex)
+		}
+	}
+	if _, ok := util.ISBNDigits(config.ISBN); config.ISBN != "" && !ok {
+		return fmt.Errorf("invalid ISBN: %s", config.ISBN)
+	}
+	if config.Identifier != "" && (len(config.InputFiles) > 1 || config.Recursive) {
+		return fmt.Errorf("-identifier can only be used with a single input file")
+	}
+	return nil
+}
+
+// bookIdentifier returns the identifier set on the command line. A plain
+// ISBN is turned into a urn:isbn identifier.
+func bookIdentifier(config Config) string {
+	if isbn, ok := util.ISBNDigits(config.Identifier); ok {
+		return "urn:isbn:" + isbn
+	}
+	return config.Identifier
+}
+
+// metadataOverrides returns the metadata set on the command line, or nil if
+// none is set
+func metadataOverrides(config Config) *cbz.ComicInfo {
+	overrides := &cbz.ComicInfo{
+		Title:       config.Title,
+		Writer:      config.Author,
+		LanguageISO: config.Language,
+		Series:      config.Series,
+		Number:      config.SeriesIndex,
+		Publisher:   config.Publisher,
+		Summary:     config.Description,
+		Tags:        config.Tags,
+		GTIN:        config.ISBN,
+	}
+	if len(overrides.Fields()) == 0 {
+		return nil
+	}
+	return overrides
+}
@@ -0,0 +1,131 @@
+package cbz2epub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cbz2epub/cbz"
+	"cbz2epub/epub"
+)
+
+// TestMetadataOverrides tests that metadata set on the command line replaces
+// the metadata of ComicInfo.xml and file names
+func TestMetadataOverrides(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputFile := filepath.Join(tempDir, "Series Name v03 (2019).cbz")
+	cbzFile := &cbz.File{
+		Name:      inputFile,
+		Images:    []cbz.Image{{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}},
+		ComicInfo: &cbz.ComicInfo{Title: "Tagged Title", Writer: "Tagged Writer", Publisher: "Tagged Press"},
+	}
+	if err := cbz.WriteFile(cbzFile, inputFile); err != nil {
+		t.Fatalf("Failed to create test CBZ file: %v", err)
+	}
+
+	config := Config{
+		Convert:     true,
+		Format:      "epub",
+		OutputFile:  filepath.Join(tempDir, "out.epub"),
+		Title:       "New Title",
+		Author:      "Jane Doe",
+		Language:    "ja",
+		SeriesIndex: "3.5",
+		Tags:        "manga",
+		InputFiles:  []string{inputFile},
+	}
+	if err := handleConvertCommand(config); err != nil {
+		t.Fatalf("handleConvertCommand failed: %v", err)
+	}
+
+	book, err := epub.ReadFile(config.OutputFile)
+	if err != nil {
+		t.Fatalf("Failed to read EPUB: %v", err)
+	}
+	info := book.ComicInfo
+	if info.Title != "New Title" || info.Writer != "Jane Doe" || info.LanguageISO != "ja" || info.Tags != "manga" {
+		t.Errorf("Expected overridden metadata, got %+v", info)
+	}
+	if info.Publisher != "Tagged Press" || info.Series != "Series Name" || info.Number != "3.5" || info.Year != 2019 {
+		t.Errorf("Expected metadata from ComicInfo.xml and the file name, got %+v", info)
+	}
+
+	// Merged files get the metadata of the first file, with the metadata
+	// set on the command line
+	mergeConfig := Config{
+		Merge:      true,
+		OutputFile: filepath.Join(tempDir, "merged.cbz"),
+		Title:      "Omnibus",
+		ISBN:       "978-1-234-56789-7",
+		InputFiles: []string{inputFile, inputFile},
+	}
+	if err := handleMergeCommand(mergeConfig); err != nil {
+		t.Fatalf("handleMergeCommand failed: %v", err)
+	}
+	comicInfo, err := cbz.ReadComicInfo(mergeConfig.OutputFile)
+	if err != nil {
+		t.Fatalf("ReadComicInfo failed: %v", err)
+	}
+	if comicInfo == nil || comicInfo.Title != "Omnibus" || comicInfo.GTIN != "978-1-234-56789-7" {
+		t.Errorf("Expected merged metadata, got %+v", comicInfo)
+	}
+	if comicInfo != nil && (comicInfo.Writer != "Tagged Writer" || comicInfo.Publisher != "Tagged Press" || comicInfo.PageCount != 2) {
+		t.Errorf("Expected the metadata of the first file for all pages, got %+v", comicInfo)
+	}
+}
+
+// TestValidateMetadata tests checking the metadata flags
+func TestValidateMetadata(t *testing.T) {
+	testCases := []struct {
+		name        string
+		config      Config
+		expectError bool
+	}{
+		{name: "no overrides", config: Config{}},
+		{name: "decimal series index", config: Config{SeriesIndex: "2.5"}},
+		{name: "invalid series index", config: Config{SeriesIndex: "two"}, expectError: true},
+		{name: "ISBN-13 with hyphens", config: Config{ISBN: "978-1-234-56789-7"}},
+		{name: "ISBN-10 with check digit X", config: Config{ISBN: "080442957X"}},
+		{name: "invalid ISBN", config: Config{ISBN: "12345"}, expectError: true},
+		{name: "ISBN with a wrong check digit", config: Config{ISBN: "978-1-234-56789-8"}, expectError: true},
+		{name: "EAN-13 of another product", config: Config{ISBN: "4006381333931"}, expectError: true},
+		{name: "identifier for one file", config: Config{Identifier: "urn:uuid:1234", InputFiles: []string{"a.cbz"}}},
+		{name: "identifier for several files", config: Config{Identifier: "urn:uuid:1234", InputFiles: []string{"a.cbz", "b.cbz"}}, expectError: true},
+		{name: "identifier for a directory tree", config: Config{Identifier: "urn:uuid:1234", Recursive: true, InputFiles: []string{"comics"}}, expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateMetadata(tc.config)
+			if tc.expectError && err == nil {
+				t.Errorf("Expected error, got nil")
+			} else if !tc.expectError && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+// TestBookIdentifier tests the identifier set on the command line
+func TestBookIdentifier(t *testing.T) {
+	testCases := []struct {
+		identifier string
+		expected   string
+	}{
+		{"", ""},
+		{"978-1-60706-601-9", "urn:isbn:9781607066019"},
+		{"urn:uuid:0e5b4d4e-7d5d-4c38-9a32-0123456789ab", "urn:uuid:0e5b4d4e-7d5d-4c38-9a32-0123456789ab"},
+		{"my-book-1", "my-book-1"},
+	}
+	for _, tc := range testCases {
+		if got := bookIdentifier(Config{Identifier: tc.identifier}); got != tc.expected {
+			t.Errorf("Expected %q for %q, got %q", tc.expected, tc.identifier, got)
+		}
+	}
+}
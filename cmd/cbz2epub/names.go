@@ -0,0 +1,162 @@
+package cbz2epub
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cbz2epub/cbz"
+)
+
+// placeholderPattern matches the placeholders of a name template, such as
+// {series} or {volume:02} with a zero-padded width
+var placeholderPattern = regexp.MustCompile(`\{(\w+)(?::(\d+))?\}`)
+
+// metadataPlaceholders lists the placeholders that need the book metadata
+var metadataPlaceholders = []string{"series", "volume", "number", "title", "year", "writer", "publisher"}
+
+// usesMetadata checks if a name template has placeholders that need the
+// metadata of the book, so the input file has to be read to expand it
+func usesMetadata(template string) bool {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		for _, name := range metadataPlaceholders {
+			if match[1] == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// templateFields returns the values of the name template placeholders for
+// an input file and its metadata, which may be nil
+func templateFields(inputFile string, comicInfo *cbz.ComicInfo) map[string]string {
+	name := strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile))
+	fields := map[string]string{}
+	for _, placeholder := range metadataPlaceholders {
+		fields[placeholder] = ""
+	}
+	fields["name"] = name
+	fields["title"] = name
+	if comicInfo == nil {
+		return fields
+	}
+
+	if comicInfo.Title != "" {
+		fields["title"] = comicInfo.Title
+	}
+	fields["series"] = comicInfo.Series
+	fields["number"] = comicInfo.Number
+	fields["writer"] = comicInfo.Writer
+	fields["publisher"] = comicInfo.Publisher
+	if comicInfo.Volume > 0 {
+		fields["volume"] = strconv.Itoa(comicInfo.Volume)
+	}
+	if comicInfo.Year > 0 {
+		fields["year"] = strconv.Itoa(comicInfo.Year)
+	}
+	return fields
+}
+
+// expandName expands a name template for an output file with the extension
+// ext. The extension is appended unless the template places it with {ext}.
+func expandName(template string, fields map[string]string, ext string) string {
+	fields["ext"] = strings.TrimPrefix(ext, ".")
+	name := expandTemplate(template, fields)
+	if name == "" {
+		name = sanitizeName(fields["name"])
+	}
+	if strings.Contains(template, "{ext}") {
+		return name
+	}
+	return name + ext
+}
+
+// expandTemplate replaces the placeholders of a name template with the
+// field values. Values are made safe for file names, while slashes in the
+// template itself create directories. A width such as {volume:02} pads the
+// number at the start of the value with zeros. Separators and brackets left
+// empty by missing values are removed.
+func expandTemplate(template string, fields map[string]string) string {
+	expanded := placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		match := placeholderPattern.FindStringSubmatch(placeholder)
+		value, ok := fields[match[1]]
+		if !ok {
+			return placeholder
+		}
+		if match[2] != "" && value != "" {
+			width, _ := strconv.Atoi(match[2])
+			value = padNumber(value, width)
+		}
+		return sanitizeName(value)
+	})
+
+	// Clean up every path component separately
+	parts := strings.Split(filepath.ToSlash(expanded), "/")
+	cleaned := parts[:0]
+	for _, part := range parts {
+		part = strings.NewReplacer("()", "", "[]", "").Replace(part)
+		part = strings.Join(strings.Fields(part), " ")
+		part = strings.Trim(part, " -_.")
+		if part != "" {
+			cleaned = append(cleaned, part)
+		}
+	}
+	return filepath.Join(cleaned...)
+}
+
+// padNumber pads the number at the start of a value with zeros, keeping any
+// fraction or suffix, so "3" becomes "003" and "21.5" becomes "021.5"
+func padNumber(value string, width int) string {
+	digits := 0
+	for digits < len(value) && value[digits] >= '0' && value[digits] <= '9' {
+		digits++
+	}
+	if digits == 0 || digits >= width {
+		return value
+	}
+	return strings.Repeat("0", width-digits) + value
+}
+
+// sanitizeName replaces the characters that are not allowed in file names
+// on common file systems
+func sanitizeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// readMetadata reads the metadata of an input file for name templates. CBZ
+// files only read their ComicInfo.xml, other files are read completely.
+// Sidecar files are applied, missing fields are filled from the file name,
+// and the metadata overrides are applied.
+func readMetadata(inputFile string, config Config) (*cbz.ComicInfo, error) {
+	if strings.ToLower(filepath.Ext(inputFile)) != ".cbz" {
+		cbzFile, err := loadBook(inputFile, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata of %s: %w", inputFile, err)
+		}
+		return cbzFile.ComicInfo, nil
+	}
+
+	patterns, err := namePatterns(config)
+	if err != nil {
+		return nil, err
+	}
+	comicInfo, err := cbz.ReadComicInfo(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	cbzFile := &cbz.File{Name: inputFile, ComicInfo: comicInfo}
+	if !config.NoSidecars {
+		applySidecars(cbzFile, inputFile, false)
+	}
+	cbzFile.ApplyFileName(patterns)
+	applyOverrides(cbzFile, config)
+	return cbzFile.ComicInfo, nil
+}
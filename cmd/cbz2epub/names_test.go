@@ -0,0 +1,200 @@
+package cbz2epub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cbz2epub/cbz"
+)
+
+// TestExpandName tests expanding output name templates
+func TestExpandName(t *testing.T) {
+	comicInfo := &cbz.ComicInfo{
+		Title:  "Into the Woods",
+		Series: "Saga: Book One",
+		Number: "7",
+		Volume: 2,
+		Year:   2013,
+		Writer: "Brian K. Vaughan",
+	}
+
+	testCases := []struct {
+		name      string
+		template  string
+		comicInfo *cbz.ComicInfo
+		ext       string
+		expected  string
+	}{
+		{
+			name:     "file name",
+			template: "{name}",
+			ext:      ".epub",
+			expected: "saga_07.epub",
+		},
+		{
+			name:      "metadata with padding",
+			template:  "{series} v{volume:02} #{number:03} ({year})",
+			comicInfo: comicInfo,
+			ext:       ".epub",
+			expected:  "Saga_ Book One v02 #007 (2013).epub",
+		},
+		{
+			name:      "subdirectories",
+			template:  "{writer}/{series}/{title}",
+			comicInfo: comicInfo,
+			ext:       ".pdf",
+			expected:  filepath.Join("Brian K. Vaughan", "Saga_ Book One", "Into the Woods.pdf"),
+		},
+		{
+			name:      "extension placeholder",
+			template:  "{title}.{ext}",
+			comicInfo: comicInfo,
+			ext:       ".kepub.epub",
+			expected:  "Into the Woods.kepub.epub",
+		},
+		{
+			name:     "missing metadata",
+			template: "{series} ({year}) - {title}",
+			ext:      ".epub",
+			expected: "saga_07.epub",
+		},
+		{
+			name:     "empty result",
+			template: "{series}",
+			ext:      ".cbz",
+			expected: "saga_07.cbz",
+		},
+		{
+			name:      "unsafe values",
+			template:  "{title}",
+			comicInfo: &cbz.ComicInfo{Title: `What? <A/B> "C"`},
+			ext:       ".epub",
+			expected:  "What_ _A_B_ _C.epub",
+		},
+		{
+			name:     "unknown placeholder",
+			template: "{name} {isbn}",
+			ext:      ".epub",
+			expected: "saga_07 {isbn}.epub",
+		},
+		{
+			name:      "fractional number",
+			template:  "{series} {number:03}",
+			comicInfo: &cbz.ComicInfo{Series: "Saga", Number: "21.5"},
+			ext:       ".epub",
+			expected:  "Saga 021.5.epub",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fields := templateFields(filepath.Join("library", "saga_07.cbz"), tc.comicInfo)
+			if got := expandName(tc.template, fields, tc.ext); got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+// TestUsesMetadata tests detecting templates that need the book metadata
+func TestUsesMetadata(t *testing.T) {
+	if usesMetadata("{name}_part{part}") || usesMetadata("{name}.{ext}") {
+		t.Errorf("Expected file name placeholders not to need metadata")
+	}
+	if !usesMetadata("{name} {volume:02}") || !usesMetadata("{writer}/{title}") {
+		t.Errorf("Expected metadata placeholders to need metadata")
+	}
+}
+
+// TestOutputPathMetadata tests naming converted and split files from ComicInfo.xml
+func TestOutputPathMetadata(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputFile := filepath.Join(tempDir, "scan0042.cbz")
+	cbzFile := &cbz.File{
+		Name:      inputFile,
+		Images:    []cbz.Image{{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}},
+		ComicInfo: &cbz.ComicInfo{Series: "Saga", Volume: 3, Title: "Chapter Three"},
+	}
+	if err := cbz.WriteFile(cbzFile, inputFile); err != nil {
+		t.Fatalf("Failed to create test CBZ file: %v", err)
+	}
+
+	config := Config{OutputDir: filepath.Join(tempDir, "out"), NameTemplate: "{series}/{series} v{volume:02}"}
+	expected := filepath.Join(tempDir, "out", "Saga", "Saga v03.kepub.epub")
+	if got := outputPath(inputFile, "", "kepub", config); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+
+	// Unreadable input files fall back to the file name fields
+	config.NameTemplate = "{name} {series}"
+	expected = filepath.Join(tempDir, "out", "missing.epub")
+	if got := outputPath(filepath.Join(tempDir, "missing.cbz"), "", "epub", config); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+
+	// Untagged files are named from the metadata in their file names
+	untagged := filepath.Join(tempDir, "Series Name v04 c030 (2019) [Group].cbz")
+	if err := cbz.WriteFile(&cbz.File{Name: untagged, Images: cbzFile.Images}, untagged); err != nil {
+		t.Fatalf("Failed to create test CBZ file: %v", err)
+	}
+	config.NameTemplate = "{series} v{volume:02} #{number}"
+	expected = filepath.Join(tempDir, "out", "Series Name v04 #30.epub")
+	if got := outputPath(untagged, "", "epub", config); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+	config.NamePatterns = []string{`^(?P<series>\w+) (?P<title>.+) v\d+`}
+	config.NameTemplate = "{series} - {title}"
+	expected = filepath.Join(tempDir, "out", "Series - Name.epub")
+	if got := outputPath(untagged, "", "epub", config); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+
+	expected = filepath.Join(tempDir, "Saga v3 part 002 of 10.cbz")
+	if got := splitOutputName(inputFile, "{series} v{volume} part {part:3} of 10", ".cbz", cbzFile.ComicInfo, 2, 10); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+// TestMergeNameTemplate tests naming a merged file from the metadata of the first file
+func TestMergeNameTemplate(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var inputFiles []string
+	for i, name := range []string{"ch01.cbz", "ch02.cbz"} {
+		inputFile := filepath.Join(tempDir, name)
+		cbzFile := &cbz.File{
+			Name:      inputFile,
+			Images:    []cbz.Image{{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}},
+			ComicInfo: &cbz.ComicInfo{Series: "Saga", Volume: 1, Number: string(rune('1' + i))},
+		}
+		if err := cbz.WriteFile(cbzFile, inputFile); err != nil {
+			t.Fatalf("Failed to create test CBZ file: %v", err)
+		}
+		inputFiles = append(inputFiles, inputFile)
+	}
+
+	config := Config{
+		Merge:        true,
+		OutputFile:   filepath.Join(tempDir, "merged.cbz"),
+		NameTemplate: "{series} v{volume:02}",
+		InputFiles:   inputFiles,
+	}
+	if err := handleMergeCommand(config); err != nil {
+		t.Fatalf("handleMergeCommand failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "Saga v01.cbz")); err != nil {
+		t.Errorf("Expected merged file named from metadata: %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package cbz2epub
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cbz2epub/cbz"
+)
+
+// progressInterval is the time between progress log lines when stdout is
+// not a terminal
+const progressInterval = 10 * time.Second
+
+// redrawInterval is the shortest time between redraws of the status line
+const redrawInterval = 100 * time.Millisecond
+
+// progressDisplay shows the progress of a command: the files done, the pages
+// of the current file, the throughput and the time left. On a terminal it
+// keeps a status line below the log; otherwise it logs a line now and then.
+// A nil display shows nothing.
+type progressDisplay struct {
+	out       io.Writer // Status line output, nil when not a terminal
+	logOutput io.Writer // Log output the display passes log lines to
+	now       func() time.Time
+
+	totalFiles int
+	doneFiles  int
+	file       string
+	current    cbz.Progress
+	bytesDone  int64 // Bytes written for the finished files
+
+	start     time.Time
+	lastShown time.Time
+	lineShown bool
+}
+
+// newProgressDisplay returns the progress display of a command processing
+// the given number of files, or nil if progress is turned off. Log lines
+// are passed through the display so they don't mix with the status line.
+func newProgressDisplay(totalFiles int, config Config) *progressDisplay {
+	if config.NoProgress || config.DryRun {
+		return nil
+	}
+
+	p := &progressDisplay{
+		logOutput:  log.Writer(),
+		now:        time.Now,
+		totalFiles: totalFiles,
+	}
+	p.start = p.now()
+	p.lastShown = p.start
+
+	// The JSON report owns stdout
+	if !config.JSON && isTerminal(os.Stdout) {
+		p.out = os.Stdout
+		log.SetOutput(p)
+	}
+	return p
+}
+
+// isTerminal checks if a file is a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// startFile starts showing the progress of a file, counting the previous
+// file as done
+func (p *progressDisplay) startFile(name string) {
+	if p == nil {
+		return
+	}
+	if p.file != "" {
+		p.doneFiles++
+		p.bytesDone += p.current.Bytes
+	}
+	p.file = filepath.Base(name)
+	p.current = cbz.Progress{}
+	p.show(true)
+}
+
+// update records a progress event of the current file
+func (p *progressDisplay) update(progress cbz.Progress) {
+	if p == nil {
+		return
+	}
+	p.current = progress
+	p.show(false)
+}
+
+// close removes the status line and gives the log its output back
+func (p *progressDisplay) close() {
+	if p == nil || p.out == nil {
+		return
+	}
+	p.clear()
+	log.SetOutput(p.logOutput)
+}
+
+// Write passes a log line to the log output, redrawing the status line
+// below it
+func (p *progressDisplay) Write(b []byte) (int, error) {
+	p.clear()
+	n, err := p.logOutput.Write(b)
+	p.show(true)
+	return n, err
+}
+
+// show draws the status line on a terminal, at most every redrawInterval
+// unless forced. Otherwise it logs the status every progressInterval.
+func (p *progressDisplay) show(force bool) {
+	now := p.now()
+	if p.out == nil {
+		if now.Sub(p.lastShown) >= progressInterval {
+			p.lastShown = now
+			log.Printf("Progress: %s\n", p.status(now))
+		}
+		return
+	}
+	if !force && now.Sub(p.lastShown) < redrawInterval {
+		return
+	}
+	p.lastShown = now
+	fmt.Fprintf(p.out, "\r\x1b[K%s", p.status(now))
+	p.lineShown = true
+}
+
+// clear removes the status line from the terminal
+func (p *progressDisplay) clear() {
+	if p.out != nil && p.lineShown {
+		fmt.Fprint(p.out, "\r\x1b[K")
+		p.lineShown = false
+	}
+}
+
+// status describes the progress, such as
+// "2/5 files, vol1.cbz: writing 120/480 pages, 3.2 MB/s, 1m20s left"
+func (p *progressDisplay) status(now time.Time) string {
+	status := fmt.Sprintf("%d/%d files", p.doneFiles, p.totalFiles)
+	if p.file != "" && p.doneFiles < p.totalFiles {
+		status += ", " + p.file
+		if p.current.Stage != "" {
+			status += fmt.Sprintf(": %s %d", stageVerb(p.current.Stage), p.current.Pages)
+			if p.current.TotalPages > 0 {
+				status += fmt.Sprintf("/%d", p.current.TotalPages)
+			}
+			status += " pages"
+		}
+	}
+
+	elapsed := now.Sub(p.start)
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		status += fmt.Sprintf(", %.1f MB/s", float64(p.bytesDone+p.current.Bytes)/1024/1024/seconds)
+	}
+	if done := p.fractionDone(); done > 0 && done < 1 {
+		left := time.Duration(float64(elapsed) * (1 - done) / done)
+		status += fmt.Sprintf(", %s left", left.Round(time.Second))
+	}
+	return status
+}
+
+// fractionDone estimates the part of the work that is done. Reading a file
+// counts as the first half of its work, processing and writing as the second.
+func (p *progressDisplay) fractionDone() float64 {
+	if p.totalFiles == 0 {
+		return 0
+	}
+	file := 0.0
+	if p.current.TotalPages > 0 {
+		file = float64(p.current.Pages) / float64(p.current.TotalPages) / 2
+		if p.current.Stage != cbz.StageRead {
+			file += 0.5
+		}
+	}
+	return (float64(p.doneFiles) + file) / float64(p.totalFiles)
+}
+
+// stageVerb describes a stage in the status line
+func stageVerb(stage cbz.Stage) string {
+	switch stage {
+	case cbz.StageRead:
+		return "reading"
+	case cbz.StageProcess:
+		return "processing"
+	case cbz.StageWrite:
+		return "writing"
+	}
+	return string(stage)
+}
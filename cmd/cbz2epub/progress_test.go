@@ -0,0 +1,161 @@
+package cbz2epub
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cbz2epub/cbz"
+)
+
+// fakeClock returns a clock for progress displays that the test moves forward
+func fakeClock(start time.Time) (func() time.Time, func(time.Duration)) {
+	now := start
+	return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+}
+
+// TestProgressStatus tests the status line of the progress display
+func TestProgressStatus(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := &progressDisplay{totalFiles: 2, start: start}
+
+	testCases := []struct {
+		name      string
+		doneFiles int
+		progress  cbz.Progress
+		elapsed   time.Duration
+		expected  string
+	}{
+		{
+			name:     "reading",
+			progress: cbz.Progress{Stage: cbz.StageRead, Pages: 50, TotalPages: 100},
+			elapsed:  10 * time.Second,
+			expected: "0/2 files, vol1.cbz: reading 50/100 pages, 0.0 MB/s, 1m10s left",
+		},
+		{
+			name:      "writing the second file",
+			doneFiles: 1,
+			progress:  cbz.Progress{Stage: cbz.StageWrite, Pages: 50, TotalPages: 100, Bytes: 20 << 20},
+			elapsed:   35 * time.Second,
+			expected:  "1/2 files, vol1.cbz: writing 50/100 pages, 0.6 MB/s, 5s left",
+		},
+		{
+			name:      "done",
+			doneFiles: 2,
+			elapsed:   40 * time.Second,
+			expected:  "2/2 files, 0.0 MB/s",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p.file, p.doneFiles, p.current = "vol1.cbz", tc.doneFiles, tc.progress
+			if got := p.status(start.Add(tc.elapsed)); got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+// TestProgressTerminal tests that log lines are written above the status line
+func TestProgressTerminal(t *testing.T) {
+	now, advance := fakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	var out, logOutput bytes.Buffer
+	p := &progressDisplay{out: &out, logOutput: &logOutput, now: now, totalFiles: 1, start: now(), lastShown: now()}
+
+	p.startFile("/comics/vol1.cbz")
+	if !strings.HasSuffix(out.String(), "\r\x1b[K0/1 files, vol1.cbz") {
+		t.Errorf("Expected the status line, got %q", out.String())
+	}
+
+	// Updates are drawn at most every redrawInterval
+	out.Reset()
+	p.update(cbz.Progress{Stage: cbz.StageWrite, Pages: 1, TotalPages: 4})
+	if out.Len() != 0 {
+		t.Errorf("Expected no redraw, got %q", out.String())
+	}
+	advance(time.Second)
+	p.update(cbz.Progress{Stage: cbz.StageWrite, Pages: 2, TotalPages: 4})
+	if !strings.Contains(out.String(), "writing 2/4 pages") {
+		t.Errorf("Expected a redraw, got %q", out.String())
+	}
+
+	// Log lines clear the status line and redraw it
+	out.Reset()
+	if _, err := p.Write([]byte("[CBZ2EPUB] Converting\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if logOutput.String() != "[CBZ2EPUB] Converting\n" {
+		t.Errorf("Expected the log line, got %q", logOutput.String())
+	}
+	if !strings.HasPrefix(out.String(), "\r\x1b[K\r\x1b[K") || !strings.Contains(out.String(), "writing 2/4 pages") {
+		t.Errorf("Expected the status line to be cleared and redrawn, got %q", out.String())
+	}
+}
+
+// TestProgressLog tests the periodic log lines when stdout is not a terminal
+func TestProgressLog(t *testing.T) {
+	var logOutput bytes.Buffer
+	log.SetOutput(&logOutput)
+	defer log.SetOutput(os.Stderr)
+
+	now, advance := fakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := &progressDisplay{logOutput: &logOutput, now: now, totalFiles: 1, start: now(), lastShown: now()}
+	p.startFile("vol1.cbz")
+	p.update(cbz.Progress{Stage: cbz.StageRead, Pages: 1, TotalPages: 2})
+	if logOutput.Len() != 0 {
+		t.Errorf("Expected no log line before the interval, got %q", logOutput.String())
+	}
+
+	advance(progressInterval)
+	p.update(cbz.Progress{Stage: cbz.StageRead, Pages: 2, TotalPages: 2})
+	if !strings.Contains(logOutput.String(), "Progress: 0/1 files, vol1.cbz: reading 2/2 pages") {
+		t.Errorf("Expected a progress log line, got %q", logOutput.String())
+	}
+
+	// A nil display shows nothing
+	var disabled *progressDisplay
+	disabled.startFile("vol1.cbz")
+	disabled.update(cbz.Progress{})
+	disabled.close()
+	if newProgressDisplay(1, Config{NoProgress: true}) != nil {
+		t.Errorf("Expected no display with -no-progress")
+	}
+}
+
+// TestCountInputFiles tests counting the files the convert command converts
+func TestCountInputFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, name := range []string{"a.cbz", "notes.txt", "sub/b.cbz", "sub/c.pdf"} {
+		path := filepath.Join(tempDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		config   Config
+		expected int
+	}{
+		{Config{InputFiles: []string{tempDir}}, 0},
+		{Config{InputFiles: []string{tempDir}, Recursive: true}, 3},
+		{Config{InputFiles: []string{filepath.Join(tempDir, "sub/c.pdf"), filepath.Join(tempDir, "notes.txt"), "missing.cbz"}}, 1},
+	}
+	for _, tc := range testCases {
+		if got := countInputFiles(tc.config); got != tc.expected {
+			t.Errorf("Expected %d files for %+v, got %d", tc.expected, tc.config, got)
+		}
+	}
+}
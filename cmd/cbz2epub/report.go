@@ -0,0 +1,169 @@
+package cbz2epub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cbz2epub/util"
+)
+
+// Status values of a conversion result
+const (
+	statusConverted = "converted"
+	statusFailed    = "failed"
+	statusSkipped   = "skipped"
+	statusPlanned   = "planned"
+)
+
+// conversionResult describes what happened to one input file
+type conversionResult struct {
+	Input      string `json:"input"`
+	Output     string `json:"output,omitempty"`
+	Status     string `json:"status"`
+	Overwrite  bool   `json:"overwrite,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Pages      int    `json:"pages"`
+	BytesIn    int64  `json:"bytesIn"`
+	BytesOut   int64  `json:"bytesOut"`
+	DurationMS int64  `json:"durationMs"`
+}
+
+// conversionReport collects the results of a convert command for scripts
+type conversionReport struct {
+	Converted int                `json:"converted"`
+	Failed    int                `json:"failed"`
+	Skipped   int                `json:"skipped"`
+	Planned   int                `json:"planned,omitempty"`
+	Results   []conversionResult `json:"results"`
+
+	// outputs maps the output files to their input files
+	outputs map[string]string
+}
+
+// newConversionReport returns an empty report
+func newConversionReport() *conversionReport {
+	return &conversionReport{Results: []conversionResult{}, outputs: map[string]string{}}
+}
+
+// claim reserves an output file for an input file. It fails if another
+// input file of the same run is converted to the same output file, which
+// happens when flattening directories with files of the same name.
+func (r *conversionReport) claim(input, output string) error {
+	output = filepath.Clean(output)
+	if other, ok := r.outputs[output]; ok && other != input {
+		return fmt.Errorf("output file %s is already written for %s", output, other)
+	}
+	r.outputs[output] = input
+	return nil
+}
+
+// add records the conversion of an input file that started at start
+func (r *conversionReport) add(input, output string, pages int, start time.Time, err error) {
+	result := conversionResult{
+		Input:      input,
+		Output:     output,
+		Status:     statusConverted,
+		Pages:      pages,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if info, statErr := os.Stat(input); statErr == nil {
+		result.BytesIn = info.Size()
+	}
+	if err != nil {
+		result.Status = statusFailed
+		result.Error = err.Error()
+		r.Failed++
+	} else {
+		if info, statErr := os.Stat(output); statErr == nil {
+			result.BytesOut = info.Size()
+		}
+		r.Converted++
+	}
+	r.Results = append(r.Results, result)
+}
+
+// skip records an input that was not converted, with the reason
+func (r *conversionReport) skip(input, reason string) {
+	r.Results = append(r.Results, conversionResult{
+		Input:  input,
+		Status: statusSkipped,
+		Error:  reason,
+	})
+	r.Skipped++
+}
+
+// plan records a conversion of a dry run. Inputs that can't be opened are
+// recorded as failed.
+func (r *conversionReport) plan(input, output string, overwrite bool, err error) {
+	result := conversionResult{
+		Input:     input,
+		Output:    output,
+		Status:    statusPlanned,
+		Overwrite: overwrite,
+	}
+	if info, statErr := os.Stat(input); statErr == nil {
+		result.BytesIn = info.Size()
+	}
+	if err != nil {
+		result.Status = statusFailed
+		result.Error = err.Error()
+		r.Failed++
+	} else {
+		r.Planned++
+	}
+	r.Results = append(r.Results, result)
+}
+
+// printPlan writes the results of a dry run as one line per input file
+func (r *conversionReport) printPlan(w io.Writer) {
+	for _, result := range r.Results {
+		switch result.Status {
+		case statusPlanned:
+			overwrite := ""
+			if result.Overwrite {
+				overwrite = " (overwrites existing file)"
+			}
+			fmt.Fprintf(w, "convert %s -> %s%s\n", result.Input, result.Output, overwrite)
+		case statusSkipped:
+			fmt.Fprintf(w, "skip    %s: %s\n", result.Input, result.Error)
+		case statusFailed:
+			fmt.Fprintf(w, "fail    %s: %s\n", result.Input, result.Error)
+		}
+	}
+	fmt.Fprintf(w, "%d to convert, %d skipped, %d failing\n", r.Planned, r.Skipped, r.Failed)
+}
+
+// write writes the report as JSON to the report file of the configuration,
+// and to stdout if JSON output is enabled
+func (r *conversionReport) write(config Config) error {
+	if config.JSON {
+		if err := r.encode(os.Stdout); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	}
+	if config.Report == "" {
+		return nil
+	}
+
+	file, err := util.CreateAtomic(config.Report)
+	if err != nil {
+		return fmt.Errorf("failed to create report file: %w", err)
+	}
+	defer file.Abort()
+
+	if err := r.encode(file); err != nil {
+		return fmt.Errorf("failed to write report file: %w", err)
+	}
+	return file.Commit()
+}
+
+// encode writes the report as indented JSON
+func (r *conversionReport) encode(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
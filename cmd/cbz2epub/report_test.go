@@ -0,0 +1,150 @@
+package cbz2epub
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cbz2epub/cbz"
+)
+
+// TestConversionReport tests the report written by the convert command
+func TestConversionReport(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a valid CBZ file, a broken one and a file that is skipped
+	goodFile := filepath.Join(tempDir, "good.cbz")
+	cbzFile := &cbz.File{
+		Name: goodFile,
+		Images: []cbz.Image{
+			{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"},
+			{Name: "002.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"},
+		},
+	}
+	if err := cbz.WriteFile(cbzFile, goodFile); err != nil {
+		t.Fatalf("Failed to create test CBZ file: %v", err)
+	}
+	badFile := filepath.Join(tempDir, "bad.cbz")
+	if err := os.WriteFile(badFile, []byte("not a zip file"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	textFile := filepath.Join(tempDir, "notes.txt")
+	if err := os.WriteFile(textFile, []byte("notes"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	reportFile := filepath.Join(tempDir, "report.json")
+	config := Config{
+		Convert:    true,
+		Report:     reportFile,
+		InputFiles: []string{goodFile, badFile, textFile},
+	}
+	if err := handleConvertCommand(config); err == nil {
+		t.Errorf("Expected error for the broken CBZ file, got nil")
+	}
+
+	// Read the report back
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	var report conversionReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("Failed to parse report: %v", err)
+	}
+
+	if report.Converted != 1 || report.Failed != 1 || report.Skipped != 1 || len(report.Results) != 3 {
+		t.Fatalf("Unexpected report totals: %+v", report)
+	}
+
+	good := report.Results[0]
+	if good.Status != statusConverted || good.Output != filepath.Join(tempDir, "good.epub") || good.Pages != 2 {
+		t.Errorf("Unexpected result for the valid file: %+v", good)
+	}
+	if good.BytesIn == 0 || good.BytesOut == 0 {
+		t.Errorf("Expected input and output sizes, got %+v", good)
+	}
+
+	bad := report.Results[1]
+	if bad.Status != statusFailed || bad.Error == "" || bad.BytesOut != 0 {
+		t.Errorf("Unexpected result for the broken file: %+v", bad)
+	}
+
+	if skipped := report.Results[2]; skipped.Status != statusSkipped || skipped.Input != textFile {
+		t.Errorf("Unexpected result for the skipped file: %+v", skipped)
+	}
+}
+
+// TestDryRun tests that a dry run plans conversions without writing anything
+func TestDryRun(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a library with new files, an already converted file and a broken file
+	subDir := filepath.Join(tempDir, "series")
+	if err := os.Mkdir(subDir, 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	for _, name := range []string{filepath.Join(tempDir, "new.cbz"), filepath.Join(subDir, "old.cbz")} {
+		cbzFile := &cbz.File{
+			Name:   name,
+			Images: []cbz.Image{{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}},
+		}
+		if err := cbz.WriteFile(cbzFile, name); err != nil {
+			t.Fatalf("Failed to create test CBZ file: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(subDir, "old.epub"), []byte("old"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(subDir, "broken.cbz"), []byte("not a zip file"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "scan.pdf"), []byte("%PDF-1.4"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	report := newConversionReport()
+	config := Config{Convert: true, Recursive: true, DryRun: true}
+	if err := processDirectory(tempDir, tempDir, config, report); err != nil {
+		t.Fatalf("processDirectory failed: %v", err)
+	}
+
+	if report.Planned != 3 || report.Failed != 1 || len(report.Results) != 4 {
+		t.Fatalf("Unexpected plan: %+v", report)
+	}
+	expected := []struct {
+		input     string
+		status    string
+		overwrite bool
+	}{
+		{filepath.Join(tempDir, "new.cbz"), statusPlanned, false},
+		{filepath.Join(tempDir, "scan.pdf"), statusPlanned, false},
+		{filepath.Join(subDir, "broken.cbz"), statusFailed, false},
+		{filepath.Join(subDir, "old.cbz"), statusPlanned, true},
+	}
+	for i, e := range expected {
+		result := report.Results[i]
+		if result.Input != e.input || result.Status != e.status || result.Overwrite != e.overwrite {
+			t.Errorf("Expected result %d to be %+v, got %+v", i, e, result)
+		}
+	}
+
+	// Nothing may be written
+	if _, err := os.Stat(filepath.Join(tempDir, "new.epub")); !os.IsNotExist(err) {
+		t.Errorf("Dry run created an output file")
+	}
+	if data, _ := os.ReadFile(filepath.Join(subDir, "old.epub")); string(data) != "old" {
+		t.Errorf("Dry run overwrote an existing file")
+	}
+}
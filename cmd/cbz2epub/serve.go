@@ -0,0 +1,731 @@
+package cbz2epub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cbz2epub/cbz"
+	"cbz2epub/epub"
+	"cbz2epub/pdf"
+	"cbz2epub/util"
+)
+
+// Statuses of an asynchronous conversion job
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// uploadField is the multipart form field holding the uploaded file
+const uploadField = "file"
+
+// maxFieldSize is the maximum size of the other multipart form fields
+const maxFieldSize = 64 << 10
+
+// expireInterval is the time between checks for expired jobs
+const expireInterval = time.Minute
+
+// shutdownTimeout is the time running requests get to finish when the
+// service stops
+const shutdownTimeout = 30 * time.Second
+
+// upload is a received CBZ file with the options of its conversion
+type upload struct {
+	file   string // Temporary copy of the uploaded file
+	name   string // File name given by the client
+	format string
+	config Config // Metadata overrides and panel mode
+	opts   epub.Options
+}
+
+// job is an asynchronous conversion. The exported fields are its status as
+// reported by the job endpoints.
+type job struct {
+	ID       string      `json:"id"`
+	Status   string      `json:"status"`
+	Name     string      `json:"name"`
+	Format   string      `json:"format"`
+	Progress jobProgress `json:"progress"`
+	Error    string      `json:"error,omitempty"`
+	Result   string      `json:"result,omitempty"`
+	Created  time.Time   `json:"created"`
+	Finished time.Time   `json:"finished,omitzero"`
+
+	upload *upload
+	output string
+	cancel context.CancelFunc
+}
+
+// jobProgress is the last progress event of a job
+type jobProgress struct {
+	Stage      cbz.Stage `json:"stage,omitempty"`
+	Pages      int       `json:"pages"`
+	TotalPages int       `json:"totalPages"`
+	Bytes      int64     `json:"bytes"`
+}
+
+// server is the HTTP conversion service. Uploads and job results are kept
+// in a work directory. Conversions, whether streamed or run as jobs, share
+// a limited number of slots. Uploads are only received when there is room
+// for them: streamed conversions take one of the upload places, and jobs
+// reserve one of the maximum number of jobs. Uploads are received before
+// taking a slot, within the upload timeout, so slow clients don't hold
+// slots.
+type server struct {
+	config    Config
+	workDir   string
+	maxUpload int64
+	slots     chan struct{}
+	uploads   chan struct{} // Streamed conversions receiving their upload
+	now       func() time.Time
+
+	// ctx is canceled when the service stops, stopping the conversions
+	// tracked by workers
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+
+	mu       sync.Mutex
+	jobs     map[string]*job
+	reserved int // Jobs whose upload is being received
+}
+
+// handleServeCommand handles the serve command
+func handleServeCommand(config Config) error {
+	workDir, err := os.MkdirTemp("", "cbz2epub-serve")
+	if err != nil {
+		return fmt.Errorf("failed to create work directory: %w", err)
+	}
+	util.RemoveOnInterrupt(workDir)
+	defer os.RemoveAll(workDir)
+
+	// The first interrupt stops the service cleanly, a second one exits
+	ctx, stop := util.InterruptContext(context.Background())
+	defer stop()
+
+	s := newServer(config, workDir)
+	defer s.stop()
+	go s.expireJobs(s.ctx)
+
+	httpServer := &http.Server{
+		Addr:              config.Listen,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 30 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return s.ctx },
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- httpServer.ListenAndServe()
+	}()
+	log.Printf("Listening on http://%s\n", config.Listen)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Running requests get some time to finish, then the conversions are
+	// canceled
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: failed to finish running requests: %v\n", err)
+	}
+	return nil
+}
+
+// newServer creates the conversion service of the configuration, keeping
+// its files in workDir
+func newServer(config Config, workDir string) *server {
+	concurrency := config.Concurrency
+	if concurrency == 0 {
+		concurrency = runtime.NumCPU()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &server{
+		config:    config,
+		workDir:   workDir,
+		maxUpload: int64(config.MaxUpload * 1024 * 1024),
+		slots:     make(chan struct{}, concurrency),
+		uploads:   make(chan struct{}, config.MaxJobs),
+		now:       time.Now,
+		ctx:       ctx,
+		cancel:    cancel,
+		jobs:      map[string]*job{},
+	}
+}
+
+// stop cancels the running conversions and waits until they end
+func (s *server) stop() {
+	s.cancel()
+	s.workers.Wait()
+}
+
+// handler returns the routes of the service
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /convert", s.handleConvert)
+	mux.HandleFunc("POST /jobs", s.handleCreateJob)
+	mux.HandleFunc("GET /jobs/{id}", s.handleJob)
+	mux.HandleFunc("GET /jobs/{id}/result", s.handleJobResult)
+	mux.HandleFunc("DELETE /jobs/{id}", s.handleDeleteJob)
+	return mux
+}
+
+// handleConvert converts an upload and streams the book back
+func (s *server) handleConvert(w http.ResponseWriter, r *http.Request) {
+	s.workers.Add(1)
+	defer s.workers.Done()
+
+	select {
+	case s.uploads <- struct{}{}:
+	default:
+		s.fail(w, http.StatusServiceUnavailable, fmt.Errorf("too many uploads, try again later"))
+		return
+	}
+	u, status, err := s.receiveUpload(w, r)
+	<-s.uploads
+	if err != nil {
+		s.fail(w, status, err)
+		return
+	}
+	defer os.Remove(u.file)
+
+	// The client may give up while waiting for a slot
+	if err := s.acquire(r.Context()); err != nil {
+		return
+	}
+	defer s.release()
+
+	book, err := loadUpload(r.Context(), u, nil)
+	if err != nil {
+		s.fail(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType(u.format))
+	w.Header().Set("Content-Disposition", attachment(outputName(u)))
+	counter := &util.CountingWriter{W: w}
+	if err := writeStream(r.Context(), counter, book, u.format, u.opts); err != nil {
+		if counter.N == 0 {
+			w.Header().Del("Content-Disposition")
+			s.fail(w, http.StatusInternalServerError, err)
+			return
+		}
+		// The status is sent already, so the client can only tell from
+		// the broken connection
+		log.Printf("Warning: failed to send %s: %v\n", outputName(u), err)
+		panic(http.ErrAbortHandler)
+	}
+	if s.config.Verbose {
+		log.Printf("Converted %s to %s, %d bytes\n", u.name, strings.ToUpper(u.format), counter.N)
+	}
+}
+
+// handleCreateJob queues the conversion of an upload and reports the job
+func (s *server) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	if len(s.jobs)+s.reserved >= s.config.MaxJobs {
+		s.mu.Unlock()
+		s.fail(w, http.StatusServiceUnavailable, fmt.Errorf("too many jobs, try again later"))
+		return
+	}
+	s.reserved++
+	s.mu.Unlock()
+
+	u, status, err := s.receiveUpload(w, r)
+	if err != nil {
+		s.mu.Lock()
+		s.reserved--
+		s.mu.Unlock()
+		s.fail(w, status, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	j := &job{
+		ID:      util.GenerateUUID(),
+		Status:  jobQueued,
+		Name:    u.name,
+		Format:  u.format,
+		Created: s.now(),
+		upload:  u,
+		cancel:  cancel,
+	}
+
+	s.mu.Lock()
+	s.reserved--
+	s.jobs[j.ID] = j
+	s.mu.Unlock()
+
+	if s.config.Verbose {
+		log.Printf("Queued job %s for %s\n", j.ID, u.name)
+	}
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		s.runJob(ctx, j)
+	}()
+
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	writeJSON(w, http.StatusAccepted, s.jobStatus(j))
+}
+
+// handleJob reports the status of a job
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	j := s.findJob(r.PathValue("id"))
+	if j == nil {
+		s.fail(w, http.StatusNotFound, fmt.Errorf("job not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.jobStatus(j))
+}
+
+// handleJobResult sends the book converted by a job
+func (s *server) handleJobResult(w http.ResponseWriter, r *http.Request) {
+	j := s.findJob(r.PathValue("id"))
+	if j == nil {
+		s.fail(w, http.StatusNotFound, fmt.Errorf("job not found"))
+		return
+	}
+	status := s.jobStatus(j)
+	if status.Status != jobDone {
+		s.fail(w, http.StatusConflict, fmt.Errorf("job is %s", status.Status))
+		return
+	}
+
+	file, err := os.Open(status.output)
+	if err != nil {
+		// The job expired or was deleted meanwhile
+		s.fail(w, http.StatusNotFound, fmt.Errorf("job not found"))
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", contentType(status.Format))
+	w.Header().Set("Content-Disposition", attachment(outputName(status.upload)))
+	http.ServeContent(w, r, "", status.Finished, file)
+}
+
+// handleDeleteJob cancels a job and removes its result
+func (s *server) handleDeleteJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	j := s.jobs[r.PathValue("id")]
+	delete(s.jobs, r.PathValue("id"))
+	s.mu.Unlock()
+
+	if j == nil {
+		s.fail(w, http.StatusNotFound, fmt.Errorf("job not found"))
+		return
+	}
+	j.cancel()
+	s.removeResult(j)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runJob waits for a slot and converts the upload of a job into the work
+// directory
+func (s *server) runJob(ctx context.Context, j *job) {
+	defer j.cancel()
+
+	if err := s.acquire(ctx); err != nil {
+		s.finishJob(j, "", err)
+		return
+	}
+	defer s.release()
+
+	s.mu.Lock()
+	j.Status = jobRunning
+	s.mu.Unlock()
+
+	output := filepath.Join(s.workDir, j.ID+formatExtension(j.Format))
+	err := s.convertJob(ctx, j, output)
+	if err != nil {
+		os.Remove(output)
+	}
+	s.finishJob(j, output, err)
+}
+
+// convertJob converts the upload of a job to the output file, recording
+// its progress
+func (s *server) convertJob(ctx context.Context, j *job, output string) error {
+	progress := func(p cbz.Progress) {
+		s.mu.Lock()
+		j.Progress = jobProgress{Stage: p.Stage, Pages: p.Pages, TotalPages: p.TotalPages, Bytes: p.Bytes}
+		s.mu.Unlock()
+	}
+
+	book, err := loadUpload(ctx, j.upload, progress)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	opts := j.upload.opts
+	opts.Progress = progress
+	if err := writeStream(ctx, file, book, j.Format, opts); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to convert to %s: %w", strings.ToUpper(j.Format), err)
+	}
+	return file.Close()
+}
+
+// finishJob records the outcome of a job and removes its upload. The result
+// of a job deleted while it ran is removed.
+func (s *server) finishJob(j *job, output string, err error) {
+	os.Remove(j.upload.file)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j.Finished = s.now()
+	if err != nil {
+		j.Status = jobFailed
+		j.Error = err.Error()
+		if s.config.Verbose {
+			log.Printf("Job %s for %s failed: %v\n", j.ID, j.Name, err)
+		}
+		return
+	}
+	j.Status = jobDone
+	j.output = output
+	j.Result = "/jobs/" + j.ID + "/result"
+	if s.jobs[j.ID] != j {
+		os.Remove(output)
+	}
+	if s.config.Verbose {
+		log.Printf("Job %s for %s is done\n", j.ID, j.Name)
+	}
+}
+
+// expireJobs removes the jobs that finished longer than the job TTL ago,
+// until the context is canceled
+func (s *server) expireJobs(ctx context.Context) {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.expire()
+		}
+	}
+}
+
+// expire removes the jobs that finished longer than the job TTL ago
+func (s *server) expire() {
+	s.mu.Lock()
+	var expired []*job
+	for id, j := range s.jobs {
+		if !j.Finished.IsZero() && s.now().Sub(j.Finished) > s.config.JobTTL {
+			delete(s.jobs, id)
+			expired = append(expired, j)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, j := range expired {
+		s.removeResult(j)
+	}
+}
+
+// removeResult removes the result file of a finished job
+func (s *server) removeResult(j *job) {
+	s.mu.Lock()
+	output := j.output
+	s.mu.Unlock()
+	if output != "" {
+		os.Remove(output)
+	}
+}
+
+// findJob returns the job with the given ID, or nil
+func (s *server) findJob(id string) *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+// jobStatus returns a copy of a job that the conversion doesn't change
+func (s *server) jobStatus(j *job) job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *j
+}
+
+// acquire waits for a free conversion slot
+func (s *server) acquire(ctx context.Context) error {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a conversion slot
+func (s *server) release() {
+	<-s.slots
+}
+
+// fail reports an error of a request as JSON
+func (s *server) fail(w http.ResponseWriter, status int, err error) {
+	if s.config.Verbose {
+		log.Printf("Request failed with status %d: %v\n", status, err)
+	}
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// receiveUpload copies the file of a request to the work directory and
+// reads its options. The file is sent as the "file" field of a multipart
+// form or as the raw request body. Options are query parameters or, for
+// multipart forms, also form fields. On error it returns the HTTP status
+// to report.
+func (s *server) receiveUpload(w http.ResponseWriter, r *http.Request) (*upload, int, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+	if s.config.UploadTimeout > 0 {
+		// Writers without deadlines, such as test recorders, are not limited
+		http.NewResponseController(w).SetReadDeadline(time.Now().Add(s.config.UploadTimeout))
+	}
+	values := r.URL.Query()
+
+	file, err := os.CreateTemp(s.workDir, "upload-*.cbz")
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to create upload file: %w", err)
+	}
+
+	var size int64
+	name := values.Get("name")
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		var fileName string
+		size, fileName, err = readMultipart(r, file, values)
+		if fileName != "" {
+			name = fileName
+		}
+	} else {
+		size, err = io.Copy(file, r.Body)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size == 0 {
+		err = fmt.Errorf("no file uploaded")
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("upload is larger than %d bytes", maxErr.Limit)
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, http.StatusRequestTimeout, fmt.Errorf("upload took longer than %s", s.config.UploadTimeout)
+		}
+		return nil, http.StatusBadRequest, fmt.Errorf("failed to receive upload: %w", err)
+	}
+
+	u, err := uploadOptions(s.config, values)
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, http.StatusBadRequest, err
+	}
+	if name == "" {
+		name = "book.cbz"
+	}
+	u.file = file.Name()
+	u.name = sanitizeName(filepath.Base(name))
+	return u, 0, nil
+}
+
+// readMultipart copies the file field of a multipart form to out and adds
+// the other fields to values. It returns the size and name of the file.
+func readMultipart(r *http.Request, out io.Writer, values url.Values) (int64, string, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return 0, "", err
+	}
+
+	var size int64
+	var name string
+	files := 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return size, name, nil
+		}
+		if err != nil {
+			return size, name, err
+		}
+
+		if part.FormName() == uploadField {
+			if files++; files > 1 {
+				return size, name, fmt.Errorf("only one file can be uploaded")
+			}
+			name = part.FileName()
+			if size, err = io.Copy(out, part); err != nil {
+				return size, name, err
+			}
+			continue
+		}
+		value, err := io.ReadAll(io.LimitReader(part, maxFieldSize))
+		if err != nil {
+			return size, name, err
+		}
+		values.Set(part.FormName(), string(value))
+	}
+}
+
+// uploadOptions reads the conversion options of a request. They have the
+// names of the convert flags and default to the flags of the serve command.
+func uploadOptions(defaults Config, values url.Values) (*upload, error) {
+	config := defaults
+	for key, field := range map[string]*string{
+		"format":       &config.Format,
+		"panels":       &config.Panels,
+		"title":        &config.Title,
+		"author":       &config.Author,
+		"language":     &config.Language,
+		"series":       &config.Series,
+		"series-index": &config.SeriesIndex,
+		"publisher":    &config.Publisher,
+		"description":  &config.Description,
+		"tags":         &config.Tags,
+		"isbn":         &config.ISBN,
+		"identifier":   &config.Identifier,
+	} {
+		if values.Has(key) {
+			*field = values.Get(key)
+		}
+	}
+	if values.Has("reproducible") {
+		reproducible, err := strconv.ParseBool(values.Get("reproducible"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid reproducible option: %s", values.Get("reproducible"))
+		}
+		config.Reproducible = reproducible
+	}
+
+	format, err := convertFormat(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMetadata(config); err != nil {
+		return nil, err
+	}
+
+	opts := epub.Options{Reproducible: config.Reproducible, Identifier: bookIdentifier(config)}
+	switch version := values.Get("version"); version {
+	case "":
+	case "2", "3":
+		opts.Version, _ = strconv.Atoi(version)
+		if opts.Version == 2 && (format == "kepub" || format == "kindle") {
+			return nil, fmt.Errorf("EPUB version 2 is not supported for %s", format)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported EPUB version: %s", version)
+	}
+
+	// EPUB 3 output lists the panels too. The default panel mode is left
+	// out for formats that can't use it.
+	if format != "epub" || opts.Version != 3 {
+		if err := checkPanels(config.Panels, format); err != nil {
+			if values.Has("panels") {
+				return nil, err
+			}
+			config.Panels = ""
+		}
+	} else if !isPanelMode(config.Panels) {
+		return nil, fmt.Errorf("unsupported panel mode: %s", config.Panels)
+	}
+	switch compression := values.Get("compression"); compression {
+	case "", "default":
+	case "fast":
+		opts.Compression = epub.CompressionFast
+	case "best":
+		opts.Compression = epub.CompressionBest
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", compression)
+	}
+
+	return &upload{format: format, config: config, opts: opts}, nil
+}
+
+// loadUpload reads an uploaded CBZ file, fills the metadata still missing
+// from its file name and applies the metadata and panel options
+func loadUpload(ctx context.Context, u *upload, progress cbz.ProgressFunc) (*cbz.File, error) {
+	book, err := cbz.ReadFileContext(ctx, u.file, progress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", u.name, err)
+	}
+	book.Name = u.name
+	book.ApplyFileName(nil)
+	applyOverrides(book, u.config)
+	if err := addPanels(book, u.config.Panels); err != nil {
+		return nil, fmt.Errorf("failed to find panels in %s: %w", u.name, err)
+	}
+	return book, nil
+}
+
+// writeStream writes a book in the given output format to w
+func writeStream(ctx context.Context, w io.Writer, book *cbz.File, format string, opts epub.Options) error {
+	switch format {
+	case "kepub":
+		opts.Profile = epub.ProfileKobo
+	case "kindle":
+		opts.Profile = epub.ProfileKindle
+	case "pdf":
+		return pdf.WriteContext(ctx, w, book, opts.Progress)
+	}
+	return epub.WriteContext(ctx, w, book, opts)
+}
+
+// contentType returns the media type of an output format
+func contentType(format string) string {
+	if format == "pdf" {
+		return "application/pdf"
+	}
+	return "application/epub+zip"
+}
+
+// outputName returns the file name of the book converted from an upload
+func outputName(u *upload) string {
+	return strings.TrimSuffix(u.name, filepath.Ext(u.name)) + formatExtension(u.format)
+}
+
+// attachment returns the Content-Disposition header of a download
+func attachment(name string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": name})
+}
@@ -0,0 +1,371 @@
+package cbz2epub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"cbz2epub/cbz"
+	"cbz2epub/epub"
+)
+
+// newTestServer returns a conversion service with its work directory
+func newTestServer(t *testing.T, config Config) (*server, func()) {
+	workDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	if config.MaxUpload == 0 {
+		config.MaxUpload = 1
+	}
+	if config.MaxJobs == 0 {
+		config.MaxJobs = 10
+	}
+	config.Concurrency = 1
+	config.JobTTL = time.Hour
+	return newServer(config, workDir), func() { os.RemoveAll(workDir) }
+}
+
+// testBookData returns a CBZ file with the given number of pages
+func testBookData(t *testing.T, pages int) []byte {
+	cbzFile := &cbz.File{}
+	for i := 0; i < pages; i++ {
+		cbzFile.Images = append(cbzFile.Images, cbz.Image{Name: "page.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"})
+	}
+	var buf bytes.Buffer
+	if err := cbz.Write(&buf, cbzFile); err != nil {
+		t.Fatalf("Failed to create test CBZ file: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// multipartBody returns a multipart form with the file and the fields
+func multipartBody(t *testing.T, name string, data []byte, fields map[string]string) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key, value := range fields {
+		writer.WriteField(key, value)
+	}
+	part, err := writer.CreateFormFile(uploadField, name)
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	part.Write(data)
+	writer.Close()
+	return &body, writer.FormDataContentType()
+}
+
+// TestServeConvert tests converting uploads and streaming the books back
+func TestServeConvert(t *testing.T) {
+	s, cleanup := newTestServer(t, Config{Format: "epub"})
+	defer cleanup()
+	handler := s.handler()
+	data := testBookData(t, 3)
+
+	multipartData, multipartType := multipartBody(t, "Saga v01.cbz", data, map[string]string{"author": "Brian K. Vaughan"})
+
+	testCases := []struct {
+		name        string
+		target      string
+		body        []byte
+		contentType string
+		status      int
+		disposition string
+		title       string
+	}{
+		{
+			name:        "raw body with options",
+			target:      "/convert?name=vol1.cbz&title=First+Volume&format=kepub",
+			body:        data,
+			contentType: "application/zip",
+			status:      http.StatusOK,
+			disposition: `attachment; filename=vol1.kepub.epub`,
+			title:       "First Volume",
+		},
+		{
+			name:        "multipart form",
+			target:      "/convert",
+			body:        multipartData.Bytes(),
+			contentType: multipartType,
+			status:      http.StatusOK,
+			disposition: `attachment; filename="Saga v01.epub"`,
+			title:       "Saga Vol. 1",
+		},
+		{
+			name:        "unsupported format",
+			target:      "/convert?format=mobi",
+			body:        data,
+			contentType: "application/zip",
+			status:      http.StatusBadRequest,
+		},
+		{
+			name:        "panels for EPUB 2",
+			target:      "/convert?panels=grid",
+			body:        data,
+			contentType: "application/zip",
+			status:      http.StatusBadRequest,
+		},
+		{
+			name:        "empty body",
+			target:      "/convert",
+			contentType: "application/zip",
+			status:      http.StatusBadRequest,
+		},
+		{
+			name:        "broken file",
+			target:      "/convert",
+			body:        []byte("not a zip file"),
+			contentType: "application/zip",
+			status:      http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "upload too large",
+			target:      "/convert",
+			body:        make([]byte, 2<<20),
+			contentType: "application/zip",
+			status:      http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.target, bytes.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Fatalf("Expected status %d, got %d: %s", tc.status, rec.Code, rec.Body.String())
+			}
+			if tc.status != http.StatusOK {
+				if !strings.Contains(rec.Body.String(), `"error"`) {
+					t.Errorf("Expected a JSON error, got %s", rec.Body.String())
+				}
+				return
+			}
+			if got := rec.Header().Get("Content-Disposition"); got != tc.disposition {
+				t.Errorf("Expected Content-Disposition %s, got %s", tc.disposition, got)
+			}
+			book, err := epub.Read(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+			if err != nil {
+				t.Fatalf("Failed to read the converted book: %v", err)
+			}
+			if len(book.Images) != 3 || book.ComicInfo.Title != tc.title {
+				t.Errorf("Expected 3 pages titled %q, got %d pages titled %q", tc.title, len(book.Images), book.ComicInfo.Title)
+			}
+		})
+	}
+
+	// Uploads are removed after the conversion
+	if entries, _ := os.ReadDir(s.workDir); len(entries) != 0 {
+		t.Errorf("Expected an empty work directory, got %d entries", len(entries))
+	}
+}
+
+// TestServeJobs tests converting uploads with asynchronous jobs
+func TestServeJobs(t *testing.T) {
+	s, cleanup := newTestServer(t, Config{Format: "epub", MaxJobs: 1})
+	defer cleanup()
+	handler := s.handler()
+
+	request := func(method, target string, body []byte) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, target, bytes.NewReader(body)))
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) job {
+		var j job
+		if err := json.Unmarshal(rec.Body.Bytes(), &j); err != nil {
+			t.Fatalf("Failed to parse job status %s: %v", rec.Body.String(), err)
+		}
+		return j
+	}
+
+	rec := request(http.MethodPost, "/jobs?name=vol1.cbz&format=kindle", testBookData(t, 2))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	created := decode(rec)
+	if rec.Header().Get("Location") != "/jobs/"+created.ID || created.Name != "vol1.cbz" || created.Format != "kindle" {
+		t.Errorf("Unexpected job %+v", created)
+	}
+
+	// Only one job is kept
+	if rec := request(http.MethodPost, "/jobs", testBookData(t, 1)); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 for too many jobs, got %d", rec.Code)
+	}
+
+	// Poll until the job is done
+	var status job
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		status = decode(request(http.MethodGet, "/jobs/"+created.ID, nil))
+		if status.Status == jobDone || status.Status == jobFailed || time.Now().After(deadline) {
+			break
+		}
+	}
+	if status.Status != jobDone || status.Result != "/jobs/"+created.ID+"/result" || status.Progress.Pages != 2 {
+		t.Fatalf("Expected a done job, got %+v", status)
+	}
+
+	rec = request(http.MethodGet, status.Result, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/epub+zip" {
+		t.Fatalf("Expected the EPUB result, got status %d and %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if book, err := epub.Read(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len())); err != nil || len(book.Images) != 2 {
+		t.Errorf("Expected a book with 2 pages, got %v", err)
+	}
+
+	// Deleting a job removes its result
+	if rec := request(http.MethodDelete, "/jobs/"+created.ID, nil); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", rec.Code)
+	}
+	if rec := request(http.MethodGet, "/jobs/"+created.ID, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a deleted job, got %d", rec.Code)
+	}
+	if entries, _ := os.ReadDir(s.workDir); len(entries) != 0 {
+		t.Errorf("Expected an empty work directory, got %d entries", len(entries))
+	}
+}
+
+// trackedBody is a request body that records whether it was read
+type trackedBody struct {
+	io.Reader
+	read bool
+}
+
+// Read reads from the body and records the read
+func (b *trackedBody) Read(p []byte) (int, error) {
+	b.read = true
+	return b.Reader.Read(p)
+}
+
+// TestServeLimits tests that uploads are not received without room for them
+// and don't hold conversion slots
+func TestServeLimits(t *testing.T) {
+	s, cleanup := newTestServer(t, Config{Format: "epub", MaxJobs: 1})
+	defer cleanup()
+	handler := s.handler()
+
+	// A new job is refused while the job list is full
+	s.jobs["queued"] = &job{ID: "queued", Status: jobQueued}
+	body := &trackedBody{Reader: bytes.NewReader(testBookData(t, 1))}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", body))
+	if rec.Code != http.StatusServiceUnavailable || body.read {
+		t.Errorf("Expected status 503 without reading the upload, got %d and read %t", rec.Code, body.read)
+	}
+
+	// A streamed conversion is refused while the upload places are taken
+	s.uploads <- struct{}{}
+	body = &trackedBody{Reader: bytes.NewReader(testBookData(t, 1))}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/convert", body))
+	<-s.uploads
+	if rec.Code != http.StatusServiceUnavailable || body.read {
+		t.Errorf("Expected status 503 without reading the upload, got %d and read %t", rec.Code, body.read)
+	}
+
+	// The upload is received before waiting for a conversion slot, and
+	// removed when the client gives up
+	s.slots <- struct{}{}
+	defer s.release()
+	body = &trackedBody{Reader: bytes.NewReader(testBookData(t, 1))}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/convert", body).WithContext(ctx))
+	if !body.read {
+		t.Errorf("Expected the upload to be received before waiting for a slot")
+	}
+	if entries, _ := os.ReadDir(s.workDir); len(entries) != 0 {
+		t.Errorf("Expected an empty work directory, got %d entries", len(entries))
+	}
+}
+
+// TestServeUploadTimeout tests that slow uploads are cut off
+func TestServeUploadTimeout(t *testing.T) {
+	s, cleanup := newTestServer(t, Config{Format: "epub", UploadTimeout: 200 * time.Millisecond})
+	defer cleanup()
+	httpServer := httptest.NewServer(s.handler())
+	defer httpServer.Close()
+
+	// The client sends a few bytes, then stalls
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	go writer.Write([]byte("PK"))
+	resp, err := http.Post(httpServer.URL+"/convert", "application/zip", reader)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("Expected status 408, got %d", resp.StatusCode)
+	}
+	if entries, _ := os.ReadDir(s.workDir); len(entries) != 0 {
+		t.Errorf("Expected an empty work directory, got %d entries", len(entries))
+	}
+}
+
+// TestServeStop tests that stopping the service cancels the jobs
+func TestServeStop(t *testing.T) {
+	s, cleanup := newTestServer(t, Config{Format: "epub"})
+	defer cleanup()
+	handler := s.handler()
+
+	// The job waits for the only conversion slot
+	s.slots <- struct{}{}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", bytes.NewReader(testBookData(t, 1))))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created job
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to parse job status %s: %v", rec.Body.String(), err)
+	}
+
+	s.stop()
+	if status := s.jobStatus(s.findJob(created.ID)); status.Status != jobFailed {
+		t.Errorf("Expected a failed job after stopping, got %+v", status)
+	}
+	if entries, _ := os.ReadDir(s.workDir); len(entries) != 0 {
+		t.Errorf("Expected an empty work directory, got %d entries", len(entries))
+	}
+}
+
+// TestServeExpire tests that finished jobs are removed after the job TTL
+func TestServeExpire(t *testing.T) {
+	s, cleanup := newTestServer(t, Config{})
+	defer cleanup()
+	now, advance := fakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	s.now = now
+
+	output := s.workDir + "/done.epub"
+	if err := os.WriteFile(output, []byte("book"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	s.jobs["done"] = &job{ID: "done", Status: jobDone, Finished: now(), output: output}
+	s.jobs["running"] = &job{ID: "running", Status: jobRunning}
+
+	advance(30 * time.Minute)
+	s.expire()
+	if len(s.jobs) != 2 {
+		t.Errorf("Expected no expired jobs yet, got %d jobs", len(s.jobs))
+	}
+
+	advance(time.Hour)
+	s.expire()
+	if s.findJob("done") != nil || s.findJob("running") == nil {
+		t.Errorf("Expected only the finished job to expire")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("Expected the result to be removed, got %v", err)
+	}
+}
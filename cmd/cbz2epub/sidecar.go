@@ -0,0 +1,189 @@
+package cbz2epub
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"cbz2epub/cbz"
+	"cbz2epub/epub"
+)
+
+// bookJSON is the generic book.json metadata sidecar
+type bookJSON struct {
+	Title       string      `json:"title"`
+	Authors     []string    `json:"authors"`
+	Series      string      `json:"series"`
+	SeriesIndex json.Number `json:"seriesIndex"`
+	Volume      int         `json:"volume"`
+	Publisher   string      `json:"publisher"`
+	Description string      `json:"description"`
+	Language    string      `json:"language"`
+	Tags        []string    `json:"tags"`
+	ISBN        string      `json:"isbn"`
+	Date        string      `json:"date"`
+	RightToLeft bool        `json:"rightToLeft"`
+}
+
+// comicInfo converts the sidecar to ComicInfo metadata
+func (b bookJSON) comicInfo() *cbz.ComicInfo {
+	comicInfo := &cbz.ComicInfo{
+		Title:       b.Title,
+		Writer:      strings.Join(b.Authors, ", "),
+		Series:      b.Series,
+		Number:      b.SeriesIndex.String(),
+		Volume:      b.Volume,
+		Publisher:   b.Publisher,
+		Summary:     b.Description,
+		LanguageISO: b.Language,
+		Tags:        strings.Join(b.Tags, ", "),
+		GTIN:        b.ISBN,
+	}
+	if b.RightToLeft {
+		comicInfo.Manga = "YesAndRightToLeft"
+	}
+
+	// The date is a year or a full date
+	for i, part := range strings.SplitN(b.Date, "-", 3) {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			break
+		}
+		switch i {
+		case 0:
+			comicInfo.Year = value
+		case 1:
+			comicInfo.Month = value
+		case 2:
+			comicInfo.Day = value
+		}
+	}
+	return comicInfo
+}
+
+// findSidecar returns the first existing file of the candidates, or an
+// empty string
+func findSidecar(candidates ...string) string {
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// singleBook checks if an input file is the only book in its folder. Folder
+// sidecars such as metadata.opf only describe the book when it is.
+func singleBook(inputFile string) bool {
+	entries, err := os.ReadDir(filepath.Dir(inputFile))
+	if err != nil {
+		return false
+	}
+	books := 0
+	for _, entry := range entries {
+		if !entry.IsDir() && isInputFile(entry.Name()) {
+			books++
+		}
+	}
+	return books <= 1
+}
+
+// sidecarCandidates returns the sidecar files of an input file: the file
+// named after it with each extension, followed by the folder files if the
+// input file is the only book in its folder
+func sidecarCandidates(inputFile string, exts []string, folderFiles ...string) []string {
+	base := strings.TrimSuffix(inputFile, filepath.Ext(inputFile))
+	var candidates []string
+	for _, ext := range exts {
+		candidates = append(candidates, base+ext)
+	}
+	if singleBook(inputFile) {
+		for _, name := range folderFiles {
+			candidates = append(candidates, filepath.Join(filepath.Dir(inputFile), name))
+		}
+	}
+	return candidates
+}
+
+// readSidecars reads the metadata of the sidecar files of an input file: a
+// Calibre OPF file, then a JSON file whose fields take precedence. Sidecars
+// named after the input file are preferred over metadata.opf and book.json
+// in its folder, which are only used if the folder holds no other book. It
+// returns nil if there are no sidecars.
+func readSidecars(inputFile string) (*cbz.ComicInfo, error) {
+	var comicInfo *cbz.ComicInfo
+	if opfFile := findSidecar(sidecarCandidates(inputFile, []string{".opf"}, "metadata.opf")...); opfFile != "" {
+		data, err := os.ReadFile(opfFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", opfFile, err)
+		}
+		comicInfo, err = epub.ParseOPF(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", opfFile, err)
+		}
+	}
+
+	if jsonFile := findSidecar(sidecarCandidates(inputFile, []string{".json"}, "book.json")...); jsonFile != "" {
+		data, err := os.ReadFile(jsonFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", jsonFile, err)
+		}
+		var book bookJSON
+		if err := json.Unmarshal(data, &book); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", jsonFile, err)
+		}
+		if comicInfo == nil {
+			comicInfo = &cbz.ComicInfo{}
+		}
+		comicInfo.Override(book.comicInfo())
+	}
+	return comicInfo, nil
+}
+
+// readCover reads the cover image named after the input file, or the one
+// Calibre writes next to the books in its library if the folder holds no
+// other book. It returns nil if there is none.
+func readCover(inputFile string) (*cbz.Image, error) {
+	coverFile := findSidecar(sidecarCandidates(inputFile, []string{".jpg", ".png"}, "cover.jpg", "cover.png")...)
+	if coverFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(coverFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", coverFile, err)
+	}
+	mimeType := "image/jpeg"
+	if strings.ToLower(filepath.Ext(coverFile)) == ".png" {
+		mimeType = "image/png"
+	}
+	return &cbz.Image{Name: filepath.Base(coverFile), Path: coverFile, Data: data, MimeType: mimeType}, nil
+}
+
+// applySidecars replaces the metadata of a book with the metadata of its
+// sidecar files. Broken sidecars are not fatal, the book is converted
+// without them.
+func applySidecars(cbzFile *cbz.File, inputFile string, withCover bool) {
+	sidecar, err := readSidecars(inputFile)
+	if err != nil {
+		log.Printf("Warning: %v\n", err)
+	} else if sidecar != nil {
+		if cbzFile.ComicInfo == nil {
+			cbzFile.ComicInfo = &cbz.ComicInfo{}
+		}
+		cbzFile.ComicInfo.Override(sidecar)
+	}
+
+	if !withCover {
+		return
+	}
+	cover, err := readCover(inputFile)
+	if err != nil {
+		log.Printf("Warning: %v\n", err)
+		return
+	}
+	cbzFile.Cover = cover
+}
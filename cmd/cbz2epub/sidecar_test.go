@@ -0,0 +1,229 @@
+package cbz2epub
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cbz2epub/cbz"
+	"cbz2epub/epub"
+)
+
+// calibreOPF is a metadata.opf file as written by Calibre
+const calibreOPF = `<?xml version='1.0' encoding='utf-8'?>
+<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="uuid_id" version="2.0">
+    <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+        <dc:identifier opf:scheme="calibre" id="calibre_id">42</dc:identifier>
+        <dc:identifier opf:scheme="ISBN">9781607066019</dc:identifier>
+        <dc:title>Saga, Volume 1</dc:title>
+        <dc:creator opf:file-as="Vaughan, Brian K." opf:role="aut">Brian K. Vaughan</dc:creator>
+        <dc:date>0101-01-01T00:00:00+00:00</dc:date>
+        <dc:publisher>Image Comics</dc:publisher>
+        <dc:language>eng</dc:language>
+        <dc:subject>Science Fiction</dc:subject>
+        <meta name="calibre:series" content="Saga"/>
+        <meta name="calibre:series_index" content="1.0"/>
+    </metadata>
+</package>`
+
+// TestReadSidecars tests reading Calibre and JSON sidecar metadata
+func TestReadSidecars(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputFile := filepath.Join(tempDir, "saga.cbz")
+	comicInfo, err := readSidecars(inputFile)
+	if err != nil || comicInfo != nil {
+		t.Fatalf("Expected no sidecar metadata, got %+v, %v", comicInfo, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tempDir, "metadata.opf"), []byte(calibreOPF), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	comicInfo, err = readSidecars(inputFile)
+	if err != nil {
+		t.Fatalf("readSidecars failed: %v", err)
+	}
+	expected := cbz.ComicInfo{
+		Title:       "Saga, Volume 1",
+		Series:      "Saga",
+		Number:      "1",
+		Writer:      "Brian K. Vaughan",
+		Publisher:   "Image Comics",
+		Tags:        "Science Fiction",
+		LanguageISO: "eng",
+		GTIN:        "9781607066019",
+	}
+	if !reflect.DeepEqual(comicInfo.Fields(), expected.Fields()) {
+		t.Errorf("Expected %+v, got %+v", expected.Fields(), comicInfo.Fields())
+	}
+
+	// A JSON sidecar named after the book takes precedence
+	if err := os.WriteFile(filepath.Join(tempDir, "book.json"), []byte(`{"title": "Ignored"}`), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	bookJSON := `{"title": "Saga One", "authors": ["Brian K. Vaughan", "Fiona Staples"], "seriesIndex": 1.5, "date": "2012-10-10", "rightToLeft": true}`
+	if err := os.WriteFile(filepath.Join(tempDir, "saga.json"), []byte(bookJSON), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	comicInfo, err = readSidecars(inputFile)
+	if err != nil {
+		t.Fatalf("readSidecars failed: %v", err)
+	}
+	if comicInfo.Title != "Saga One" || comicInfo.Writer != "Brian K. Vaughan, Fiona Staples" || comicInfo.Number != "1.5" ||
+		comicInfo.Year != 2012 || comicInfo.Day != 10 || comicInfo.Manga != "YesAndRightToLeft" || comicInfo.Publisher != "Image Comics" {
+		t.Errorf("Unexpected merged sidecar metadata: %+v", comicInfo)
+	}
+
+	// Broken sidecars are reported
+	if err := os.WriteFile(filepath.Join(tempDir, "saga.json"), []byte(`{"title": `), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if _, err := readSidecars(inputFile); err == nil {
+		t.Errorf("Expected error for a broken sidecar, got nil")
+	}
+}
+
+// TestFolderSidecars tests that folder sidecars are only used for the only
+// book in a folder
+func TestFolderSidecars(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		"metadata.opf": calibreOPF,
+		"book.json":    `{"title": "Folder Title"}`,
+		"cover.jpg":    "fake cover data",
+		"saga v01.cbz": "",
+		"saga v02.cbz": "",
+		"saga v02.opf": strings.Replace(calibreOPF, "Saga, Volume 1", "Saga, Volume 2", 1),
+		"saga v02.png": "fake cover data",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	// The folder sidecars don't apply to either book
+	first := filepath.Join(tempDir, "saga v01.cbz")
+	comicInfo, err := readSidecars(first)
+	if err != nil || comicInfo != nil {
+		t.Errorf("Expected no sidecar metadata, got %+v, %v", comicInfo, err)
+	}
+	cover, err := readCover(first)
+	if err != nil || cover != nil {
+		t.Errorf("Expected no cover, got %+v, %v", cover, err)
+	}
+
+	// Sidecars named after a book still do
+	second := filepath.Join(tempDir, "saga v02.cbz")
+	comicInfo, err = readSidecars(second)
+	if err != nil {
+		t.Fatalf("readSidecars failed: %v", err)
+	}
+	if comicInfo == nil || comicInfo.Title != "Saga, Volume 2" {
+		t.Errorf("Expected the metadata of saga v02.opf, got %+v", comicInfo)
+	}
+	cover, err = readCover(second)
+	if err != nil {
+		t.Fatalf("readCover failed: %v", err)
+	}
+	if cover == nil || cover.MimeType != "image/png" {
+		t.Errorf("Expected the cover saga v02.png, got %+v", cover)
+	}
+
+	// With one book left, the folder sidecars apply to it
+	if err := os.Remove(second); err != nil {
+		t.Fatalf("Failed to remove test file: %v", err)
+	}
+	comicInfo, err = readSidecars(first)
+	if err != nil {
+		t.Fatalf("readSidecars failed: %v", err)
+	}
+	if comicInfo == nil || comicInfo.Title != "Folder Title" || comicInfo.Series != "Saga" {
+		t.Errorf("Expected the folder metadata, got %+v", comicInfo)
+	}
+	if cover, err := readCover(first); err != nil || cover == nil || cover.Name != "cover.jpg" {
+		t.Errorf("Expected the folder cover, got %+v, %v", cover, err)
+	}
+}
+
+// TestConvertWithSidecars tests that sidecar metadata and covers end up in the EPUB file
+func TestConvertWithSidecars(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputFile := filepath.Join(tempDir, "Saga v01.cbz")
+	cbzFile := &cbz.File{
+		Name:      inputFile,
+		Images:    []cbz.Image{{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}},
+		ComicInfo: &cbz.ComicInfo{Title: "Embedded Title", Summary: "Embedded summary"},
+	}
+	if err := cbz.WriteFile(cbzFile, inputFile); err != nil {
+		t.Fatalf("Failed to create test CBZ file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "metadata.opf"), []byte(calibreOPF), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "cover.jpg"), []byte("fake cover data"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	outputFile := filepath.Join(tempDir, "saga.epub")
+	if _, err := convertFile(inputFile, outputFile, "kepub", Config{Title: "Saga, Vol. 1"}); err != nil {
+		t.Fatalf("convertFile failed: %v", err)
+	}
+
+	book, err := epub.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read EPUB: %v", err)
+	}
+	info := book.ComicInfo
+	if info.Title != "Saga, Vol. 1" || info.Series != "Saga" || info.Writer != "Brian K. Vaughan" || info.Summary != "Embedded summary" {
+		t.Errorf("Unexpected metadata: %+v", info)
+	}
+	if len(book.Images) != 1 {
+		t.Errorf("Expected the cover not to add a page, got %d pages", len(book.Images))
+	}
+
+	zipReader, err := zip.OpenReader(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to open EPUB: %v", err)
+	}
+	defer zipReader.Close()
+	found := false
+	for _, file := range zipReader.File {
+		found = found || file.Name == "OEBPS/images/cover.jpg"
+	}
+	if !found {
+		t.Errorf("Expected the cover image in the EPUB file")
+	}
+
+	// Sidecars can be ignored
+	if _, err := convertFile(inputFile, outputFile, "epub", Config{NoSidecars: true}); err != nil {
+		t.Fatalf("convertFile failed: %v", err)
+	}
+	book, err = epub.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read EPUB: %v", err)
+	}
+	if book.ComicInfo.Title != "Embedded Title" || book.ComicInfo.Writer != "CBZ2EPUB Converter" {
+		t.Errorf("Expected only the embedded metadata, got %+v", book.ComicInfo)
+	}
+}
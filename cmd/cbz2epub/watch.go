@@ -0,0 +1,487 @@
+package cbz2epub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cbz2epub/cbz"
+	"cbz2epub/epub"
+	"cbz2epub/util"
+)
+
+// Default folders of a watched directory, created inside it
+const (
+	watchOutputDir    = "converted"
+	watchProcessedDir = "processed"
+	watchFailedDir    = "failed"
+	watchStateFile    = ".cbz2epub-watch.json"
+)
+
+// watchRecord is the state of an entry of a watched directory that has been
+// handled
+type watchRecord struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Status  string    `json:"status"`
+	Outputs []string  `json:"outputs,omitempty"`
+	MovedTo string    `json:"movedTo,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// watchState is the persistent record of the handled entries, by path
+type watchState struct {
+	Entries map[string]watchRecord `json:"entries"`
+}
+
+// entrySignature identifies the content of an entry, to tell when it
+// stopped changing
+type entrySignature struct {
+	size    int64
+	modTime time.Time
+	files   int
+}
+
+// pendingEntry is an entry that is waiting to stop changing
+type pendingEntry struct {
+	signature entrySignature
+	since     time.Time
+}
+
+// watcher converts the files and folders dropped into watched directories
+type watcher struct {
+	config    Config
+	format    string
+	dirs      []string
+	stateFile string
+	state     watchState
+	pending   map[string]pendingEntry
+	now       func() time.Time
+}
+
+// handleWatchCommand handles the watch command
+func handleWatchCommand(config Config) error {
+	if len(config.InputFiles) == 0 {
+		log.Println("No directories specified")
+		printUsage()
+		return fmt.Errorf("no directories specified")
+	}
+
+	w, err := newWatcher(config)
+	if err != nil {
+		return err
+	}
+
+	// The first interrupt stops watching once the current entry is
+	// handled, a second one exits
+	ctx, stop := util.InterruptContext(context.Background())
+	defer stop()
+	return w.run(ctx)
+}
+
+// newWatcher creates a watcher for the directories of the configuration and
+// loads its state file
+func newWatcher(config Config) (*watcher, error) {
+	format, err := convertFormat(config)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &watcher{config: config, format: format, pending: map[string]pendingEntry{}, now: time.Now}
+	for _, dir := range config.InputFiles {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to access %s: %w", dir, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", dir)
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		w.dirs = append(w.dirs, abs)
+	}
+
+	w.stateFile = config.StateFile
+	if w.stateFile == "" {
+		w.stateFile = filepath.Join(w.dirs[0], watchStateFile)
+	}
+	if err := w.loadState(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// run watches the directories until the context is done. Changes are
+// noticed with inotify on Linux and by scanning every interval otherwise.
+// With -once, it returns when the entries found at the start are handled.
+func (w *watcher) run(ctx context.Context) error {
+	var changes <-chan struct{}
+	if !w.config.Poll {
+		events, stop, err := watchChanges(w.dirs)
+		if err != nil {
+			log.Printf("Warning: %v, scanning every %s\n", err, w.config.Interval)
+		} else {
+			defer stop()
+			changes = events
+		}
+	}
+
+	log.Printf("Watching %s\n", strings.Join(w.dirs, ", "))
+	for {
+		w.scan()
+		if w.config.Once && len(w.pending) == 0 {
+			return nil
+		}
+
+		// Entries that are still changing are checked again after the
+		// settle time, even if nothing else happens
+		wait := w.config.Interval
+		if len(w.pending) > 0 {
+			wait = min(wait, max(w.config.Settle, time.Second))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Println("Stopping")
+			return nil
+		case <-changes:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// scan looks for new entries in the watched directories and handles the
+// entries that stopped changing
+func (w *watcher) scan() {
+	seen := map[string]bool{}
+	for _, dir := range w.dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			log.Printf("Error reading %s: %v\n", dir, err)
+			continue
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if w.ignored(dir, path) {
+				continue
+			}
+			signature, ok := entrySignatureOf(path)
+			if !ok {
+				continue
+			}
+			seen[path] = true
+
+			// Entries with the content of a handled entry are left alone
+			if record, ok := w.state.Entries[path]; ok && record.Size == signature.size && record.ModTime.Equal(signature.modTime) {
+				delete(w.pending, path)
+				continue
+			}
+
+			// Entries are handled once they stopped changing for the
+			// settle time
+			now := w.now()
+			pending, ok := w.pending[path]
+			if !ok || pending.signature != signature {
+				w.pending[path] = pendingEntry{signature: signature, since: now}
+				if w.config.Verbose && !ok {
+					log.Printf("Found %s\n", path)
+				}
+				if w.config.Settle > 0 {
+					continue
+				}
+			} else if now.Sub(pending.since) < w.config.Settle {
+				continue
+			}
+			delete(w.pending, path)
+			w.handle(dir, path, signature)
+		}
+	}
+
+	// Forget entries that disappeared before they were handled
+	for path := range w.pending {
+		if !seen[path] {
+			delete(w.pending, path)
+		}
+	}
+}
+
+// ignored checks if an entry of a watched directory is not an input: hidden
+// files, such as the temporary files of conversions, and the output folders
+func (w *watcher) ignored(dir, path string) bool {
+	if strings.HasPrefix(filepath.Base(path), ".") || path == w.stateFile {
+		return true
+	}
+	outputDir, processedDir, failedDir := w.folders(dir)
+	for _, folder := range []string{outputDir, processedDir, failedDir} {
+		if path == folder {
+			return true
+		}
+	}
+	return false
+}
+
+// folders returns the output, processed and failed folders of a watched
+// directory
+func (w *watcher) folders(dir string) (string, string, string) {
+	folder := func(value, name string) string {
+		if value == "" {
+			return filepath.Join(dir, name)
+		}
+		if abs, err := filepath.Abs(value); err == nil {
+			return abs
+		}
+		return value
+	}
+	return folder(w.config.OutputDir, watchOutputDir),
+		folder(w.config.Processed, watchProcessedDir),
+		folder(w.config.Failed, watchFailedDir)
+}
+
+// entrySignatureOf returns the signature of an input file or of a folder
+// with input files, and false for other entries
+func entrySignatureOf(path string) (entrySignature, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return entrySignature{}, false
+	}
+	if !info.IsDir() {
+		if !isInputFile(path) {
+			return entrySignature{}, false
+		}
+		return entrySignature{size: info.Size(), modTime: info.ModTime(), files: 1}, true
+	}
+
+	// Folders change while any file in them changes
+	var signature entrySignature
+	filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		signature.size += info.Size()
+		if info.ModTime().After(signature.modTime) {
+			signature.modTime = info.ModTime()
+		}
+		if isInputFile(file) {
+			signature.files++
+		}
+		return nil
+	})
+	return signature, signature.files > 0
+}
+
+// handle converts an entry of a watched directory, moves it to the
+// processed or failed folder and records it in the state file
+func (w *watcher) handle(dir, path string, signature entrySignature) {
+	outputDir, processedDir, failedDir := w.folders(dir)
+	outputs, err := w.convert(dir, path, outputDir)
+
+	record := watchRecord{
+		Size:    signature.size,
+		ModTime: signature.modTime,
+		Status:  statusConverted,
+		Outputs: outputs,
+		Time:    w.now(),
+	}
+	target := processedDir
+	if err != nil {
+		log.Printf("Error converting %s: %v\n", path, err)
+		record.Status = statusFailed
+		record.Error = err.Error()
+		target = failedDir
+	} else {
+		log.Printf("Successfully converted %s to %s\n", path, strings.Join(outputs, ", "))
+	}
+
+	// Entries that can't be moved stay in place, the record keeps them
+	// from being converted again
+	movedTo, err := moveInto(path, target)
+	if err != nil {
+		log.Printf("Warning: %v\n", err)
+	} else {
+		record.MovedTo = movedTo
+	}
+
+	w.state.Entries[path] = record
+	if err := w.saveState(); err != nil {
+		log.Printf("Warning: %v\n", err)
+	}
+}
+
+// convert converts an input file, or the input files of a folder. Folders
+// are merged into one book with -merge-folders, named after the folder.
+func (w *watcher) convert(dir, path, outputDir string) ([]string, error) {
+	config := w.config
+	config.OutputDir = outputDir
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		outputFile := outputPath(path, dir, w.format, config)
+		if _, err := convertFile(path, outputFile, w.format, config); err != nil {
+			return nil, err
+		}
+		return []string{outputFile}, nil
+	}
+
+	var inputFiles []string
+	filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() && isInputFile(file) && !strings.HasPrefix(entry.Name(), ".") {
+			inputFiles = append(inputFiles, file)
+		}
+		return nil
+	})
+	sort.Strings(inputFiles)
+
+	if config.MergeFolders {
+		outputFile, err := w.merge(path, inputFiles, outputDir)
+		if err != nil {
+			return nil, err
+		}
+		return []string{outputFile}, nil
+	}
+
+	// The files of a folder keep their folder in the output directory
+	var outputs []string
+	for _, inputFile := range inputFiles {
+		outputFile := outputPath(inputFile, dir, w.format, config)
+		if _, err := convertFile(inputFile, outputFile, w.format, config); err != nil {
+			return outputs, err
+		}
+		outputs = append(outputs, outputFile)
+	}
+	return outputs, nil
+}
+
+// merge merges the input files of a folder in file name order and writes
+// the book in the output format. The files are merged one at a time into a
+// temporary CBZ file, which is then converted.
+func (w *watcher) merge(folder string, inputFiles []string, outputDir string) (string, error) {
+	first, err := loadBook(inputFiles[0], w.config)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", inputFiles[0], err)
+	}
+
+	// The book is named after the folder, or after the name template
+	ext := formatExtension(w.format)
+	name := filepath.Base(folder) + ext
+	if w.config.NameTemplate != "" {
+		name = expandName(w.config.NameTemplate, templateFields(folder+".cbz", first.ComicInfo), ext)
+	}
+	outputFile := filepath.Join(outputDir, name)
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	tempDir, err := os.MkdirTemp("", "cbz2epub-merge")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	util.RemoveOnInterrupt(tempDir)
+	defer os.RemoveAll(tempDir)
+
+	ctx := context.Background()
+	mergedFile := filepath.Join(tempDir, filepath.Base(folder)+".cbz")
+	merger, err := cbz.NewMerger(mergedFile, nil)
+	if err != nil {
+		return "", err
+	}
+	defer merger.Abort()
+	if err := mergeBooks(ctx, merger, first, inputFiles[1:], w.config); err != nil {
+		return "", err
+	}
+
+	merged, err := cbz.ReadFileContext(ctx, mergedFile, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to read merged file: %w", err)
+	}
+	if err := addPanels(merged, w.config.Panels); err != nil {
+		return "", fmt.Errorf("failed to find panels in %s: %w", folder, err)
+	}
+	err = writeBook(merged, outputFile, w.format, epub.Options{Reproducible: w.config.Reproducible})
+	if err != nil {
+		return "", fmt.Errorf("failed to convert to %s: %w", strings.ToUpper(w.format), err)
+	}
+	return outputFile, nil
+}
+
+// moveInto moves a file or folder into a directory, adding a number to its
+// name if the directory already has an entry of that name
+func moveInto(path, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		ext = ""
+	}
+	base := strings.TrimSuffix(name, ext)
+	target := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			break
+		}
+		target = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+	}
+
+	if err := os.Rename(path, target); err != nil {
+		return "", fmt.Errorf("failed to move %s to %s: %w", path, dir, err)
+	}
+	return target, nil
+}
+
+// loadState reads the state file, if there is one
+func (w *watcher) loadState() error {
+	w.state = watchState{Entries: map[string]watchRecord{}}
+	data, err := os.ReadFile(w.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err := json.Unmarshal(data, &w.state); err != nil {
+		return fmt.Errorf("failed to parse state file %s: %w", w.stateFile, err)
+	}
+	if w.state.Entries == nil {
+		w.state.Entries = map[string]watchRecord{}
+	}
+	return nil
+}
+
+// saveState writes the state file
+func (w *watcher) saveState() error {
+	data, err := json.MarshalIndent(w.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state file: %w", err)
+	}
+
+	file, err := util.CreateAtomic(w.stateFile)
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer file.Abort()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return file.Commit()
+}
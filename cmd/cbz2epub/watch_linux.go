@@ -0,0 +1,57 @@
+//go:build linux
+
+package cbz2epub
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// watchEvents are the inotify events that change the entries of a directory
+const watchEvents = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY |
+	syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE
+
+// watchChanges notifies of changes in the directories with inotify. The
+// channel receives a value after one or more changes; the function stops
+// watching and waits until the events are no longer read.
+func watchChanges(dirs []string) (<-chan struct{}, func(), error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start inotify: %w", err)
+	}
+	for _, dir := range dirs {
+		if _, err := syscall.InotifyAddWatch(fd, dir, watchEvents); err != nil {
+			syscall.Close(fd)
+			return nil, nil, fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+
+	// The non-blocking descriptor is read through the runtime poller, so
+	// closing the file wakes up a pending read
+	file := os.NewFile(uintptr(fd), "inotify")
+
+	// Events are only used as a signal to scan, so they are not parsed and
+	// changes that arrive while a scan is pending are merged
+	changes := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := file.Read(buf)
+			if err != nil || n <= 0 {
+				return
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	stop := func() {
+		file.Close()
+		<-done
+	}
+	return changes, stop, nil
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+package cbz2epub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWatchChanges tests that inotify reports new files and stops
+func TestWatchChanges(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	changes, stop, err := watchChanges([]string{tempDir})
+	if err != nil {
+		t.Skipf("inotify is not available: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tempDir, "new.cbz"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected a change notification for a new file")
+	}
+
+	// Stopping ends the goroutine reading the events
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected stop to end reading the events")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package cbz2epub
+
+import "errors"
+
+// watchChanges is only supported on Linux, other systems scan the
+// directories every interval
+func watchChanges(dirs []string) (<-chan struct{}, func(), error) {
+	return nil, nil, errors.New("change notifications are not supported on this system")
+}
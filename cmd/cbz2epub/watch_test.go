@@ -0,0 +1,211 @@
+package cbz2epub
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cbz2epub/cbz"
+	"cbz2epub/epub"
+)
+
+// writeTestBook writes a CBZ file with the given number of pages
+func writeTestBook(t *testing.T, filename string, pages int) {
+	cbzFile := &cbz.File{Name: filename}
+	for i := 0; i < pages; i++ {
+		cbzFile.Images = append(cbzFile.Images, cbz.Image{Name: filepath.Base(filename) + "_page.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"})
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := cbz.WriteFile(cbzFile, filename); err != nil {
+		t.Fatalf("Failed to create test CBZ file: %v", err)
+	}
+}
+
+// TestWatchOnce tests converting the files and folders of a watched directory
+func TestWatchOnce(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	writeTestBook(t, filepath.Join(tempDir, "single.cbz"), 2)
+	writeTestBook(t, filepath.Join(tempDir, "Series", "ch1.cbz"), 2)
+	writeTestBook(t, filepath.Join(tempDir, "Series", "ch2.cbz"), 3)
+	if err := os.WriteFile(filepath.Join(tempDir, "broken.cbz"), []byte("not a zip file"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	config := Config{InputFiles: []string{tempDir}, MergeFolders: true, Once: true, Poll: true, Interval: time.Second}
+	w, err := newWatcher(config)
+	if err != nil {
+		t.Fatalf("newWatcher failed: %v", err)
+	}
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	// The folder is merged into one book
+	book, err := epub.ReadFile(filepath.Join(tempDir, "converted", "Series.epub"))
+	if err != nil {
+		t.Fatalf("Failed to read merged book: %v", err)
+	}
+	if len(book.Images) != 5 {
+		t.Errorf("Expected 5 pages in the merged book, got %d", len(book.Images))
+	}
+
+	// Originals are moved, other files are left alone
+	for _, path := range []string{
+		"converted/single.epub",
+		"processed/single.cbz",
+		"processed/Series/ch2.cbz",
+		"failed/broken.cbz",
+		"notes.txt",
+	} {
+		if _, err := os.Stat(filepath.Join(tempDir, path)); err != nil {
+			t.Errorf("Expected %s: %v", path, err)
+		}
+	}
+
+	// The state file records every entry
+	data, err := os.ReadFile(filepath.Join(tempDir, watchStateFile))
+	if err != nil {
+		t.Fatalf("Failed to read state file: %v", err)
+	}
+	var state watchState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("Failed to parse state file: %v", err)
+	}
+	expected := map[string]string{"single.cbz": statusConverted, "Series": statusConverted, "broken.cbz": statusFailed}
+	if len(state.Entries) != len(expected) {
+		t.Errorf("Expected %d entries, got %+v", len(expected), state.Entries)
+	}
+	for name, status := range expected {
+		record := state.Entries[filepath.Join(w.dirs[0], name)]
+		if record.Status != status || record.MovedTo == "" {
+			t.Errorf("Unexpected record for %s: %+v", name, record)
+		}
+	}
+}
+
+// TestWatchSettle tests that files are only converted once they stopped changing
+func TestWatchSettle(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputFile := filepath.Join(tempDir, "growing.cbz")
+	writeTestBook(t, inputFile, 1)
+
+	w, err := newWatcher(Config{InputFiles: []string{tempDir}, Settle: 5 * time.Second, Interval: time.Second})
+	if err != nil {
+		t.Fatalf("newWatcher failed: %v", err)
+	}
+	now, advance := fakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	w.now = now
+	converted := filepath.Join(tempDir, "converted", "growing.epub")
+
+	w.scan()
+	advance(3 * time.Second)
+
+	// The file grows, so it has to settle again
+	writeTestBook(t, inputFile, 2)
+	if err := os.Chtimes(inputFile, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Failed to change file time: %v", err)
+	}
+	w.scan()
+	advance(3 * time.Second)
+	w.scan()
+	if _, err := os.Stat(converted); !os.IsNotExist(err) {
+		t.Fatalf("Expected no conversion while the file changes, got %v", err)
+	}
+
+	advance(3 * time.Second)
+	w.scan()
+	if _, err := os.Stat(converted); err != nil {
+		t.Errorf("Expected the file to be converted after settling: %v", err)
+	}
+}
+
+// TestWatchState tests that recorded entries are not converted again
+func TestWatchState(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputFile := filepath.Join(tempDir, "handled.cbz")
+	writeTestBook(t, inputFile, 1)
+	info, err := os.Stat(inputFile)
+	if err != nil {
+		t.Fatalf("Failed to stat test file: %v", err)
+	}
+
+	// A file that could not be moved after its conversion
+	stateFile := filepath.Join(tempDir, "state.json")
+	state := watchState{Entries: map[string]watchRecord{
+		inputFile: {Size: info.Size(), ModTime: info.ModTime(), Status: statusConverted},
+	}}
+	data, _ := json.Marshal(state)
+	if err := os.WriteFile(stateFile, data, 0644); err != nil {
+		t.Fatalf("Failed to write state file: %v", err)
+	}
+
+	w, err := newWatcher(Config{InputFiles: []string{tempDir}, StateFile: stateFile, Once: true, Poll: true, Interval: time.Second})
+	if err != nil {
+		t.Fatalf("newWatcher failed: %v", err)
+	}
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if _, err := os.Stat(inputFile); err != nil {
+		t.Errorf("Expected the handled file to stay in place: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "converted")); !os.IsNotExist(err) {
+		t.Errorf("Expected no conversion of a handled file, got %v", err)
+	}
+
+	// A broken state file is an error
+	if err := os.WriteFile(stateFile, []byte("{"), 0644); err != nil {
+		t.Fatalf("Failed to write state file: %v", err)
+	}
+	if _, err := newWatcher(Config{InputFiles: []string{tempDir}, StateFile: stateFile}); err == nil {
+		t.Errorf("Expected an error for a broken state file")
+	}
+}
+
+// TestMoveInto tests moving originals without replacing earlier ones
+func TestMoveInto(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "cbz2epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	targetDir := filepath.Join(tempDir, "processed")
+	expected := []string{"book.cbz", "book (1).cbz", "book (2).cbz"}
+	for _, name := range expected {
+		path := filepath.Join(tempDir, "book.cbz")
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		moved, err := moveInto(path, targetDir)
+		if err != nil {
+			t.Fatalf("moveInto failed: %v", err)
+		}
+		if moved != filepath.Join(targetDir, name) {
+			t.Errorf("Expected %s, got %s", name, moved)
+		}
+	}
+}
@@ -3,8 +3,12 @@ package epub
 import (
 	"archive/zip"
 	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/xml"
 	"fmt"
-	"os"
+	"image"
+	"io"
 	"path/filepath"
 	"strings"
 	"time"
@@ -13,22 +17,148 @@ import (
 	"cbz2epub/util"
 )
 
+// Profile selects device-specific EPUB output
+type Profile string
+
+const (
+	// ProfileDefault writes a plain EPUB 2 file
+	ProfileDefault Profile = ""
+	// ProfileKobo writes a Kobo KEPUB with fixed-layout settings
+	ProfileKobo Profile = "kobo"
+	// ProfileKindle writes a fixed-layout EPUB with the Amazon comic metadata
+	// and panel view regions, ready for conversion to KF8
+	ProfileKindle Profile = "kindle"
+)
+
+// sourceHashMeta is the name of the OPF meta element holding the source hash
+const sourceHashMeta = "cbz2epub:source-hash"
+
+// Options controls how an EPUB file is written
+type Options struct {
+	Profile Profile
+	// SourceHash identifies the content of the source file, so a later
+	// conversion can tell if the EPUB file is up to date
+	SourceHash string
+	// Reproducible writes the same file for the same book on every run
+	Reproducible bool
+	// Identifier replaces the identifier derived from the book, such as
+	// urn:isbn:9781607066019
+	Identifier string
+	// Version is the EPUB version, 2 or 3. Zero selects the version of the
+	// profile: EPUB 2 by default, EPUB 3 for the fixed-layout profiles.
+	Version int
+	// Metadata replaces the fields of the book metadata that are set
+	Metadata *cbz.ComicInfo
+	// Images are applied in order to every page image before it is
+	// written, for example to resize or recompress the pages
+	Images []ImageFilter
+	// Compression selects how the entries of the EPUB file are compressed
+	Compression Compression
+	// Progress receives the pages processed and written
+	Progress cbz.ProgressFunc
+}
+
+// ImageFilter transforms a page image. It returns the new image with its
+// name and MIME type updated if the format changes.
+type ImageFilter func(image cbz.Image) (cbz.Image, error)
+
+// Compression selects how the entries of an EPUB file are compressed
+type Compression int
+
+const (
+	// CompressionDefault deflates every entry
+	CompressionDefault Compression = iota
+	// CompressionFast stores the images, which are compressed already, and
+	// deflates the other entries
+	CompressionFast
+	// CompressionBest deflates every entry with the best compression level
+	CompressionBest
+)
+
+// pageSize holds the dimensions of a page image, zero if unknown
+type pageSize struct {
+	width, height int
+}
+
 // ConvertFromCBZ converts a CBZ file to EPUB format
 func ConvertFromCBZ(cbzFile *cbz.File, outputFile string) error {
-	// Create a new zip file for the EPUB
-	zipFile, err := os.Create(outputFile)
+	return ConvertWithOptions(cbzFile, outputFile, Options{})
+}
+
+// ConvertWithOptions converts a CBZ file to EPUB format using the given options
+func ConvertWithOptions(cbzFile *cbz.File, outputFile string, opts Options) error {
+	return ConvertContext(context.Background(), cbzFile, outputFile, opts)
+}
+
+// ConvertContext converts a CBZ file to EPUB format like ConvertWithOptions.
+// The conversion stops between pages when the context is done, and the
+// output file is left untouched.
+func ConvertContext(ctx context.Context, cbzFile *cbz.File, outputFile string, opts Options) error {
+	// Create a new zip file for the EPUB. It is written to a temporary file
+	// that replaces the output file when complete.
+	zipFile, err := util.CreateAtomic(outputFile)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
-	defer zipFile.Close()
+	defer zipFile.Abort()
+
+	if err := WriteContext(ctx, zipFile, cbzFile, opts); err != nil {
+		return err
+	}
+	return zipFile.Commit()
+}
+
+// Write writes a book as an EPUB file to w. The book is not modified; the
+// metadata and image filters of the options apply to a copy.
+func Write(w io.Writer, cbzFile *cbz.File, opts Options) error {
+	return WriteContext(context.Background(), w, cbzFile, opts)
+}
+
+// WriteContext writes a book as an EPUB file to w like Write. Writing stops
+// between pages when the context is done.
+func WriteContext(ctx context.Context, w io.Writer, cbzFile *cbz.File, opts Options) error {
+	fixedLayout := opts.Profile == ProfileKobo || opts.Profile == ProfileKindle
+	switch {
+	case opts.Version != 0 && opts.Version != 2 && opts.Version != 3:
+		return fmt.Errorf("unsupported EPUB version: %d", opts.Version)
+	case opts.Version == 2 && fixedLayout:
+		return fmt.Errorf("the %s profile requires EPUB 3", opts.Profile)
+	}
+	cbzFile, err := prepareBook(ctx, cbzFile, opts)
+	if err != nil {
+		return err
+	}
 
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
+	// Reproducible books get their dates from the metadata or
+	// SOURCE_DATE_EPOCH
+	now := time.Now()
+	if opts.Reproducible {
+		now = reproducibleTime(cbzFile.ComicInfo)
+	}
+	rawIdentifier := bookIdentifier(cbzFile, opts)
+	identifier := xmlEscape(rawIdentifier)
+
+	// All entries carry the same modification time
+	counter := &util.CountingWriter{W: w}
+	zipWriter := zip.NewWriter(counter)
+	if opts.Compression == CompressionBest {
+		zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, flate.BestCompression)
+		})
+	}
+	createEntry := func(name string) (io.Writer, error) {
+		method := zip.Deflate
+		if opts.Compression == CompressionFast && strings.HasPrefix(name, "OEBPS/images/") {
+			method = zip.Store
+		}
+		return zipWriter.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: now})
+	}
 
 	// Add mimetype file (must be first and uncompressed)
 	mimetypeWriter, err := zipWriter.CreateHeader(&zip.FileHeader{
-		Name:   "mimetype",
-		Method: zip.Store, // No compression
+		Name:     "mimetype",
+		Method:   zip.Store, // No compression
+		Modified: now,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create mimetype file: %w", err)
@@ -39,7 +169,7 @@ func ConvertFromCBZ(cbzFile *cbz.File, outputFile string) error {
 	}
 
 	// Add META-INF/container.xml
-	containerWriter, err := zipWriter.Create("META-INF/container.xml")
+	containerWriter, err := createEntry("META-INF/container.xml")
 	if err != nil {
 		return fmt.Errorf("failed to create container.xml: %w", err)
 	}
@@ -53,35 +183,144 @@ func ConvertFromCBZ(cbzFile *cbz.File, outputFile string) error {
 		return fmt.Errorf("failed to write container.xml: %w", err)
 	}
 
+	// Fixed-layout output and the panel regions of EPUB 3 need the size of
+	// every page
+	epub3 := fixedLayout || opts.Version == 3
+	hasPanels := false
+	if epub3 {
+		for _, image := range cbzFile.Images {
+			hasPanels = hasPanels || len(image.Panels) > 0
+		}
+	}
+	sizes := make([]pageSize, len(cbzFile.Images))
+	var maxSize pageSize
+	landscape := false
+	if fixedLayout || hasPanels {
+		for i, image := range cbzFile.Images {
+			if config, _, err := image.DecodeConfig(); err == nil {
+				sizes[i] = pageSize{config.Width, config.Height}
+				maxSize.width = max(maxSize.width, config.Width)
+				maxSize.height = max(maxSize.height, config.Height)
+				landscape = landscape || config.Width > config.Height
+			}
+		}
+	}
+
 	// Create content.opf
-	title := strings.TrimSuffix(filepath.Base(cbzFile.Name), ".cbz")
-	date := time.Now().Format("2006-01-02")
-	uuid := util.GenerateUUID()
-	contentOPF := bytes.NewBufferString(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+	title := xmlEscape(cbzFile.Title())
+	date := publicationDate(cbzFile.ComicInfo, now)
+	contentOPF := &bytes.Buffer{}
+	if epub3 {
+		contentOPF.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="BookID" version="3.0" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+`)
+	} else {
+		contentOPF.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
 <package xmlns="http://www.idpf.org/2007/opf" unique-identifier="BookID" version="2.0">
-  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+`)
+	}
+	contentOPF.WriteString(fmt.Sprintf(`  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
     <dc:title>%s</dc:title>
-    <dc:language>en</dc:language>
-    <dc:identifier id="BookID">urn:uuid:%s</dc:identifier>
+    <dc:language>%s</dc:language>
+    <dc:identifier id="BookID">%s</dc:identifier>
     <dc:date>%s</dc:date>
-    <dc:creator>CBZ2EPUB Converter</dc:creator>
-  </metadata>
+`, title, xmlEscape(bookLanguage(cbzFile.ComicInfo)), identifier, date))
+	contentOPF.WriteString(metadataElements(cbzFile.ComicInfo, epub3, rawIdentifier))
+	if epub3 {
+		contentOPF.WriteString(fmt.Sprintf(`    <meta property="dcterms:modified">%s</meta>
+`, now.UTC().Format("2006-01-02T15:04:05Z")))
+	}
+	if opts.SourceHash != "" {
+		contentOPF.WriteString(fmt.Sprintf(`    <meta name="%s" content="%s"/>
+`, sourceHashMeta, xmlEscape(opts.SourceHash)))
+	}
+	if fixedLayout {
+		contentOPF.WriteString(`    <meta property="rendition:layout">pre-paginated</meta>
+    <meta property="rendition:orientation">auto</meta>
+    <meta property="rendition:spread">landscape</meta>
+`)
+	}
+	if cbzFile.Cover != nil {
+		contentOPF.WriteString(`    <meta name="cover" content="cover"/>
+`)
+	} else if fixedLayout {
+		contentOPF.WriteString(`    <meta name="cover" content="image001"/>
+`)
+	}
+	if opts.Profile == ProfileKindle {
+		writingMode := "horizontal-lr"
+		if cbzFile.RightToLeft() {
+			writingMode = "horizontal-rl"
+		}
+		orientation := "portrait"
+		if landscape {
+			orientation = "none"
+		}
+		contentOPF.WriteString(fmt.Sprintf(`    <meta name="fixed-layout" content="true"/>
+    <meta name="original-resolution" content="%dx%d"/>
+    <meta name="book-type" content="comic"/>
+    <meta name="primary-writing-mode" content="%s"/>
+    <meta name="zero-gutter" content="true"/>
+    <meta name="zero-margin" content="true"/>
+    <meta name="ke-border-color" content="#FFFFFF"/>
+    <meta name="ke-border-width" content="0"/>
+    <meta name="orientation-lock" content="%s"/>
+    <meta name="region-mag" content="%t"/>
+`, maxSize.width, maxSize.height, writingMode, orientation, hasPanels))
+	}
+	contentOPF.WriteString(`  </metadata>
   <manifest>
     <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
-`, title, uuid, date))
+`)
+	if epub3 {
+		contentOPF.WriteString(`    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+`)
+	}
+	if epub3 && hasPanels {
+		contentOPF.WriteString(`    <item id="regions" href="regions.xhtml" media-type="application/xhtml+xml" properties="data-nav"/>
+`)
+	}
+
+	// Add a separate cover image to the manifest
+	if cover := cbzFile.Cover; cover != nil {
+		coverName := "cover" + filepath.Ext(cover.Name)
+		properties := ""
+		if epub3 {
+			properties = ` properties="cover-image"`
+		}
+		contentOPF.WriteString(fmt.Sprintf(`    <item id="cover" href="images/%s" media-type="%s"%s/>
+`, coverName, cover.MimeType, properties))
+
+		coverWriter, err := createEntry("OEBPS/images/" + coverName)
+		if err != nil {
+			return fmt.Errorf("failed to create cover image: %w", err)
+		}
+		if _, err := coverWriter.Write(cover.Data); err != nil {
+			return fmt.Errorf("failed to write cover image: %w", err)
+		}
+	}
 
 	// Add each image to the manifest
 	for i, image := range cbzFile.Images {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		// Create a new name for the image to avoid conflicts
 		ext := filepath.Ext(image.Name)
 		newName := fmt.Sprintf("image%03d%s", i+1, ext)
 
-		// Add to manifest
-		contentOPF.WriteString(fmt.Sprintf(`    <item id="image%03d" href="images/%s" media-type="%s"/>
-`, i+1, newName, image.MimeType))
+		// Add to manifest, marking the first image as cover unless there is
+		// a separate cover image
+		properties := ""
+		if epub3 && i == 0 && cbzFile.Cover == nil {
+			properties = ` properties="cover-image"`
+		}
+		contentOPF.WriteString(fmt.Sprintf(`    <item id="image%03d" href="images/%s" media-type="%s"%s/>
+`, i+1, newName, image.MimeType, properties))
 
 		// Add to EPUB
-		imageWriter, err := zipWriter.Create("OEBPS/images/" + newName)
+		imageWriter, err := createEntry("OEBPS/images/" + newName)
 		if err != nil {
 			return fmt.Errorf("failed to create image file: %w", err)
 		}
@@ -89,6 +328,7 @@ func ConvertFromCBZ(cbzFile *cbz.File, outputFile string) error {
 		if err != nil {
 			return fmt.Errorf("failed to write image data: %w", err)
 		}
+		opts.Progress.Report(cbz.Progress{Stage: cbz.StageWrite, Pages: i + 1, TotalPages: len(cbzFile.Images), Bytes: counter.N})
 	}
 
 	// Create HTML pages for each image
@@ -103,36 +343,34 @@ func ConvertFromCBZ(cbzFile *cbz.File, outputFile string) error {
 `, i+1, pageName))
 
 		// Add HTML page to EPUB
-		pageWriter, err := zipWriter.Create("OEBPS/pages/" + pageName)
+		pageWriter, err := createEntry("OEBPS/pages/" + pageName)
 		if err != nil {
 			return fmt.Errorf("failed to create page file: %w", err)
 		}
 
 		// Write HTML content
-		_, err = pageWriter.Write([]byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN" "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd">
-<html xmlns="http://www.w3.org/1999/xhtml">
-<head>
-  <title>Page %d</title>
-  <style type="text/css">
-    img { max-width: 100%%; max-height: 100%%; }
-    body { margin: 0; padding: 0; text-align: center; }
-  </style>
-</head>
-<body>
-  <div>
-    <img src="../images/%s" alt="Page %d" />
-  </div>
-</body>
-</html>`, i+1, newName, i+1)))
+		var page string
+		switch opts.Profile {
+		case ProfileKobo:
+			page = koboPage(i+1, newName, sizes[i])
+		case ProfileKindle:
+			page = kindlePage(i+1, newName, sizes[i], image.Panels)
+		default:
+			page = defaultPage(i+1, newName, epub3)
+		}
+		_, err = pageWriter.Write([]byte(page))
 		if err != nil {
 			return fmt.Errorf("failed to write page content: %w", err)
 		}
 	}
 
 	// Finish content.opf with spine
+	spine := `  <spine toc="ncx">`
+	if epub3 && cbzFile.RightToLeft() {
+		spine = `  <spine toc="ncx" page-progression-direction="rtl">`
+	}
 	contentOPF.WriteString(`  </manifest>
-  <spine toc="ncx">
+` + spine + `
 `)
 	for i := range cbzFile.Images {
 		contentOPF.WriteString(fmt.Sprintf(`    <itemref idref="page%03d"/>
@@ -142,7 +380,7 @@ func ConvertFromCBZ(cbzFile *cbz.File, outputFile string) error {
 </package>`)
 
 	// Add content.opf to EPUB
-	contentWriter, err := zipWriter.Create("OEBPS/content.opf")
+	contentWriter, err := createEntry("OEBPS/content.opf")
 	if err != nil {
 		return fmt.Errorf("failed to create content.opf: %w", err)
 	}
@@ -156,7 +394,7 @@ func ConvertFromCBZ(cbzFile *cbz.File, outputFile string) error {
 <!DOCTYPE ncx PUBLIC "-//NISO//DTD ncx 2005-1//EN" "http://www.daisy.org/z3986/2005/ncx-2005-1.dtd">
 <ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
   <head>
-    <meta name="dtb:uid" content="urn:uuid:%s"/>
+    <meta name="dtb:uid" content="%s"/>
     <meta name="dtb:depth" content="1"/>
     <meta name="dtb:totalPageCount" content="0"/>
     <meta name="dtb:maxPageNumber" content="0"/>
@@ -165,7 +403,7 @@ func ConvertFromCBZ(cbzFile *cbz.File, outputFile string) error {
     <text>%s</text>
   </docTitle>
   <navMap>
-`, uuid, title))
+`, identifier, title))
 
 	// Add each page to the navigation map
 	for i := range cbzFile.Images {
@@ -181,7 +419,7 @@ func ConvertFromCBZ(cbzFile *cbz.File, outputFile string) error {
 </ncx>`)
 
 	// Add toc.ncx to EPUB
-	tocWriter, err := zipWriter.Create("OEBPS/toc.ncx")
+	tocWriter, err := createEntry("OEBPS/toc.ncx")
 	if err != nil {
 		return fmt.Errorf("failed to create toc.ncx: %w", err)
 	}
@@ -190,9 +428,73 @@ func ConvertFromCBZ(cbzFile *cbz.File, outputFile string) error {
 		return fmt.Errorf("failed to write toc.ncx: %w", err)
 	}
 
+	// EPUB 3 requires a navigation document
+	if epub3 {
+		navWriter, err := createEntry("OEBPS/nav.xhtml")
+		if err != nil {
+			return fmt.Errorf("failed to create nav.xhtml: %w", err)
+		}
+		_, err = navWriter.Write([]byte(navDocument(title, len(cbzFile.Images))))
+		if err != nil {
+			return fmt.Errorf("failed to write nav.xhtml: %w", err)
+		}
+	}
+
+	// Panels become EPUB 3 region-based navigation for guided reading
+	if epub3 && hasPanels {
+		regionsWriter, err := createEntry("OEBPS/regions.xhtml")
+		if err != nil {
+			return fmt.Errorf("failed to create regions.xhtml: %w", err)
+		}
+		_, err = regionsWriter.Write([]byte(regionsDocument(title, cbzFile.Images, sizes)))
+		if err != nil {
+			return fmt.Errorf("failed to write regions.xhtml: %w", err)
+		}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish EPUB file: %w", err)
+	}
+	opts.Progress.Report(cbz.Progress{Stage: cbz.StageWrite, Pages: len(cbzFile.Images), TotalPages: len(cbzFile.Images), Bytes: counter.N})
 	return nil
 }
 
+// prepareBook returns a copy of a book with the metadata and image filters
+// of the options applied, or the book itself if there are none
+func prepareBook(ctx context.Context, cbzFile *cbz.File, opts Options) (*cbz.File, error) {
+	if opts.Metadata == nil && len(opts.Images) == 0 {
+		return cbzFile, nil
+	}
+	book := *cbzFile
+
+	if opts.Metadata != nil {
+		comicInfo := &cbz.ComicInfo{}
+		if cbzFile.ComicInfo != nil {
+			*comicInfo = *cbzFile.ComicInfo
+		}
+		comicInfo.Override(opts.Metadata)
+		book.ComicInfo = comicInfo
+	}
+
+	if len(opts.Images) > 0 {
+		book.Images = make([]cbz.Image, len(cbzFile.Images))
+		for i, image := range cbzFile.Images {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			for _, filter := range opts.Images {
+				var err error
+				if image, err = filter(image); err != nil {
+					return nil, fmt.Errorf("failed to process image %s: %w", cbzFile.Images[i].Name, err)
+				}
+			}
+			book.Images[i] = image
+			opts.Progress.Report(cbz.Progress{Stage: cbz.StageProcess, Pages: i + 1, TotalPages: len(cbzFile.Images)})
+		}
+	}
+	return &book, nil
+}
+
 // ConvertFile converts a CBZ file to EPUB format
 func ConvertFile(inputFile, outputFile string) error {
 	// Read the CBZ file
@@ -209,3 +511,203 @@ func ConvertFile(inputFile, outputFile string) error {
 
 	return nil
 }
+
+// defaultPage returns the XHTML page showing an image, with the doctype of
+// XHTML 1.1 for EPUB 2 and of HTML5 for EPUB 3
+func defaultPage(number int, imageName string, epub3 bool) string {
+	doctype := `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN" "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd">`
+	if epub3 {
+		doctype = `<!DOCTYPE html>`
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+%s
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+  <title>Page %d</title>
+  <style type="text/css">
+    img { max-width: 100%%; max-height: 100%%; }
+    body { margin: 0; padding: 0; text-align: center; }
+  </style>
+</head>
+<body>
+  <div>
+    <img src="../images/%s" alt="Page %d" />
+  </div>
+</body>
+</html>`, doctype, number, imageName, number)
+}
+
+// koboPage returns a fixed-layout XHTML page with the koboSpan markup that
+// Kobo devices expect in KEPUB files
+func koboPage(number int, imageName string, size pageSize) string {
+	viewport := ""
+	if size.width > 0 && size.height > 0 {
+		viewport = fmt.Sprintf(`
+  <meta name="viewport" content="width=%d, height=%d"/>`, size.width, size.height)
+	}
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>Page %d</title>%s
+  <style type="text/css">
+    body { margin: 0; padding: 0; }
+    img { display: block; width: 100%%; height: 100%%; }
+  </style>
+</head>
+<body>
+  <div id="book-columns">
+    <div id="book-inner">
+      <span class="koboSpan" id="kobo.1.1"><img src="../images/%s" alt="Page %d" /></span>
+    </div>
+  </div>
+</body>
+</html>`, number, viewport, imageName, number)
+}
+
+// kindlePage returns a fixed-layout XHTML page for Kindle. Each panel gets
+// a tap region that shows a magnified copy of the panel, which Kindle uses
+// for panel view.
+func kindlePage(number int, imageName string, size pageSize, panels []image.Rectangle) string {
+	head := ""
+	if size.width > 0 && size.height > 0 {
+		head = fmt.Sprintf(`
+  <meta name="viewport" content="width=%d, height=%d"/>`, size.width, size.height)
+	}
+
+	page := &strings.Builder{}
+	page.WriteString(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>Page %d</title>%s
+  <style type="text/css">
+    body { margin: 0; padding: 0; }
+    .page { position: absolute; left: 0; top: 0; width: 100%%; height: 100%%; }
+    .page img { display: block; width: 100%%; height: 100%%; }
+    .region { position: absolute; }
+    .region a { display: block; width: 100%%; height: 100%%; }
+    .target-mag { display: none; position: absolute; overflow: hidden; }
+    .target-mag img { position: absolute; }
+  </style>
+</head>
+<body>
+  <div class="page"><img src="../images/%s" alt="Page %d" /></div>
+`, number, head, imageName, number))
+
+	// Magnification regions need the page size to place the targets
+	if size.width > 0 && size.height > 0 {
+		for i, panel := range panels {
+			panel = panel.Intersect(image.Rect(0, 0, size.width, size.height))
+			if panel.Empty() {
+				continue
+			}
+			page.WriteString(magnificationRegion(i+1, imageName, size, panel))
+		}
+	}
+
+	page.WriteString(`</body>
+</html>`)
+	return page.String()
+}
+
+// magnificationRegion returns the tap region and magnification target of a
+// panel. The target shows the panel at up to twice its size, centered on the
+// panel and kept inside the page.
+func magnificationRegion(ordinal int, imageName string, size pageSize, panel image.Rectangle) string {
+	scale := min(2.0, float64(size.width)/float64(panel.Dx()), float64(size.height)/float64(panel.Dy()))
+	targetWidth := float64(panel.Dx()) * scale
+	targetHeight := float64(panel.Dy()) * scale
+	centerX := float64(panel.Min.X+panel.Max.X) / 2
+	centerY := float64(panel.Min.Y+panel.Max.Y) / 2
+	targetLeft := min(max(centerX-targetWidth/2, 0), float64(size.width)-targetWidth)
+	targetTop := min(max(centerY-targetHeight/2, 0), float64(size.height)-targetHeight)
+
+	percent := func(value, total int) float64 {
+		return float64(value) * 100 / float64(total)
+	}
+
+	return fmt.Sprintf(`  <div id="region-%d" class="region" style="left: %.2f%%; top: %.2f%%; width: %.2f%%; height: %.2f%%;">
+    <a class="app-amzn-magnify" data-app-amzn-magnify='{"targetId":"region-%d-magTarget", "ordinal":%d}'></a>
+  </div>
+  <div id="region-%d-magTarget" class="target-mag" style="left: %.0fpx; top: %.0fpx; width: %.0fpx; height: %.0fpx;">
+    <img src="../images/%s" alt="" style="left: %.0fpx; top: %.0fpx; width: %.0fpx; height: %.0fpx;" />
+  </div>
+`, ordinal,
+		percent(panel.Min.X, size.width), percent(panel.Min.Y, size.height),
+		percent(panel.Dx(), size.width), percent(panel.Dy(), size.height),
+		ordinal, ordinal,
+		ordinal, targetLeft, targetTop, targetWidth, targetHeight,
+		imageName, float64(-panel.Min.X)*scale, float64(-panel.Min.Y)*scale,
+		float64(size.width)*scale, float64(size.height)*scale)
+}
+
+// navDocument returns the EPUB 3 navigation document
+func navDocument(title string, pages int) string {
+	nav := &strings.Builder{}
+	nav.WriteString(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>%s</title>
+</head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <ol>
+`, title))
+	for i := 1; i <= pages; i++ {
+		nav.WriteString(fmt.Sprintf(`      <li><a href="pages/page%03d.xhtml">Page %d</a></li>
+`, i, i))
+	}
+	nav.WriteString(`    </ol>
+  </nav>
+</body>
+</html>`)
+	return nav.String()
+}
+
+// regionsDocument returns the EPUB 3 region-based navigation document that
+// lists the panels of every page in reading order. Panels of pages with an
+// unknown size are left out.
+func regionsDocument(title string, images []cbz.Image, sizes []pageSize) string {
+	nav := &strings.Builder{}
+	nav.WriteString(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>%s</title>
+</head>
+<body>
+  <nav epub:type="region-based" prefix="ahl: http://idpf.org/epub/vocab/ahl">
+    <ol>
+`, title))
+	for i, img := range images {
+		size := sizes[i]
+		if size.width <= 0 || size.height <= 0 {
+			continue
+		}
+		for _, panel := range img.Panels {
+			panel = panel.Intersect(image.Rect(0, 0, size.width, size.height))
+			if panel.Empty() {
+				continue
+			}
+			nav.WriteString(fmt.Sprintf(`      <li epub:type="panel"><a href="pages/page%03d.xhtml#xywh=percent:%.2f,%.2f,%.2f,%.2f"></a></li>
+`, i+1,
+				float64(panel.Min.X)*100/float64(size.width), float64(panel.Min.Y)*100/float64(size.height),
+				float64(panel.Dx())*100/float64(size.width), float64(panel.Dy())*100/float64(size.height)))
+		}
+	}
+	nav.WriteString(`    </ol>
+  </nav>
+</body>
+</html>`)
+	return nav.String()
+}
+
+// xmlEscape escapes text for use in XML content and attributes
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
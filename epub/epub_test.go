@@ -2,10 +2,19 @@ package epub
 
 import (
 	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"image"
+	"image/png"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"cbz2epub/cbz"
 )
@@ -219,3 +228,418 @@ func TestConvertFile(t *testing.T) {
 		t.Errorf("ConvertFile should fail with non-existent file")
 	}
 }
+
+// readZipEntry reads a file from a zip archive for inspection
+func readZipEntry(t *testing.T, zipReader *zip.Reader, name string) string {
+	for _, file := range zipReader.File {
+		if file.Name != name {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", name, err)
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		return string(data)
+	}
+	t.Fatalf("File not found in EPUB: %s", name)
+	return ""
+}
+
+// TestConvertWithOptionsKobo tests writing a Kobo KEPUB
+func TestConvertWithOptionsKobo(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a CBZ file with a real image so the page size is known
+	var imageData bytes.Buffer
+	if err := png.Encode(&imageData, image.NewGray(image.Rect(0, 0, 80, 120))); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	cbzFile := &cbz.File{
+		Name: filepath.Join(tempDir, "test.cbz"),
+		Images: []cbz.Image{
+			{Name: "001.png", Data: imageData.Bytes(), MimeType: "image/png"},
+			{Name: "002.jpg", Data: []byte("not decodable"), MimeType: "image/jpeg"},
+		},
+		ComicInfo: &cbz.ComicInfo{Title: "Tom & Jerry"},
+	}
+
+	epubPath := filepath.Join(tempDir, "test.kepub.epub")
+	if err := ConvertWithOptions(cbzFile, epubPath, Options{Profile: ProfileKobo}); err != nil {
+		t.Fatalf("ConvertWithOptions failed: %v", err)
+	}
+
+	zipReader, err := zip.OpenReader(epubPath)
+	if err != nil {
+		t.Fatalf("Failed to open EPUB: %v", err)
+	}
+	defer zipReader.Close()
+
+	// Check the fixed-layout metadata
+	opf := readZipEntry(t, &zipReader.Reader, "OEBPS/content.opf")
+	for _, s := range []string{
+		`version="3.0"`,
+		`<meta property="rendition:layout">pre-paginated</meta>`,
+		`<dc:title>Tom &amp; Jerry</dc:title>`,
+		`properties="cover-image"`,
+		`properties="nav"`,
+	} {
+		if !strings.Contains(opf, s) {
+			t.Errorf("content.opf does not contain %q", s)
+		}
+	}
+
+	// Check the Kobo page markup
+	page := readZipEntry(t, &zipReader.Reader, "OEBPS/pages/page001.xhtml")
+	for _, s := range []string{`class="koboSpan" id="kobo.1.1"`, `content="width=80, height=120"`} {
+		if !strings.Contains(page, s) {
+			t.Errorf("page001.xhtml does not contain %q", s)
+		}
+	}
+	if page := readZipEntry(t, &zipReader.Reader, "OEBPS/pages/page002.xhtml"); strings.Contains(page, "viewport") {
+		t.Errorf("page002.xhtml should not have a viewport without a known image size")
+	}
+
+	// Check that the generated documents are well-formed
+	for _, name := range []string{"OEBPS/content.opf", "OEBPS/nav.xhtml", "OEBPS/toc.ncx", "OEBPS/pages/page001.xhtml"} {
+		decoder := xml.NewDecoder(strings.NewReader(readZipEntry(t, &zipReader.Reader, name)))
+		for {
+			_, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf("%s is not well-formed: %v", name, err)
+				break
+			}
+		}
+	}
+}
+
+func TestConvertWithOptionsKindle(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a right-to-left CBZ file with two panels on the first page
+	var imageData bytes.Buffer
+	if err := png.Encode(&imageData, image.NewGray(image.Rect(0, 0, 100, 200))); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	cbzFile := &cbz.File{
+		Name: filepath.Join(tempDir, "test.cbz"),
+		Images: []cbz.Image{
+			{
+				Name:     "001.png",
+				Data:     imageData.Bytes(),
+				MimeType: "image/png",
+				Panels:   []image.Rectangle{image.Rect(50, 0, 100, 100), image.Rect(0, 100, 100, 200)},
+			},
+			{Name: "002.png", Data: imageData.Bytes(), MimeType: "image/png"},
+		},
+		ComicInfo: &cbz.ComicInfo{Title: "Test", Manga: "YesAndRightToLeft"},
+	}
+
+	epubPath := filepath.Join(tempDir, "test.epub")
+	if err := ConvertWithOptions(cbzFile, epubPath, Options{Profile: ProfileKindle}); err != nil {
+		t.Fatalf("ConvertWithOptions failed: %v", err)
+	}
+
+	zipReader, err := zip.OpenReader(epubPath)
+	if err != nil {
+		t.Fatalf("Failed to open EPUB: %v", err)
+	}
+	defer zipReader.Close()
+
+	// Check the Amazon fixed-layout metadata
+	opf := readZipEntry(t, &zipReader.Reader, "OEBPS/content.opf")
+	for _, s := range []string{
+		`<meta name="fixed-layout" content="true"/>`,
+		`<meta name="original-resolution" content="100x200"/>`,
+		`<meta name="book-type" content="comic"/>`,
+		`<meta name="primary-writing-mode" content="horizontal-rl"/>`,
+		`<meta name="orientation-lock" content="portrait"/>`,
+		`<meta name="region-mag" content="true"/>`,
+		`page-progression-direction="rtl"`,
+		`properties="data-nav"`,
+	} {
+		if !strings.Contains(opf, s) {
+			t.Errorf("content.opf does not contain %q", s)
+		}
+	}
+
+	// Check the magnification regions
+	page := readZipEntry(t, &zipReader.Reader, "OEBPS/pages/page001.xhtml")
+	for _, s := range []string{
+		`id="region-1" class="region" style="left: 50.00%; top: 0.00%; width: 50.00%; height: 50.00%;"`,
+		`{"targetId":"region-2-magTarget", "ordinal":2}`,
+		`id="region-1-magTarget" class="target-mag" style="left: 0px; top: 0px; width: 100px; height: 200px;"`,
+		`style="left: -100px; top: 0px; width: 200px; height: 400px;"`,
+	} {
+		if !strings.Contains(page, s) {
+			t.Errorf("page001.xhtml does not contain %q", s)
+		}
+	}
+	if page := readZipEntry(t, &zipReader.Reader, "OEBPS/pages/page002.xhtml"); strings.Contains(page, "app-amzn-magnify") {
+		t.Errorf("page002.xhtml should not have magnification regions without panels")
+	}
+
+	// Check the region-based navigation
+	regions := readZipEntry(t, &zipReader.Reader, "OEBPS/regions.xhtml")
+	for _, s := range []string{
+		`<nav epub:type="region-based"`,
+		`<li epub:type="panel"><a href="pages/page001.xhtml#xywh=percent:50.00,0.00,50.00,50.00"></a></li>`,
+		`<a href="pages/page001.xhtml#xywh=percent:0.00,50.00,100.00,50.00">`,
+	} {
+		if !strings.Contains(regions, s) {
+			t.Errorf("regions.xhtml does not contain %q", s)
+		}
+	}
+
+	// EPUB 3 output lists the panels too, EPUB 2 output can't
+	for _, version := range []int{2, 3} {
+		var buf bytes.Buffer
+		if err := Write(&buf, cbzFile, Options{Version: version}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("Failed to open EPUB: %v", err)
+		}
+		_, err = reader.Open("OEBPS/regions.xhtml")
+		if hasRegions := err == nil; hasRegions != (version == 3) {
+			t.Errorf("Expected regions.xhtml %t for EPUB %d, got %t", version == 3, version, hasRegions)
+		}
+		if version == 3 && !strings.Contains(readZipEntry(t, reader, "OEBPS/regions.xhtml"), "page001.xhtml#xywh=percent:50.00,0.00,50.00,50.00") {
+			t.Errorf("Expected the panels in the regions of EPUB 3 output")
+		}
+	}
+
+	// Check that the generated pages are well-formed
+	for _, name := range []string{"OEBPS/content.opf", "OEBPS/regions.xhtml", "OEBPS/pages/page001.xhtml"} {
+		decoder := xml.NewDecoder(strings.NewReader(readZipEntry(t, &zipReader.Reader, name)))
+		for {
+			_, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf("%s is not well-formed: %v", name, err)
+				break
+			}
+		}
+	}
+}
+
+// TestConvertWithOptionsReproducible tests that reproducible books are byte-identical
+func TestConvertWithOptionsReproducible(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cbzFile := &cbz.File{
+		Name:      filepath.Join(tempDir, "test.cbz"),
+		Images:    []cbz.Image{{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}},
+		ComicInfo: &cbz.ComicInfo{Title: "Test", Year: 2019, Month: 5},
+	}
+	convert := func(name string, opts Options) []byte {
+		epubPath := filepath.Join(tempDir, name)
+		if err := ConvertWithOptions(cbzFile, epubPath, opts); err != nil {
+			t.Fatalf("ConvertWithOptions failed: %v", err)
+		}
+		data, err := os.ReadFile(epubPath)
+		if err != nil {
+			t.Fatalf("Failed to read EPUB: %v", err)
+		}
+		return data
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	for _, profile := range []Profile{ProfileDefault, ProfileKobo, ProfileKindle} {
+		first := convert("first.epub", Options{Profile: profile, Reproducible: true})
+		time.Sleep(10 * time.Millisecond)
+		second := convert("second.epub", Options{Profile: profile, Reproducible: true})
+		if !bytes.Equal(first, second) {
+			t.Errorf("Expected identical files for profile %q", profile)
+		}
+	}
+
+	// The dates come from the metadata, or from SOURCE_DATE_EPOCH
+	zipReader, err := zip.OpenReader(filepath.Join(tempDir, "second.epub"))
+	if err != nil {
+		t.Fatalf("Failed to open EPUB: %v", err)
+	}
+	opf := readZipEntry(t, &zipReader.Reader, "OEBPS/content.opf")
+	modified := zipReader.File[0].Modified
+	zipReader.Close()
+	if !strings.Contains(opf, "<dc:date>2019-05</dc:date>") || !strings.Contains(opf, `<meta property="dcterms:modified">2019-05-01T00:00:00Z</meta>`) {
+		t.Errorf("Expected dates from the metadata, got %s", opf)
+	}
+	if !modified.Equal(time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected zip entries modified at the publication date, got %v", modified)
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	convert("third.epub", Options{Profile: ProfileKobo, Reproducible: true})
+	zipReader, err = zip.OpenReader(filepath.Join(tempDir, "third.epub"))
+	if err != nil {
+		t.Fatalf("Failed to open EPUB: %v", err)
+	}
+	defer zipReader.Close()
+	if opf := readZipEntry(t, &zipReader.Reader, "OEBPS/content.opf"); !strings.Contains(opf, "2023-11-14T22:13:20Z") {
+		t.Errorf("Expected the modification date from SOURCE_DATE_EPOCH, got %s", opf)
+	}
+
+	// Dates before 1980 cannot be stored in zip files
+	t.Setenv("SOURCE_DATE_EPOCH", "0")
+	if date := reproducibleTime(nil); !date.Equal(zipEpoch) {
+		t.Errorf("Expected SOURCE_DATE_EPOCH to be clamped to %v, got %v", zipEpoch, date)
+	}
+}
+
+// TestWrite tests the options of writing an EPUB file to memory
+func TestWrite(t *testing.T) {
+	newBook := func() *cbz.File {
+		return &cbz.File{
+			Images:    []cbz.Image{{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}},
+			ComicInfo: &cbz.ComicInfo{Title: "Original", Writer: "Jane Doe"},
+		}
+	}
+	write := func(book *cbz.File, opts Options) *zip.Reader {
+		var buf bytes.Buffer
+		if err := Write(&buf, book, opts); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		zipReader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("Failed to open EPUB: %v", err)
+		}
+		return zipReader
+	}
+	entryMethod := func(zipReader *zip.Reader, name string) uint16 {
+		for _, file := range zipReader.File {
+			if file.Name == name {
+				return file.Method
+			}
+		}
+		t.Fatalf("File not found in EPUB: %s", name)
+		return 0
+	}
+
+	// EPUB 3 without a fixed-layout profile
+	zipReader := write(newBook(), Options{Version: 3})
+	if opf := readZipEntry(t, zipReader, "OEBPS/content.opf"); !strings.Contains(opf, `version="3.0"`) {
+		t.Errorf("Expected an EPUB 3 package, got %s", opf)
+	}
+	readZipEntry(t, zipReader, "OEBPS/nav.xhtml")
+	if page := readZipEntry(t, zipReader, "OEBPS/pages/page001.xhtml"); !strings.Contains(page, "<!DOCTYPE html>") {
+		t.Errorf("Expected an HTML5 page, got %s", page)
+	}
+
+	// Metadata and image filters apply to a copy of the book
+	book := newBook()
+	zipReader = write(book, Options{
+		Metadata: &cbz.ComicInfo{Title: "Replaced"},
+		Images: []ImageFilter{func(image cbz.Image) (cbz.Image, error) {
+			image.Data = []byte("filtered")
+			return image, nil
+		}},
+	})
+	opf := readZipEntry(t, zipReader, "OEBPS/content.opf")
+	if !strings.Contains(opf, "<dc:title>Replaced</dc:title>") || !strings.Contains(opf, "<dc:creator>Jane Doe</dc:creator>") {
+		t.Errorf("Expected the replaced title and the original writer, got %s", opf)
+	}
+	if data := readZipEntry(t, zipReader, "OEBPS/images/image001.jpg"); data != "filtered" {
+		t.Errorf("Expected the filtered image, got %q", data)
+	}
+	if book.ComicInfo.Title != "Original" || string(book.Images[0].Data) != "fake image data" {
+		t.Errorf("Expected the book to be unchanged, got %+v", book)
+	}
+
+	// Fast compression stores the images
+	zipReader = write(newBook(), Options{Compression: CompressionFast})
+	if method := entryMethod(zipReader, "OEBPS/images/image001.jpg"); method != zip.Store {
+		t.Errorf("Expected stored images, got method %d", method)
+	}
+	if method := entryMethod(zipReader, "OEBPS/content.opf"); method != zip.Deflate {
+		t.Errorf("Expected deflated content.opf, got method %d", method)
+	}
+	write(newBook(), Options{Compression: CompressionBest})
+
+	// Invalid versions and failing filters are errors
+	failing := func(image cbz.Image) (cbz.Image, error) { return image, io.ErrUnexpectedEOF }
+	for _, opts := range []Options{{Version: 4}, {Version: 2, Profile: ProfileKobo}, {Images: []ImageFilter{failing}}} {
+		if err := Write(io.Discard, newBook(), opts); err == nil {
+			t.Errorf("Expected an error for options %+v", opts)
+		}
+	}
+}
+
+// TestWriteContext tests the progress events and cancellation of writing an EPUB file
+func TestWriteContext(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	book := &cbz.File{Images: []cbz.Image{
+		{Name: "001.jpg", Data: []byte("first page"), MimeType: "image/jpeg"},
+		{Name: "002.jpg", Data: []byte("second page"), MimeType: "image/jpeg"},
+	}}
+	identity := func(image cbz.Image) (cbz.Image, error) { return image, nil }
+
+	var events []cbz.Progress
+	var buf bytes.Buffer
+	opts := Options{Images: []ImageFilter{identity}, Progress: func(p cbz.Progress) { events = append(events, p) }}
+	if err := WriteContext(context.Background(), &buf, book, opts); err != nil {
+		t.Fatalf("WriteContext failed: %v", err)
+	}
+	var stages []cbz.Stage
+	for _, event := range events {
+		stages = append(stages, event.Stage)
+	}
+	expected := []cbz.Stage{cbz.StageProcess, cbz.StageProcess, cbz.StageWrite, cbz.StageWrite, cbz.StageWrite}
+	if !reflect.DeepEqual(stages, expected) {
+		t.Errorf("Expected stages %v, got %v", expected, stages)
+	}
+	if last := events[len(events)-1]; last.Pages != 2 || last.Bytes != int64(buf.Len()) {
+		t.Errorf("Unexpected last event: %+v", last)
+	}
+
+	// A cancelled conversion leaves no output file
+	ctx, cancel := context.WithCancel(context.Background())
+	opts.Progress = func(p cbz.Progress) {
+		if p.Stage == cbz.StageWrite {
+			cancel()
+		}
+	}
+	epubPath := filepath.Join(tempDir, "cancelled.epub")
+	if err := ConvertContext(ctx, book, epubPath, opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := os.Stat(epubPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no output file after cancelling, got %v", err)
+	}
+	bookPath := filepath.Join(tempDir, "book.epub")
+	if err := os.WriteFile(bookPath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write EPUB: %v", err)
+	}
+	if _, err := ReadFileContext(ctx, bookPath, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from ReadFileContext, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package epub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"cbz2epub/cbz"
+	"cbz2epub/util"
+)
+
+// zipEpoch is the earliest modification time of zip entries, used for
+// reproducible books without a date
+var zipEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// publicationDate returns the dc:date of a book, from the year, month and
+// day of its ComicInfo.xml if known
+func publicationDate(comicInfo *cbz.ComicInfo, now time.Time) string {
+	if comicInfo == nil || comicInfo.Year == 0 {
+		return now.Format("2006-01-02")
+	}
+	date := fmt.Sprintf("%04d", comicInfo.Year)
+	if comicInfo.Month > 0 {
+		date += fmt.Sprintf("-%02d", comicInfo.Month)
+		if comicInfo.Day > 0 {
+			date += fmt.Sprintf("-%02d", comicInfo.Day)
+		}
+	}
+	return date
+}
+
+// reproducibleTime returns the modification time of a reproducible book:
+// SOURCE_DATE_EPOCH if set, otherwise the publication date, but never
+// earlier than the earliest time zip files can store
+func reproducibleTime(comicInfo *cbz.ComicInfo) time.Time {
+	date := zipEpoch
+	if epoch, ok := util.SourceDateEpoch(); ok {
+		date = epoch
+	} else if comicInfo != nil && comicInfo.Year > 0 {
+		date = time.Date(comicInfo.Year, time.Month(max(comicInfo.Month, 1)), max(comicInfo.Day, 1), 0, 0, 0, 0, time.UTC)
+	}
+	if date.Before(zipEpoch) {
+		return zipEpoch
+	}
+	return date
+}
+
+// pagesHash returns a hash of the page images of a book
+func pagesHash(cbzFile *cbz.File) string {
+	hash := sha256.New()
+	for _, image := range cbzFile.Images {
+		fmt.Fprintf(hash, "%s %d\n", image.MimeType, len(image.Data))
+		hash.Write(image.Data)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// bookIdentifier returns the unique identifier of a book: the identifier of
+// the options, the ISBN or GTIN of its metadata, a name-based UUID of its
+// series, volume, number, language and publisher, so translations and
+// editions differ, or a name-based UUID of its pages. Converting
+// the same book again gives the same identifier, so readers keep the
+// reading progress.
+func bookIdentifier(cbzFile *cbz.File, opts Options) string {
+	if opts.Identifier != "" {
+		return opts.Identifier
+	}
+	if comicInfo := cbzFile.ComicInfo; comicInfo != nil {
+		if isbn, ok := util.ISBNDigits(comicInfo.GTIN); ok {
+			return "urn:isbn:" + isbn
+		}
+		if gtin := strings.TrimSpace(comicInfo.GTIN); gtin != "" {
+			return "urn:uuid:" + util.NameUUID("cbz2epub:gtin:"+gtin)
+		}
+		if comicInfo.Series != "" && (comicInfo.Volume > 0 || comicInfo.Number != "") {
+			return "urn:uuid:" + util.NameUUID(fmt.Sprintf("cbz2epub:series:%s:%d:%s:%s:%s", comicInfo.Series, comicInfo.Volume, comicInfo.Number, bookLanguage(comicInfo), comicInfo.Publisher))
+		}
+	}
+	return "urn:uuid:" + util.NameUUID("cbz2epub:pages:"+pagesHash(cbzFile))
+}
+
+// seriesIndex returns the position of a book in its series, the chapter or
+// issue number if known, otherwise the volume
+func seriesIndex(comicInfo *cbz.ComicInfo) string {
+	if comicInfo.Number != "" {
+		return comicInfo.Number
+	}
+	if comicInfo.Volume > 0 {
+		return fmt.Sprint(comicInfo.Volume)
+	}
+	return ""
+}
+
+// bookLanguage returns the dc:language of a book, English if unknown
+func bookLanguage(comicInfo *cbz.ComicInfo) string {
+	if comicInfo == nil || comicInfo.LanguageISO == "" {
+		return "en"
+	}
+	return comicInfo.LanguageISO
+}
+
+// splitList splits a comma-separated ComicInfo.xml field
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// metadataElements returns the OPF metadata elements for the ComicInfo.xml
+// of a book: creators, publisher, description, subjects, GTIN and series.
+// An ISBN is written without hyphens and left out if it is already the
+// identifier of the book; other GTINs are written without a scheme.
+// The series is written in the Calibre format read by most readers, and as
+// an EPUB 3 collection.
+func metadataElements(comicInfo *cbz.ComicInfo, epub3 bool, identifier string) string {
+	if comicInfo == nil {
+		comicInfo = &cbz.ComicInfo{}
+	}
+
+	var b strings.Builder
+	creators := splitList(comicInfo.Writer)
+	if len(creators) == 0 {
+		creators = []string{"CBZ2EPUB Converter"}
+	}
+	for _, creator := range creators {
+		fmt.Fprintf(&b, "    <dc:creator>%s</dc:creator>\n", xmlEscape(creator))
+	}
+	if comicInfo.Publisher != "" {
+		fmt.Fprintf(&b, "    <dc:publisher>%s</dc:publisher>\n", xmlEscape(comicInfo.Publisher))
+	}
+	if comicInfo.Summary != "" {
+		fmt.Fprintf(&b, "    <dc:description>%s</dc:description>\n", xmlEscape(comicInfo.Summary))
+	}
+	for _, tag := range splitList(comicInfo.Tags) {
+		fmt.Fprintf(&b, "    <dc:subject>%s</dc:subject>\n", xmlEscape(tag))
+	}
+	if isbn, ok := util.ISBNDigits(comicInfo.GTIN); ok {
+		if identifier != "urn:isbn:"+isbn {
+			if epub3 {
+				fmt.Fprintf(&b, "    <dc:identifier id=\"isbn\">urn:isbn:%s</dc:identifier>\n", isbn)
+			} else {
+				fmt.Fprintf(&b, "    <dc:identifier opf:scheme=\"ISBN\">%s</dc:identifier>\n", isbn)
+			}
+		}
+	} else if gtin := strings.TrimSpace(comicInfo.GTIN); gtin != "" {
+		fmt.Fprintf(&b, "    <dc:identifier>%s</dc:identifier>\n", xmlEscape(gtin))
+	}
+	if comicInfo.Series != "" {
+		series := xmlEscape(comicInfo.Series)
+		index := xmlEscape(seriesIndex(comicInfo))
+		fmt.Fprintf(&b, "    <meta name=\"calibre:series\" content=\"%s\"/>\n", series)
+		if index != "" {
+			fmt.Fprintf(&b, "    <meta name=\"calibre:series_index\" content=\"%s\"/>\n", index)
+		}
+		if epub3 {
+			fmt.Fprintf(&b, "    <meta property=\"belongs-to-collection\" id=\"series\">%s</meta>\n", series)
+			b.WriteString("    <meta refines=\"#series\" property=\"collection-type\">series</meta>\n")
+			if index != "" {
+				fmt.Fprintf(&b, "    <meta refines=\"#series\" property=\"group-position\">%s</meta>\n", index)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,211 @@
+package epub
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cbz2epub/cbz"
+	"cbz2epub/util"
+)
+
+// TestPublicationDate tests the dc:date of books with and without a year
+func TestPublicationDate(t *testing.T) {
+	now := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		comicInfo *cbz.ComicInfo
+		expected  string
+	}{
+		{nil, "2024-03-09"},
+		{&cbz.ComicInfo{Series: "Saga"}, "2024-03-09"},
+		{&cbz.ComicInfo{Year: 2019}, "2019"},
+		{&cbz.ComicInfo{Year: 2019, Month: 5}, "2019-05"},
+		{&cbz.ComicInfo{Year: 2019, Month: 5, Day: 21}, "2019-05-21"},
+	}
+	for _, tc := range testCases {
+		if got := publicationDate(tc.comicInfo, now); got != tc.expected {
+			t.Errorf("Expected %s for %+v, got %s", tc.expected, tc.comicInfo, got)
+		}
+	}
+}
+
+// TestSeriesMetadata tests that the series survives a conversion to EPUB and back
+func TestSeriesMetadata(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, profile := range []Profile{ProfileDefault, ProfileKobo} {
+		cbzFile := &cbz.File{
+			Name:      filepath.Join(tempDir, "test.cbz"),
+			Images:    []cbz.Image{{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}},
+			ComicInfo: &cbz.ComicInfo{Series: "Saga & Co", Volume: 3, Number: "21", Year: 2019},
+		}
+		epubPath := filepath.Join(tempDir, "test"+string(profile)+".epub")
+		if err := ConvertWithOptions(cbzFile, epubPath, Options{Profile: profile}); err != nil {
+			t.Fatalf("ConvertWithOptions failed: %v", err)
+		}
+
+		book, err := ReadFile(epubPath)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		comicInfo := book.ComicInfo
+		if comicInfo.Series != "Saga & Co" || comicInfo.Number != "21" || comicInfo.Year != 2019 || comicInfo.Title != "Saga & Co Vol. 3 #21" {
+			t.Errorf("Unexpected metadata for profile %q: %+v", profile, comicInfo)
+		}
+	}
+
+	epub3 := metadataElements(&cbz.ComicInfo{Series: "Saga", Volume: 2}, true, "")
+	if !strings.Contains(epub3, `<meta refines="#series" property="group-position">2</meta>`) {
+		t.Errorf("Expected the volume as collection position, got %s", epub3)
+	}
+}
+
+// TestBookMetadata tests that the ComicInfo.xml fields survive a conversion to EPUB and back
+func TestBookMetadata(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	expected := &cbz.ComicInfo{
+		Title:       "Into the Woods",
+		Writer:      "Jane Doe, John Doe",
+		Publisher:   "Test Press",
+		Summary:     "A story about <trees>.",
+		Tags:        "fantasy, drama",
+		LanguageISO: "ja",
+		GTIN:        "9781234567897",
+	}
+	for _, profile := range []Profile{ProfileDefault, ProfileKindle} {
+		info := *expected
+		cbzFile := &cbz.File{
+			Name:      filepath.Join(tempDir, "test.cbz"),
+			Images:    []cbz.Image{{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}},
+			ComicInfo: &info,
+		}
+		epubPath := filepath.Join(tempDir, "test"+string(profile)+".epub")
+		if err := ConvertWithOptions(cbzFile, epubPath, Options{Profile: profile}); err != nil {
+			t.Fatalf("ConvertWithOptions failed: %v", err)
+		}
+
+		book, err := ReadFile(epubPath)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		got := book.ComicInfo
+		got.Year, got.Month, got.Day, got.PageCount = 0, 0, 0, 0
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected metadata %+v for profile %q, got %+v", expected, profile, got)
+		}
+	}
+
+	// Books without writers name the converter as creator
+	if elements := metadataElements(nil, false, ""); !strings.Contains(elements, "<dc:creator>CBZ2EPUB Converter</dc:creator>") {
+		t.Errorf("Expected the converter as creator, got %s", elements)
+	}
+}
+
+// TestBookIdentifier tests that books keep their identifier across conversions
+func TestBookIdentifier(t *testing.T) {
+	pages := []cbz.Image{{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}}
+	otherPages := []cbz.Image{{Name: "001.jpg", Data: []byte("other image data"), MimeType: "image/jpeg"}}
+	seriesID := bookIdentifier(&cbz.File{Images: pages, ComicInfo: &cbz.ComicInfo{Series: "Saga", Volume: 1}}, Options{})
+	pagesID := bookIdentifier(&cbz.File{Images: pages}, Options{})
+
+	testCases := []struct {
+		name     string
+		file     *cbz.File
+		opts     Options
+		expected string
+	}{
+		{
+			name:     "explicit identifier",
+			file:     &cbz.File{Images: pages, ComicInfo: &cbz.ComicInfo{GTIN: "9781607066019"}},
+			opts:     Options{Identifier: "urn:isbn:9781632153524"},
+			expected: "urn:isbn:9781632153524",
+		},
+		{
+			name:     "ISBN",
+			file:     &cbz.File{Images: pages, ComicInfo: &cbz.ComicInfo{GTIN: "978-1-60706-601-9", Series: "Saga", Volume: 1}},
+			expected: "urn:isbn:9781607066019",
+		},
+		{
+			name:     "EAN-13 of another product",
+			file:     &cbz.File{Images: pages, ComicInfo: &cbz.ComicInfo{GTIN: "4006381333931"}},
+			expected: "urn:uuid:" + util.NameUUID("cbz2epub:gtin:4006381333931"),
+		},
+		{
+			name:     "ISBN with a wrong check digit",
+			file:     &cbz.File{Images: pages, ComicInfo: &cbz.ComicInfo{GTIN: "978-1-60706-601-8"}},
+			expected: "urn:uuid:" + util.NameUUID("cbz2epub:gtin:978-1-60706-601-8"),
+		},
+		{
+			name:     "series and volume with other pages and title",
+			file:     &cbz.File{Images: otherPages, ComicInfo: &cbz.ComicInfo{Series: "Saga", Volume: 1, Title: "New Title"}},
+			expected: seriesID,
+		},
+		{
+			name:     "pages with other metadata",
+			file:     &cbz.File{Name: "other.cbz", Images: pages, ComicInfo: &cbz.ComicInfo{Title: "Other"}},
+			expected: pagesID,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := bookIdentifier(tc.file, tc.opts); got != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+
+	if !strings.HasPrefix(seriesID, "urn:uuid:") || seriesID == pagesID {
+		t.Errorf("Expected distinct name-based UUIDs, got %s and %s", seriesID, pagesID)
+	}
+	if other := bookIdentifier(&cbz.File{Images: otherPages}, Options{}); other == pagesID {
+		t.Errorf("Expected other pages to get another identifier")
+	}
+	if other := bookIdentifier(&cbz.File{Images: pages, ComicInfo: &cbz.ComicInfo{Series: "Saga", Volume: 2}}, Options{}); other == seriesID {
+		t.Errorf("Expected another volume to get another identifier")
+	}
+	if other := bookIdentifier(&cbz.File{Images: pages, ComicInfo: &cbz.ComicInfo{Series: "Saga", Volume: 1, LanguageISO: "fr"}}, Options{}); other == seriesID {
+		t.Errorf("Expected a translation to get another identifier")
+	}
+	if other := bookIdentifier(&cbz.File{Images: pages, ComicInfo: &cbz.ComicInfo{Series: "Saga", Volume: 1, Publisher: "Urban Comics"}}, Options{}); other == seriesID {
+		t.Errorf("Expected another edition to get another identifier")
+	}
+
+	// The ISBN is not repeated when it is the identifier
+	elements := metadataElements(&cbz.ComicInfo{GTIN: "9781607066019"}, true, "urn:isbn:9781607066019")
+	if strings.Contains(elements, "dc:identifier") {
+		t.Errorf("Expected no second ISBN identifier, got %s", elements)
+	}
+
+	// Only ISBNs are labelled as ISBN
+	identifierTests := []struct {
+		gtin     string
+		epub3    bool
+		expected string
+	}{
+		{"978-1-60706-601-9", true, `<dc:identifier id="isbn">urn:isbn:9781607066019</dc:identifier>`},
+		{"978-1-60706-601-9", false, `<dc:identifier opf:scheme="ISBN">9781607066019</dc:identifier>`},
+		{"761941366357", true, `<dc:identifier>761941366357</dc:identifier>`},
+		{"761941366357", false, `<dc:identifier>761941366357</dc:identifier>`},
+		{"4006381333931", true, `<dc:identifier>4006381333931</dc:identifier>`},
+		{"978-1-60706-601-8", false, `<dc:identifier>978-1-60706-601-8</dc:identifier>`},
+	}
+	for _, tc := range identifierTests {
+		elements := metadataElements(&cbz.ComicInfo{GTIN: tc.gtin}, tc.epub3, "urn:uuid:test")
+		if !strings.Contains(elements, tc.expected) || strings.Count(elements, "dc:identifier") != 2 {
+			t.Errorf("Expected %s for GTIN %s, got %s", tc.expected, tc.gtin, elements)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"cbz2epub/cbz"
+)
+
+// container represents META-INF/container.xml
+type container struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// packageDocument represents the parts of content.opf needed for extraction
+type packageDocument struct {
+	Metadata opfMetadata `xml:"metadata"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine struct {
+		Direction string `xml:"page-progression-direction,attr"`
+		ItemRefs  []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+// opfMetadata represents the Dublin Core metadata of an OPF file
+type opfMetadata struct {
+	Titles      []string `xml:"title"`
+	Creators    []string `xml:"creator"`
+	Publisher   string   `xml:"publisher"`
+	Description string   `xml:"description"`
+	Language    string   `xml:"language"`
+	Date        string   `xml:"date"`
+	Subjects    []string `xml:"subject"`
+	Identifiers []struct {
+		Scheme string `xml:"scheme,attr"`
+		Value  string `xml:",chardata"`
+	} `xml:"identifier"`
+	Metas []struct {
+		Name     string `xml:"name,attr"`
+		Content  string `xml:"content,attr"`
+		Property string `xml:"property,attr"`
+		Value    string `xml:",chardata"`
+	} `xml:"meta"`
+}
+
+// ExtractFile converts an EPUB file back to CBZ format
+func ExtractFile(inputFile, outputFile string) error {
+	// Read the EPUB file
+	cbzFile, err := ReadFile(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to read EPUB file: %w", err)
+	}
+
+	// Write the CBZ file
+	err = cbz.WriteFile(cbzFile, outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to write CBZ file: %w", err)
+	}
+
+	return nil
+}
+
+// ReadFile reads the page images of an EPUB file in spine order. Each spine
+// item is either an image itself or an XHTML page showing an image; spine
+// items without an image are skipped.
+func ReadFile(filename string) (*cbz.File, error) {
+	return ReadFileContext(context.Background(), filename, nil)
+}
+
+// ReadFileContext reads the page images of an EPUB file like ReadFile,
+// reporting each page read to progress. Reading stops between pages when
+// the context is done.
+func ReadFileContext(ctx context.Context, filename string, progress cbz.ProgressFunc) (*cbz.File, error) {
+	zipReader, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EPUB file: %w", err)
+	}
+	defer zipReader.Close()
+
+	return readBook(ctx, &zipReader.Reader, filename, progress)
+}
+
+// Read reads the page images of an EPUB file of the given size from r,
+// such as an uploaded file. The book has no name.
+func Read(r io.ReaderAt, size int64) (*cbz.File, error) {
+	return ReadContext(context.Background(), r, size, nil)
+}
+
+// ReadContext reads the page images of an EPUB file from r like Read,
+// reporting each page read to progress. Reading stops between pages when
+// the context is done.
+func ReadContext(ctx context.Context, r io.ReaderAt, size int64, progress cbz.ProgressFunc) (*cbz.File, error) {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EPUB file: %w", err)
+	}
+	return readBook(ctx, zipReader, "", progress)
+}
+
+// readBook reads the page images of an opened EPUB file
+func readBook(ctx context.Context, zipReader *zip.Reader, filename string, progress cbz.ProgressFunc) (*cbz.File, error) {
+	// Index the files in the archive
+	files := make(map[string]*zip.File)
+	for _, file := range zipReader.File {
+		files[file.Name] = file
+	}
+
+	opfPath, opf, err := readPackage(files)
+	if err != nil {
+		return nil, err
+	}
+
+	cbzFile := &cbz.File{
+		Name:      filename,
+		Images:    []cbz.Image{},
+		ComicInfo: opf.Metadata.comicInfo(),
+	}
+
+	// Right-to-left books store their reading direction in the spine
+	if opf.Spine.Direction == "rtl" {
+		cbzFile.ComicInfo.Manga = "YesAndRightToLeft"
+	}
+
+	// The manifest declares the media type of every image
+	opfDir := path.Dir(opfPath)
+	mediaTypes := make(map[string]string)
+	for _, item := range opf.Manifest {
+		mediaTypes[resolvePath(opfDir, item.Href)] = item.MediaType
+	}
+
+	// Resolve the image of each spine item in reading order
+	for _, itemRef := range opf.Spine.ItemRefs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for _, item := range opf.Manifest {
+			if item.ID != itemRef.IDRef {
+				continue
+			}
+
+			imagePath := resolvePath(opfDir, item.Href)
+			if !strings.HasPrefix(item.MediaType, "image/") {
+				pageData, err := readEntry(files, imagePath)
+				if err != nil {
+					return nil, err
+				}
+				src := findPageImage(pageData)
+				if src == "" {
+					break
+				}
+				imagePath = resolvePath(path.Dir(imagePath), src)
+			}
+
+			data, err := readEntry(files, imagePath)
+			if err != nil {
+				return nil, err
+			}
+
+			// Name the pages by reading order, with the extension of their
+			// media type
+			mimeType := imageMediaType(mediaTypes, imagePath)
+			ext := cbz.Extension(mimeType)
+			if ext == "" {
+				ext = strings.ToLower(path.Ext(imagePath))
+			}
+			pageName := fmt.Sprintf("page%03d%s", len(cbzFile.Images)+1, ext)
+			cbzFile.Images = append(cbzFile.Images, cbz.Image{
+				Name:     pageName,
+				Path:     pageName,
+				Data:     data,
+				MimeType: mimeType,
+			})
+			progress.Report(cbz.Progress{Stage: cbz.StageRead, Pages: len(cbzFile.Images), TotalPages: len(opf.Spine.ItemRefs)})
+			break
+		}
+	}
+
+	if len(cbzFile.Images) == 0 {
+		if filename == "" {
+			return nil, fmt.Errorf("no page images found in EPUB file")
+		}
+		return nil, fmt.Errorf("no page images found in %s", filename)
+	}
+	cbzFile.ComicInfo.PageCount = len(cbzFile.Images)
+
+	return cbzFile, nil
+}
+
+// readPackage finds and parses the OPF file of an EPUB file
+func readPackage(files map[string]*zip.File) (string, *packageDocument, error) {
+	// Find the OPF file
+	containerData, err := readEntry(files, "META-INF/container.xml")
+	if err != nil {
+		return "", nil, err
+	}
+	var containerXML container
+	if err := xml.Unmarshal(containerData, &containerXML); err != nil {
+		return "", nil, fmt.Errorf("failed to parse container.xml: %w", err)
+	}
+	if len(containerXML.Rootfiles) == 0 {
+		return "", nil, fmt.Errorf("no rootfile found in container.xml")
+	}
+	opfPath := containerXML.Rootfiles[0].FullPath
+
+	// Parse the OPF file
+	opfData, err := readEntry(files, opfPath)
+	if err != nil {
+		return "", nil, err
+	}
+	opf := &packageDocument{}
+	if err := xml.Unmarshal(opfData, opf); err != nil {
+		return "", nil, fmt.Errorf("failed to parse %s: %w", opfPath, err)
+	}
+	return opfPath, opf, nil
+}
+
+// ParseOPF reads the metadata of an OPF package document, such as the
+// metadata.opf files Calibre keeps next to each book
+func ParseOPF(data []byte) (*cbz.ComicInfo, error) {
+	opf := &packageDocument{}
+	if err := xml.Unmarshal(data, opf); err != nil {
+		return nil, fmt.Errorf("failed to parse OPF file: %w", err)
+	}
+	return opf.Metadata.comicInfo(), nil
+}
+
+// SourceHash returns the hash of the source file recorded in an EPUB file
+// written with Options.SourceHash, or an empty string if none is recorded
+func SourceHash(filename string) (string, error) {
+	zipReader, err := zip.OpenReader(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to open EPUB file: %w", err)
+	}
+	defer zipReader.Close()
+
+	files := make(map[string]*zip.File)
+	for _, file := range zipReader.File {
+		files[file.Name] = file
+	}
+
+	_, opf, err := readPackage(files)
+	if err != nil {
+		return "", err
+	}
+	for _, meta := range opf.Metadata.Metas {
+		if meta.Name == sourceHashMeta {
+			return meta.Content, nil
+		}
+	}
+	return "", nil
+}
+
+// comicInfo builds ComicInfo metadata from the OPF metadata
+func (m opfMetadata) comicInfo() *cbz.ComicInfo {
+	var creators []string
+	for _, creator := range m.Creators {
+		if creator = strings.TrimSpace(creator); creator != "" {
+			creators = append(creators, creator)
+		}
+	}
+
+	comicInfo := &cbz.ComicInfo{
+		Writer:      strings.Join(creators, ", "),
+		Publisher:   strings.TrimSpace(m.Publisher),
+		Summary:     strings.TrimSpace(m.Description),
+		LanguageISO: strings.TrimSpace(m.Language),
+		Tags:        strings.Join(m.Subjects, ", "),
+	}
+	if len(m.Titles) > 0 {
+		comicInfo.Title = strings.TrimSpace(m.Titles[0])
+	}
+
+	// The date may be a year, a full date or a timestamp. Calibre writes
+	// the year 101 for unknown dates.
+	for i, part := range strings.SplitN(strings.TrimSpace(m.Date), "-", 3) {
+		if len(part) > 2 && i > 0 {
+			part = part[:2]
+		}
+		value, err := strconv.Atoi(part)
+		if err != nil || (i == 0 && value < 1000) {
+			break
+		}
+		switch i {
+		case 0:
+			comicInfo.Year = value
+		case 1:
+			comicInfo.Month = value
+		case 2:
+			comicInfo.Day = value
+		}
+	}
+
+	// Use an ISBN identifier as GTIN
+	for _, identifier := range m.Identifiers {
+		value := strings.TrimSpace(identifier.Value)
+		if strings.EqualFold(identifier.Scheme, "isbn") {
+			comicInfo.GTIN = value
+		} else if strings.HasPrefix(strings.ToLower(value), "urn:isbn:") {
+			comicInfo.GTIN = value[len("urn:isbn:"):]
+		}
+	}
+
+	// Calibre and EPUB 3 series metadata
+	for _, meta := range m.Metas {
+		switch {
+		case meta.Name == "calibre:series":
+			comicInfo.Series = meta.Content
+		case meta.Name == "calibre:series_index":
+			comicInfo.Number = strings.TrimSuffix(meta.Content, ".0")
+		case meta.Property == "belongs-to-collection":
+			comicInfo.Series = strings.TrimSpace(meta.Value)
+		case meta.Property == "group-position":
+			comicInfo.Number = strings.TrimSpace(meta.Value)
+		}
+	}
+
+	return comicInfo
+}
+
+// findPageImage returns the source of the first image on an XHTML page,
+// either an <img> element or an SVG <image> element
+func findPageImage(data []byte) string {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		for _, attr := range element.Attr {
+			if (element.Name.Local == "img" && attr.Name.Local == "src") ||
+				(element.Name.Local == "image" && attr.Name.Local == "href") {
+				return attr.Value
+			}
+		}
+	}
+}
+
+// resolvePath resolves a relative, possibly URL-encoded, reference against
+// a directory inside the archive
+func resolvePath(dir, ref string) string {
+	if i := strings.IndexAny(ref, "#?"); i >= 0 {
+		ref = ref[:i]
+	}
+	if unescaped, err := url.PathUnescape(ref); err == nil {
+		ref = unescaped
+	}
+	if strings.HasPrefix(ref, "/") {
+		return strings.TrimPrefix(path.Clean(ref), "/")
+	}
+	return path.Clean(path.Join(dir, ref))
+}
+
+// readEntry reads a file from the EPUB archive
+func readEntry(files map[string]*zip.File, name string) ([]byte, error) {
+	file, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("file not found in EPUB: %s", name)
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	return data, nil
+}
+
+// imageMediaType returns the media type of an image from the manifest,
+// falling back to its extension for images missing from the manifest
+func imageMediaType(mediaTypes map[string]string, imagePath string) string {
+	if mediaType := mediaTypes[imagePath]; strings.HasPrefix(mediaType, "image/") {
+		return mediaType
+	}
+	return cbz.MimeType(imagePath)
+}
@@ -0,0 +1,230 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cbz2epub/cbz"
+)
+
+// createTestEPUB creates an EPUB file with the given files
+func createTestEPUB(t *testing.T, filename string, files []struct{ name, content string }) {
+	zipFile, err := os.Create(filename)
+	if err != nil {
+		t.Fatalf("Failed to create test EPUB file: %v", err)
+	}
+	defer zipFile.Close()
+
+	zipWriter := zip.NewWriter(zipFile)
+	defer zipWriter.Close()
+
+	for _, file := range files {
+		writer, err := zipWriter.Create(file.name)
+		if err != nil {
+			t.Fatalf("Failed to create file in test EPUB: %v", err)
+		}
+
+		_, err = writer.Write([]byte(file.content))
+		if err != nil {
+			t.Fatalf("Failed to write data in test EPUB: %v", err)
+		}
+	}
+}
+
+// TestReadFile tests reading a fixed-layout EPUB in spine order
+func TestReadFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	epubPath := filepath.Join(tempDir, "test.epub")
+	createTestEPUB(t, epubPath, []struct{ name, content string }{
+		{"mimetype", "application/epub+zip"},
+		{"META-INF/container.xml", `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles><rootfile full-path="book/package.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`},
+		{"book/package.opf", `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Test Comic</dc:title>
+    <dc:creator>Jane Doe</dc:creator>
+    <dc:creator>John Doe</dc:creator>
+    <dc:publisher>Test Press</dc:publisher>
+    <dc:language>fr</dc:language>
+    <dc:date>2019-05-21T00:00:00Z</dc:date>
+    <dc:identifier>urn:isbn:9781234567897</dc:identifier>
+    <meta property="belongs-to-collection" id="c01">Test Series</meta>
+    <meta property="group-position" refines="#c01">4</meta>
+  </metadata>
+  <manifest>
+    <item id="p2" href="text/p2.xhtml" media-type="application/xhtml+xml"/>
+    <item id="p1" href="text/p1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="credits" href="text/credits.xhtml" media-type="application/xhtml+xml"/>
+    <item id="img3" href="img/third%20page.png" media-type="image/png"/>
+    <item id="i1" href="img/a.jpg" media-type="image/jpeg"/>
+    <item id="i2" href="img/b" media-type="image/jpeg"/>
+  </manifest>
+  <spine page-progression-direction="rtl">
+    <itemref idref="p1"/>
+    <itemref idref="p2"/>
+    <itemref idref="credits"/>
+    <itemref idref="img3"/>
+  </spine>
+</package>`},
+		{"book/text/p1.xhtml", `<html xmlns="http://www.w3.org/1999/xhtml"><body><img src="../img/b" alt=""/></body></html>`},
+		{"book/text/p2.xhtml", `<html xmlns="http://www.w3.org/1999/xhtml"><body><svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"><image xlink:href="../img/a.jpg"/></svg></body></html>`},
+		{"book/text/credits.xhtml", `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Thanks&nbsp;for reading</p></body></html>`},
+		{"book/img/a.jpg", "image a"},
+		{"book/img/b", "image b"},
+		{"book/img/third page.png", "image c"},
+	})
+
+	cbzFile, err := ReadFile(epubPath)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+
+	// Check that the pages follow the spine and skip the text page. Media
+	// types and extensions come from the manifest, not the file names.
+	expected := []struct{ name, content, mimeType string }{
+		{"page001.jpg", "image b", "image/jpeg"},
+		{"page002.jpg", "image a", "image/jpeg"},
+		{"page003.png", "image c", "image/png"},
+	}
+	if len(cbzFile.Images) != len(expected) {
+		t.Fatalf("Expected %d images, got %d", len(expected), len(cbzFile.Images))
+	}
+	for i, image := range cbzFile.Images {
+		if image.Name != expected[i].name || string(image.Data) != expected[i].content || image.MimeType != expected[i].mimeType {
+			t.Errorf("Expected page %d to be %s (%s, %s), got %s (%s, %s)", i+1, expected[i].name, expected[i].content, expected[i].mimeType, image.Name, string(image.Data), image.MimeType)
+		}
+	}
+
+	// Check the metadata
+	comicInfo := cbzFile.ComicInfo
+	if comicInfo.Title != "Test Comic" || comicInfo.Writer != "Jane Doe, John Doe" || comicInfo.Publisher != "Test Press" {
+		t.Errorf("Unexpected ComicInfo: %+v", comicInfo)
+	}
+	if comicInfo.Year != 2019 || comicInfo.Month != 5 || comicInfo.Day != 21 {
+		t.Errorf("Unexpected date: %d-%d-%d", comicInfo.Year, comicInfo.Month, comicInfo.Day)
+	}
+	if comicInfo.GTIN != "9781234567897" || comicInfo.LanguageISO != "fr" {
+		t.Errorf("Unexpected GTIN or language: %s, %s", comicInfo.GTIN, comicInfo.LanguageISO)
+	}
+	if comicInfo.Series != "Test Series" || comicInfo.Number != "4" || comicInfo.PageCount != 3 {
+		t.Errorf("Unexpected series: %s #%s (%d pages)", comicInfo.Series, comicInfo.Number, comicInfo.PageCount)
+	}
+	if comicInfo.Manga != "YesAndRightToLeft" {
+		t.Errorf("Expected the right-to-left spine to set Manga, got %q", comicInfo.Manga)
+	}
+}
+
+// TestExtractFile tests converting an EPUB written by ConvertFromCBZ back to CBZ
+func TestExtractFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	testImages := []struct{ name, content string }{
+		{"image1.jpg", "test image 1 content"},
+		{"image2.png", "test image 2 content"},
+	}
+	cbzFile := createTestCBZ(t, filepath.Join(tempDir, "test.cbz"), testImages)
+
+	epubPath := filepath.Join(tempDir, "test.epub")
+	if err := ConvertFromCBZ(cbzFile, epubPath); err != nil {
+		t.Fatalf("ConvertFromCBZ failed: %v", err)
+	}
+
+	outputPath := filepath.Join(tempDir, "extracted.cbz")
+	if err := ExtractFile(epubPath, outputPath); err != nil {
+		t.Fatalf("ExtractFile failed: %v", err)
+	}
+
+	extracted, err := cbz.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read extracted CBZ: %v", err)
+	}
+
+	if len(extracted.Images) != len(testImages) {
+		t.Fatalf("Expected %d images, got %d", len(testImages), len(extracted.Images))
+	}
+	for i, image := range extracted.Images {
+		if string(image.Data) != testImages[i].content {
+			t.Errorf("Expected image content %s, got %s", testImages[i].content, string(image.Data))
+		}
+	}
+
+	if extracted.ComicInfo == nil || extracted.ComicInfo.Title != "test" {
+		t.Errorf("Expected ComicInfo with title from the EPUB, got %+v", extracted.ComicInfo)
+	}
+
+	// Test with a non-existent file
+	if err := ExtractFile(filepath.Join(tempDir, "nonexistent.epub"), outputPath); err == nil {
+		t.Errorf("ExtractFile should fail with non-existent file")
+	}
+}
+
+// TestSourceHash tests reading the recorded source hash
+func TestSourceHash(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "epub_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cbzFile := &cbz.File{
+		Name:   "test.cbz",
+		Images: []cbz.Image{{Name: "001.jpg", Data: []byte("fake image data"), MimeType: "image/jpeg"}},
+	}
+
+	// Test with and without a recorded hash
+	for _, hash := range []string{"sha256:0123abcd", ""} {
+		epubPath := filepath.Join(tempDir, "test.epub")
+		if err := ConvertWithOptions(cbzFile, epubPath, Options{SourceHash: hash}); err != nil {
+			t.Fatalf("ConvertWithOptions failed: %v", err)
+		}
+
+		recorded, err := SourceHash(epubPath)
+		if err != nil {
+			t.Fatalf("SourceHash failed: %v", err)
+		}
+		if recorded != hash {
+			t.Errorf("Expected source hash %q, got %q", hash, recorded)
+		}
+	}
+
+	// Test with a file that is not an EPUB
+	if _, err := SourceHash(filepath.Join(tempDir, "nonexistent.epub")); err == nil {
+		t.Errorf("SourceHash should fail for a missing file")
+	}
+}
+
+// TestRead tests reading an EPUB file from memory
+func TestRead(t *testing.T) {
+	var buf bytes.Buffer
+	book := &cbz.File{
+		Images:    []cbz.Image{{Name: "001.png", Data: []byte("fake image data"), MimeType: "image/png"}},
+		ComicInfo: &cbz.ComicInfo{Title: "In Memory"},
+	}
+	if err := Write(&buf, book, Options{}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	readBook, err := Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(readBook.Images) != 1 || string(readBook.Images[0].Data) != "fake image data" || readBook.ComicInfo.Title != "In Memory" {
+		t.Errorf("Unexpected book: %+v", readBook)
+	}
+}
@@ -0,0 +1,204 @@
+package panel
+
+import (
+	"image"
+	"image/color"
+)
+
+const (
+	// maxSamples is the number of samples along the longest side of a page.
+	// Larger pages are sampled with a coarser step.
+	maxSamples = 1000
+	// inkTolerance is the luminance difference from the gutter color that
+	// makes a pixel part of the artwork
+	inkTolerance = 48
+	// maxDepth limits how often a region is cut into smaller panels
+	maxDepth = 8
+)
+
+// Detect finds the panels of a comic page from the gutters between them and
+// returns them in reading order. Pages are cut recursively along rows and
+// columns that only contain the gutter color, which is taken from the page
+// border. Pages without at least two panels return nil.
+func Detect(img image.Image, rightToLeft bool) []image.Rectangle {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil
+	}
+
+	d := newDetector(img)
+	page := image.Rect(0, 0, d.width, d.height)
+
+	var panels []image.Rectangle
+	for _, r := range d.cut(page, rightToLeft, 0) {
+		// Skip page numbers, captions and other specks
+		if r.Dx()*20 < d.width || r.Dy()*20 < d.height || r.Dx()*r.Dy()*100 < d.width*d.height {
+			continue
+		}
+		panels = append(panels, image.Rect(
+			bounds.Min.X+r.Min.X*d.step,
+			bounds.Min.Y+r.Min.Y*d.step,
+			bounds.Min.X+min(r.Max.X*d.step, bounds.Dx()),
+			bounds.Min.Y+min(r.Max.Y*d.step, bounds.Dy()),
+		))
+	}
+	if len(panels) < 2 {
+		return nil
+	}
+	return panels
+}
+
+// detector holds the sampled ink mask of a page as a summed-area table, so
+// the ink in any rectangle can be counted in constant time
+type detector struct {
+	width, height int
+	step          int
+	sums          []int
+	minGap        int
+}
+
+// newDetector samples the luminance of a page and marks every sample that
+// differs from the gutter color as ink
+func newDetector(img image.Image) *detector {
+	bounds := img.Bounds()
+	step := max(1, (max(bounds.Dx(), bounds.Dy())+maxSamples-1)/maxSamples)
+	d := &detector{
+		width:  (bounds.Dx() + step - 1) / step,
+		height: (bounds.Dy() + step - 1) / step,
+		step:   step,
+	}
+	d.minGap = max(2, min(d.width, d.height)/150)
+
+	luminance := make([]uint8, d.width*d.height)
+	for y := 0; y < d.height; y++ {
+		for x := 0; x < d.width; x++ {
+			c := color.GrayModel.Convert(img.At(bounds.Min.X+x*step, bounds.Min.Y+y*step)).(color.Gray)
+			luminance[y*d.width+x] = c.Y
+		}
+	}
+
+	// The gutter color is the average color of the page border
+	total, count := 0, 0
+	for x := 0; x < d.width; x++ {
+		total += int(luminance[x]) + int(luminance[(d.height-1)*d.width+x])
+		count += 2
+	}
+	for y := 0; y < d.height; y++ {
+		total += int(luminance[y*d.width]) + int(luminance[y*d.width+d.width-1])
+		count += 2
+	}
+	gutter := total / count
+
+	d.sums = make([]int, (d.width+1)*(d.height+1))
+	for y := 0; y < d.height; y++ {
+		row := 0
+		for x := 0; x < d.width; x++ {
+			diff := int(luminance[y*d.width+x]) - gutter
+			if diff > inkTolerance || diff < -inkTolerance {
+				row++
+			}
+			d.sums[(y+1)*(d.width+1)+x+1] = d.sums[y*(d.width+1)+x+1] + row
+		}
+	}
+	return d
+}
+
+// ink counts the ink samples in a rectangle
+func (d *detector) ink(r image.Rectangle) int {
+	w := d.width + 1
+	return d.sums[r.Max.Y*w+r.Max.X] - d.sums[r.Min.Y*w+r.Max.X] -
+		d.sums[r.Max.Y*w+r.Min.X] + d.sums[r.Min.Y*w+r.Min.X]
+}
+
+// isGutter checks if a row or column of samples is blank, allowing for a
+// little noise from scans
+func (d *detector) isGutter(r image.Rectangle) bool {
+	return d.ink(r)*100 <= r.Dx()*r.Dy()
+}
+
+// trim shrinks a rectangle until its edges touch ink
+func (d *detector) trim(r image.Rectangle) image.Rectangle {
+	for r.Min.Y < r.Max.Y && d.isGutter(image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+1)) {
+		r.Min.Y++
+	}
+	for r.Max.Y > r.Min.Y && d.isGutter(image.Rect(r.Min.X, r.Max.Y-1, r.Max.X, r.Max.Y)) {
+		r.Max.Y--
+	}
+	for r.Min.X < r.Max.X && d.isGutter(image.Rect(r.Min.X, r.Min.Y, r.Min.X+1, r.Max.Y)) {
+		r.Min.X++
+	}
+	for r.Max.X > r.Min.X && d.isGutter(image.Rect(r.Max.X-1, r.Min.Y, r.Max.X, r.Max.Y)) {
+		r.Max.X--
+	}
+	return r
+}
+
+// cut splits a region into panels, trying rows before columns so panels
+// are read tier by tier
+func (d *detector) cut(r image.Rectangle, rightToLeft bool, depth int) []image.Rectangle {
+	r = d.trim(r)
+	if r.Empty() {
+		return nil
+	}
+	if depth >= maxDepth {
+		return []image.Rectangle{r}
+	}
+
+	parts := d.split(r, true)
+	if len(parts) < 2 {
+		parts = d.split(r, false)
+		if rightToLeft {
+			for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+				parts[i], parts[j] = parts[j], parts[i]
+			}
+		}
+	}
+	if len(parts) < 2 {
+		return []image.Rectangle{r}
+	}
+
+	var panels []image.Rectangle
+	for _, part := range parts {
+		panels = append(panels, d.cut(part, rightToLeft, depth+1)...)
+	}
+	return panels
+}
+
+// split divides a trimmed region at gutters running across it, along rows
+// if horizontal is set and along columns otherwise
+func (d *detector) split(r image.Rectangle, horizontal bool) []image.Rectangle {
+	start, end := r.Min.X, r.Max.X
+	if horizontal {
+		start, end = r.Min.Y, r.Max.Y
+	}
+	line := func(i int) image.Rectangle {
+		if horizontal {
+			return image.Rect(r.Min.X, i, r.Max.X, i+1)
+		}
+		return image.Rect(i, r.Min.Y, i+1, r.Max.Y)
+	}
+	part := func(from, to int) image.Rectangle {
+		if horizontal {
+			return image.Rect(r.Min.X, from, r.Max.X, to)
+		}
+		return image.Rect(from, r.Min.Y, to, r.Max.Y)
+	}
+
+	var parts []image.Rectangle
+	from := start
+	for i := start; i < end; {
+		if !d.isGutter(line(i)) {
+			i++
+			continue
+		}
+		gap := i
+		for i < end && d.isGutter(line(i)) {
+			i++
+		}
+		if i-gap >= d.minGap {
+			parts = append(parts, part(from, gap))
+			from = i
+		}
+	}
+	return append(parts, part(from, end))
+}
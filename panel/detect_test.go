@@ -0,0 +1,111 @@
+package panel
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// drawPage draws panels filled with the ink color on a page of the gutter color
+func drawPage(bounds image.Rectangle, gutter, ink color.Color, panels []image.Rectangle) *image.RGBA {
+	page := image.NewRGBA(bounds)
+	draw.Draw(page, bounds, image.NewUniform(gutter), image.Point{}, draw.Src)
+	for _, p := range panels {
+		draw.Draw(page, p, image.NewUniform(ink), image.Point{}, draw.Src)
+	}
+	return page
+}
+
+// TestDetect tests the Detect function
+func TestDetect(t *testing.T) {
+	top := image.Rect(10, 10, 290, 190)
+	left := image.Rect(10, 210, 145, 390)
+	right := image.Rect(155, 210, 290, 390)
+	panels := []image.Rectangle{top, left, right}
+
+	testCases := []struct {
+		name        string
+		page        image.Image
+		rightToLeft bool
+		expected    []image.Rectangle
+	}{
+		{
+			name:     "white gutters",
+			page:     drawPage(image.Rect(0, 0, 300, 400), color.White, color.Black, panels),
+			expected: []image.Rectangle{top, left, right},
+		},
+		{
+			name:        "right to left",
+			page:        drawPage(image.Rect(0, 0, 300, 400), color.White, color.Black, panels),
+			rightToLeft: true,
+			expected:    []image.Rectangle{top, right, left},
+		},
+		{
+			name:     "black gutters",
+			page:     drawPage(image.Rect(0, 0, 300, 400), color.Black, color.White, panels),
+			expected: []image.Rectangle{top, left, right},
+		},
+		{
+			name:     "offset bounds",
+			page:     drawPage(image.Rect(0, 0, 300, 400), color.White, color.Black, panels).SubImage(image.Rect(5, 5, 295, 395)),
+			expected: []image.Rectangle{top, left, right},
+		},
+		{
+			name:     "page number is ignored",
+			page:     drawPage(image.Rect(0, 0, 300, 400), color.White, color.Black, []image.Rectangle{top, left, right, image.Rect(148, 393, 152, 397)}),
+			expected: []image.Rectangle{top, left, right},
+		},
+		{
+			name:     "single panel",
+			page:     drawPage(image.Rect(0, 0, 300, 400), color.White, color.Black, []image.Rectangle{image.Rect(10, 10, 290, 390)}),
+			expected: nil,
+		},
+		{
+			name:     "blank page",
+			page:     drawPage(image.Rect(0, 0, 300, 400), color.White, color.Black, nil),
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			detected := Detect(tc.page, tc.rightToLeft)
+			if len(detected) != len(tc.expected) {
+				t.Fatalf("Expected %d panels, got %v", len(tc.expected), detected)
+			}
+			for i := range tc.expected {
+				if detected[i] != tc.expected[i] {
+					t.Errorf("Expected panel %d to be %v, got %v", i, tc.expected[i], detected[i])
+				}
+			}
+		})
+	}
+}
+
+// TestDetectLargePage tests that sampled pages map back to image pixels
+func TestDetectLargePage(t *testing.T) {
+	page := drawPage(image.Rect(0, 0, 1500, 2400), color.White, color.Black, []image.Rectangle{
+		image.Rect(40, 40, 1460, 1180),
+		image.Rect(40, 1220, 1460, 2360),
+	})
+
+	detected := Detect(page, false)
+	if len(detected) != 2 {
+		t.Fatalf("Expected 2 panels, got %v", detected)
+	}
+	for i, expected := range []image.Rectangle{image.Rect(40, 40, 1460, 1180), image.Rect(40, 1220, 1460, 2360)} {
+		// Edges may be off by up to one sampling step
+		if abs(detected[i].Min.X-expected.Min.X) > 3 || abs(detected[i].Min.Y-expected.Min.Y) > 3 ||
+			abs(detected[i].Max.X-expected.Max.X) > 3 || abs(detected[i].Max.Y-expected.Max.Y) > 3 {
+			t.Errorf("Expected panel %d near %v, got %v", i, expected, detected[i])
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
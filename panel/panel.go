@@ -0,0 +1,31 @@
+package panel
+
+import (
+	"image"
+)
+
+// Grid divides a page into rows and columns of equal panels, ordered row by
+// row in reading direction. It is a simple stand-in for detected panels that
+// still lets readers zoom into parts of the page.
+func Grid(bounds image.Rectangle, rows, cols int, rightToLeft bool) []image.Rectangle {
+	if rows <= 0 || cols <= 0 || bounds.Empty() {
+		return nil
+	}
+
+	panels := make([]image.Rectangle, 0, rows*cols)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			c := col
+			if rightToLeft {
+				c = cols - 1 - col
+			}
+			panels = append(panels, image.Rect(
+				bounds.Min.X+bounds.Dx()*c/cols,
+				bounds.Min.Y+bounds.Dy()*row/rows,
+				bounds.Min.X+bounds.Dx()*(c+1)/cols,
+				bounds.Min.Y+bounds.Dy()*(row+1)/rows,
+			))
+		}
+	}
+	return panels
+}
@@ -0,0 +1,38 @@
+package panel
+
+import (
+	"image"
+	"testing"
+)
+
+// TestGrid tests the Grid function
+func TestGrid(t *testing.T) {
+	bounds := image.Rect(0, 0, 100, 200)
+
+	panels := Grid(bounds, 2, 2, false)
+	expected := []image.Rectangle{
+		image.Rect(0, 0, 50, 100),
+		image.Rect(50, 0, 100, 100),
+		image.Rect(0, 100, 50, 200),
+		image.Rect(50, 100, 100, 200),
+	}
+	if len(panels) != len(expected) {
+		t.Fatalf("Expected %d panels, got %d", len(expected), len(panels))
+	}
+	for i := range expected {
+		if panels[i] != expected[i] {
+			t.Errorf("Expected panel %d to be %v, got %v", i, expected[i], panels[i])
+		}
+	}
+
+	// Right to left pages start with the top right panel
+	panels = Grid(bounds, 2, 2, true)
+	if panels[0] != image.Rect(50, 0, 100, 100) {
+		t.Errorf("Expected first right to left panel to be top right, got %v", panels[0])
+	}
+
+	// Test with an empty page
+	if panels := Grid(image.Rectangle{}, 2, 2, false); panels != nil {
+		t.Errorf("Expected no panels for an empty page, got %v", panels)
+	}
+}
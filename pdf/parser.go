@@ -0,0 +1,877 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"unicode/utf16"
+)
+
+// PDF object types. Integers are int64, reals float64, literal and hex
+// strings string, booleans bool and null nil.
+type (
+	name    string
+	keyword string
+	array   []interface{}
+	dict    map[name]interface{}
+	ref     struct{ num, gen int }
+	stream  struct {
+		dict dict
+		data []byte
+	}
+)
+
+// xrefEntry locates an object in the file or in an object stream
+type xrefEntry struct {
+	offset int64
+	stream int // Object number of the object stream, 0 for uncompressed objects
+	index  int
+}
+
+// document is a parsed PDF file
+type document struct {
+	data    []byte
+	xref    map[int]xrefEntry
+	trailer dict
+	objects map[int]interface{}
+	loading map[int]bool
+}
+
+// objectPattern finds object headers when the cross-reference table is broken
+var objectPattern = regexp.MustCompile(`(?m)(?:^|[\r\n\s])(\d+)\s+(\d+)\s+obj\b`)
+
+// parseDocument parses the cross-reference information of a PDF file
+func parseDocument(data []byte) (*document, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\n\f\r "), []byte("%PDF-")) {
+		return nil, fmt.Errorf("not a PDF file")
+	}
+
+	d := &document{
+		data:    data,
+		xref:    make(map[int]xrefEntry),
+		objects: make(map[int]interface{}),
+		loading: make(map[int]bool),
+	}
+
+	// Fall back to scanning for objects if the cross-reference table is broken
+	if err := d.readXref(); err != nil || d.trailer["Root"] == nil {
+		d.scanObjects()
+	}
+	if d.trailer["Root"] == nil {
+		return nil, fmt.Errorf("no document catalog found")
+	}
+	if d.trailer["Encrypt"] != nil {
+		return nil, fmt.Errorf("encrypted PDF files are not supported")
+	}
+
+	return d, nil
+}
+
+// readXref reads the cross-reference sections starting at startxref,
+// following /Prev links to older sections. Entries of newer sections win.
+func (d *document) readXref() error {
+	i := bytes.LastIndex(d.data, []byte("startxref"))
+	if i < 0 {
+		return fmt.Errorf("startxref not found")
+	}
+	p := &parser{data: d.data, pos: i + len("startxref")}
+	offset, ok := p.parseObject().(int64)
+	if !ok {
+		return fmt.Errorf("invalid startxref")
+	}
+
+	visited := make(map[int64]bool)
+	for offset > 0 && !visited[offset] {
+		visited[offset] = true
+		if offset >= int64(len(d.data)) {
+			return fmt.Errorf("invalid cross-reference offset %d", offset)
+		}
+
+		var trailer dict
+		var err error
+		p := &parser{data: d.data, pos: int(offset)}
+		p.skipSpace()
+		if bytes.HasPrefix(d.data[p.pos:], []byte("xref")) {
+			p.pos += len("xref")
+			trailer, err = d.readXrefTable(p)
+			// Hybrid files keep compressed objects in an extra xref stream
+			if xrefStm, ok := trailer["XRefStm"].(int64); ok && err == nil {
+				if _, err := d.readXrefStream(xrefStm); err != nil {
+					return err
+				}
+			}
+		} else {
+			trailer, err = d.readXrefStream(offset)
+		}
+		if err != nil {
+			return err
+		}
+
+		if d.trailer == nil {
+			d.trailer = trailer
+		}
+		offset, _ = trailer["Prev"].(int64)
+	}
+
+	return nil
+}
+
+// readXrefTable reads a classic cross-reference table and its trailer
+func (d *document) readXrefTable(p *parser) (dict, error) {
+	for {
+		obj := p.parseObject()
+		if obj == keyword("trailer") {
+			trailer, ok := p.parseObject().(dict)
+			if !ok {
+				return nil, fmt.Errorf("invalid trailer")
+			}
+			return trailer, nil
+		}
+
+		start, ok1 := obj.(int64)
+		count, ok2 := p.parseObject().(int64)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("invalid cross-reference table")
+		}
+
+		for n := start; n < start+count; n++ {
+			offset, ok1 := p.parseObject().(int64)
+			_, ok2 := p.parseObject().(int64)
+			kind, ok3 := p.parseObject().(keyword)
+			if !ok1 || !ok2 || !ok3 {
+				return nil, fmt.Errorf("invalid cross-reference entry")
+			}
+			if _, exists := d.xref[int(n)]; !exists && kind == "n" {
+				d.xref[int(n)] = xrefEntry{offset: offset}
+			} else if !exists {
+				// Remember free entries so older sections don't revive them
+				d.xref[int(n)] = xrefEntry{offset: -1}
+			}
+		}
+	}
+}
+
+// readXrefStream reads a cross-reference stream
+func (d *document) readXrefStream(offset int64) (dict, error) {
+	p := &parser{data: d.data, pos: int(offset), doc: d}
+	_, obj, err := p.parseIndirect()
+	if err != nil {
+		return nil, err
+	}
+	s, ok := obj.(*stream)
+	if !ok || s.dict["Type"] != name("XRef") {
+		return nil, fmt.Errorf("invalid cross-reference stream")
+	}
+
+	data, _, err := d.decodeStream(s, false)
+	if err != nil {
+		return nil, err
+	}
+
+	widths, ok := s.dict["W"].(array)
+	if !ok || len(widths) != 3 {
+		return nil, fmt.Errorf("invalid cross-reference stream widths")
+	}
+	var w [3]int
+	for i := range w {
+		width, _ := widths[i].(int64)
+		w[i] = int(width)
+	}
+
+	index, ok := s.dict["Index"].(array)
+	if !ok {
+		size, _ := s.dict["Size"].(int64)
+		index = array{int64(0), size}
+	}
+
+	pos := 0
+	field := func(width int, def int64) int64 {
+		if width == 0 {
+			return def
+		}
+		var value int64
+		for i := 0; i < width && pos < len(data); i++ {
+			value = value<<8 | int64(data[pos])
+			pos++
+		}
+		return value
+	}
+
+	for i := 0; i+1 < len(index); i += 2 {
+		start, _ := index[i].(int64)
+		count, _ := index[i+1].(int64)
+		for n := start; n < start+count && pos < len(data); n++ {
+			kind := field(w[0], 1)
+			a := field(w[1], 0)
+			b := field(w[2], 0)
+			if _, exists := d.xref[int(n)]; exists {
+				continue
+			}
+			switch kind {
+			case 0:
+				d.xref[int(n)] = xrefEntry{offset: -1}
+			case 1:
+				d.xref[int(n)] = xrefEntry{offset: a}
+			case 2:
+				d.xref[int(n)] = xrefEntry{stream: int(a), index: int(b)}
+			}
+		}
+	}
+
+	return s.dict, nil
+}
+
+// scanObjects rebuilds the cross-reference information by scanning the file
+// for object headers. Later definitions replace earlier ones.
+func (d *document) scanObjects() {
+	d.xref = make(map[int]xrefEntry)
+	d.objects = make(map[int]interface{})
+	for _, match := range objectPattern.FindAllSubmatchIndex(d.data, -1) {
+		num, err := strconv.Atoi(string(d.data[match[2]:match[3]]))
+		if err != nil {
+			continue
+		}
+		d.xref[num] = xrefEntry{offset: int64(match[2])}
+	}
+
+	// Register the objects stored in object streams
+	var objectStreams []int
+	for num := range d.xref {
+		if s, ok := d.object(num).(*stream); ok && s.dict["Type"] == name("ObjStm") {
+			objectStreams = append(objectStreams, num)
+		}
+	}
+	for _, num := range objectStreams {
+		s := d.object(num).(*stream)
+		data, _, err := d.decodeStream(s, false)
+		if err != nil {
+			continue
+		}
+		count, _ := s.dict["N"].(int64)
+		p := &parser{data: data}
+		for i := 0; i < int(count); i++ {
+			objNum, ok := p.parseObject().(int64)
+			if _, ok2 := p.parseObject().(int64); !ok || !ok2 {
+				break
+			}
+			if _, exists := d.xref[int(objNum)]; !exists {
+				d.xref[int(objNum)] = xrefEntry{stream: num, index: i}
+			}
+		}
+	}
+
+	// Use the last trailer, or find the catalog
+	if i := bytes.LastIndex(d.data, []byte("trailer")); i >= 0 {
+		p := &parser{data: d.data, pos: i + len("trailer")}
+		if trailer, ok := p.parseObject().(dict); ok {
+			d.trailer = trailer
+		}
+	}
+	if d.trailer == nil {
+		d.trailer = dict{}
+	}
+	if d.trailer["Root"] == nil {
+		for num := range d.xref {
+			if obj, ok := d.object(num).(dict); ok && obj["Type"] == name("Catalog") {
+				d.trailer["Root"] = ref{num: num}
+				break
+			}
+		}
+	}
+}
+
+// object returns the object with the given number, or nil if it doesn't exist
+func (d *document) object(num int) interface{} {
+	if obj, ok := d.objects[num]; ok {
+		return obj
+	}
+	entry, ok := d.xref[num]
+	if !ok || entry.offset < 0 || d.loading[num] {
+		return nil
+	}
+
+	d.loading[num] = true
+	defer delete(d.loading, num)
+
+	var obj interface{}
+	if entry.stream != 0 {
+		obj = d.compressedObject(entry.stream, entry.index)
+	} else if entry.offset < int64(len(d.data)) {
+		p := &parser{data: d.data, pos: int(entry.offset), doc: d}
+		if _, parsed, err := p.parseIndirect(); err == nil {
+			obj = parsed
+		}
+	}
+
+	d.objects[num] = obj
+	return obj
+}
+
+// compressedObject returns an object stored in an object stream
+func (d *document) compressedObject(streamNum, index int) interface{} {
+	s, ok := d.object(streamNum).(*stream)
+	if !ok {
+		return nil
+	}
+	data, _, err := d.decodeStream(s, false)
+	if err != nil {
+		return nil
+	}
+
+	first, _ := d.resolve(s.dict["First"]).(int64)
+	p := &parser{data: data, doc: d}
+	var offset int64
+	for i := 0; i <= index; i++ {
+		_, ok1 := p.parseObject().(int64)
+		off, ok2 := p.parseObject().(int64)
+		if !ok1 || !ok2 {
+			return nil
+		}
+		offset = off
+	}
+
+	if first+offset < 0 || first+offset >= int64(len(data)) {
+		return nil
+	}
+	p.pos = int(first + offset)
+	return p.parseObject()
+}
+
+// resolve follows indirect references
+func (d *document) resolve(obj interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		r, ok := obj.(ref)
+		if !ok {
+			return obj
+		}
+		obj = d.object(r.num)
+	}
+	return nil
+}
+
+// resolveDict resolves an object that is expected to be a dictionary. The
+// dictionary of a stream is returned for streams.
+func (d *document) resolveDict(obj interface{}) dict {
+	switch obj := d.resolve(obj).(type) {
+	case dict:
+		return obj
+	case *stream:
+		return obj.dict
+	}
+	return nil
+}
+
+// decodeStream applies the filters of a stream. If stopAtImage is set, image
+// filters like DCTDecode are left for the caller and returned.
+func (d *document) decodeStream(s *stream, stopAtImage bool) ([]byte, []name, error) {
+	var filters []name
+	var params []dict
+	switch filter := d.resolve(s.dict["Filter"]).(type) {
+	case name:
+		filters = []name{filter}
+		params = []dict{d.resolveDict(s.dict["DecodeParms"])}
+	case array:
+		parms, _ := d.resolve(s.dict["DecodeParms"]).(array)
+		for i, f := range filter {
+			n, _ := d.resolve(f).(name)
+			filters = append(filters, n)
+			var param dict
+			if i < len(parms) {
+				param = d.resolveDict(parms[i])
+			}
+			params = append(params, param)
+		}
+	}
+
+	data := s.data
+	for i, filter := range filters {
+		var err error
+		switch filter {
+		case "FlateDecode", "Fl":
+			data, err = inflate(data)
+			if err == nil {
+				data, err = unpredict(data, params[i])
+			}
+		case "ASCIIHexDecode", "AHx":
+			data, err = decodeASCIIHex(data)
+		case "ASCII85Decode", "A85":
+			data, err = decodeASCII85(data)
+		default:
+			if stopAtImage {
+				return data, filters[i:], nil
+			}
+			err = fmt.Errorf("unsupported filter %s", filter)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return data, nil, nil
+}
+
+// inflate decompresses FlateDecode data, keeping what could be read from
+// truncated streams
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress stream: %w", err)
+	}
+	defer zr.Close()
+
+	out, err := io.ReadAll(zr)
+	if err != nil && len(out) == 0 {
+		return nil, fmt.Errorf("failed to decompress stream: %w", err)
+	}
+	return out, nil
+}
+
+// unpredict reverses the PNG and TIFF predictors of FlateDecode data
+func unpredict(data []byte, params dict) ([]byte, error) {
+	predictor, _ := params["Predictor"].(int64)
+	if predictor < 2 {
+		return data, nil
+	}
+
+	colors := intParam(params, "Colors", 1)
+	bpc := intParam(params, "BitsPerComponent", 8)
+	columns := intParam(params, "Columns", 1)
+	bpp := (colors*bpc + 7) / 8
+	rowLen := (colors*bpc*columns + 7) / 8
+	if rowLen <= 0 {
+		return nil, fmt.Errorf("invalid predictor parameters")
+	}
+
+	// TIFF predictor, only for 8-bit samples
+	if predictor == 2 {
+		if bpc != 8 {
+			return nil, fmt.Errorf("unsupported TIFF predictor with %d bits per component", bpc)
+		}
+		out := append([]byte(nil), data...)
+		for row := 0; row+rowLen <= len(out); row += rowLen {
+			for i := bpp; i < rowLen; i++ {
+				out[row+i] += out[row+i-bpp]
+			}
+		}
+		return out, nil
+	}
+
+	// PNG predictors, each row starts with its filter type
+	out := make([]byte, 0, len(data)/(rowLen+1)*rowLen)
+	prev := make([]byte, rowLen)
+	for pos := 0; pos < len(data); pos += rowLen + 1 {
+		filterType := data[pos]
+		row := make([]byte, rowLen)
+		copy(row, data[pos+1:])
+		for i := 0; i < rowLen; i++ {
+			var left, upLeft byte
+			if i >= bpp {
+				left = row[i-bpp]
+				upLeft = prev[i-bpp]
+			}
+			up := prev[i]
+			switch filterType {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+// paeth is the Paeth predictor function of the PNG specification
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	} else if pb <= pc {
+		return b
+	}
+	return c
+}
+
+// abs returns the absolute value of an integer
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// intParam returns an integer from a dictionary, or a default value
+func intParam(params dict, key name, def int) int {
+	if value, ok := params[key].(int64); ok {
+		return int(value)
+	}
+	return def
+}
+
+// decodeASCIIHex decodes ASCIIHexDecode data
+func decodeASCIIHex(data []byte) ([]byte, error) {
+	if i := bytes.IndexByte(data, '>'); i >= 0 {
+		data = data[:i]
+	}
+	digits := make([]byte, 0, len(data)+1)
+	for _, c := range data {
+		if !isSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	if _, err := hex.Decode(out, digits); err != nil {
+		return nil, fmt.Errorf("failed to decode ASCIIHex data: %w", err)
+	}
+	return out, nil
+}
+
+// decodeASCII85 decodes ASCII85Decode data
+func decodeASCII85(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+	if i := bytes.Index(data, []byte("~>")); i >= 0 {
+		data = data[:i]
+	}
+	out := make([]byte, len(data))
+	n, _, err := ascii85.Decode(out, data, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode ASCII85 data: %w", err)
+	}
+	return out[:n], nil
+}
+
+// parser reads PDF objects from a byte slice
+type parser struct {
+	data []byte
+	pos  int
+	doc  *document // Used to resolve indirect stream lengths
+}
+
+// parseIndirect parses an indirect object "num gen obj ... endobj"
+func (p *parser) parseIndirect() (int, interface{}, error) {
+	num, ok1 := p.parseObject().(int64)
+	_, ok2 := p.parseObject().(int64)
+	if !ok1 || !ok2 || p.parseObject() != keyword("obj") {
+		return 0, nil, fmt.Errorf("invalid object at offset %d", p.pos)
+	}
+
+	obj := p.parseObject()
+	if d, ok := obj.(dict); ok {
+		start := p.pos
+		if p.parseObject() == keyword("stream") {
+			return int(num), p.parseStream(d), nil
+		}
+		p.pos = start
+	}
+
+	return int(num), obj, nil
+}
+
+// parseStream reads the data of a stream after the stream keyword
+func (p *parser) parseStream(d dict) *stream {
+	// The data starts after the end of line following the keyword
+	if p.pos < len(p.data) && p.data[p.pos] == '\r' {
+		p.pos++
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+		p.pos++
+	}
+	start := p.pos
+
+	var length int64 = -1
+	if p.doc != nil {
+		length, _ = p.doc.resolve(d["Length"]).(int64)
+	} else if l, ok := d["Length"].(int64); ok {
+		length = l
+	}
+
+	// Trust the length only if endstream follows it
+	end := start + int(length)
+	if length < 0 || end > len(p.data) || !bytes.HasPrefix(bytes.TrimLeft(p.data[end:], "\x00\t\n\f\r "), []byte("endstream")) {
+		i := bytes.Index(p.data[start:], []byte("endstream"))
+		if i < 0 {
+			end = len(p.data)
+		} else {
+			end = start + i
+			// Drop the end of line before endstream
+			if end > start && p.data[end-1] == '\n' {
+				end--
+			}
+			if end > start && p.data[end-1] == '\r' {
+				end--
+			}
+		}
+	}
+
+	p.pos = end
+	return &stream{dict: d, data: p.data[start:end]}
+}
+
+// parseObject parses the next object. Unknown bare words are returned as
+// keywords, the end of the data as nil.
+func (p *parser) parseObject() interface{} {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil
+	}
+
+	c := p.data[p.pos]
+	switch {
+	case c == '/':
+		return p.parseName()
+	case c == '(':
+		return p.parseLiteralString()
+	case c == '<' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '<':
+		return p.parseDict()
+	case c == '<':
+		return p.parseHexString()
+	case c == '[':
+		p.pos++
+		arr := array{}
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.data) {
+				return arr
+			}
+			if p.data[p.pos] == ']' {
+				p.pos++
+				return arr
+			}
+			arr = append(arr, p.parseObject())
+		}
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	case isDelimiter(c):
+		// Skip stray delimiters like ')', '>' or '}'
+		p.pos++
+		return keyword(string(c))
+	}
+
+	start := p.pos
+	for p.pos < len(p.data) && !isSpace(p.data[p.pos]) && !isDelimiter(p.data[p.pos]) {
+		p.pos++
+	}
+	switch word := string(p.data[start:p.pos]); word {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	default:
+		return keyword(word)
+	}
+}
+
+// parseNumber parses an integer, a real or an indirect reference
+func (p *parser) parseNumber() interface{} {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.data) && (p.data[p.pos] == '.' || (p.data[p.pos] >= '0' && p.data[p.pos] <= '9')) {
+		p.pos++
+	}
+	text := string(p.data[start:p.pos])
+
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		real, _ := strconv.ParseFloat(text, 64)
+		return real
+	}
+
+	// Look ahead for "gen R"
+	save := p.pos
+	if gen, ok := p.parseUnsigned().(int64); ok {
+		p.skipSpace()
+		if p.pos < len(p.data) && p.data[p.pos] == 'R' && (p.pos+1 == len(p.data) || isSpace(p.data[p.pos+1]) || isDelimiter(p.data[p.pos+1])) {
+			p.pos++
+			return ref{num: int(value), gen: int(gen)}
+		}
+	}
+	p.pos = save
+	return value
+}
+
+// parseUnsigned parses an unsigned integer without reference lookahead
+func (p *parser) parseUnsigned() interface{} {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+		p.pos++
+	}
+	if start == p.pos || (p.pos < len(p.data) && p.data[p.pos] == '.') {
+		return nil
+	}
+	value, err := strconv.ParseInt(string(p.data[start:p.pos]), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return value
+}
+
+// parseName parses a name, decoding #xx escapes
+func (p *parser) parseName() name {
+	p.pos++
+	var b []byte
+	for p.pos < len(p.data) && !isSpace(p.data[p.pos]) && !isDelimiter(p.data[p.pos]) {
+		c := p.data[p.pos]
+		if c == '#' && p.pos+2 < len(p.data) {
+			if v, err := strconv.ParseUint(string(p.data[p.pos+1:p.pos+3]), 16, 8); err == nil {
+				b = append(b, byte(v))
+				p.pos += 3
+				continue
+			}
+		}
+		b = append(b, c)
+		p.pos++
+	}
+	return name(b)
+}
+
+// parseLiteralString parses a string in parentheses
+func (p *parser) parseLiteralString() string {
+	p.pos++
+	var b []byte
+	depth := 1
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return string(b)
+			}
+		case '\\':
+			if p.pos >= len(p.data) {
+				return string(b)
+			}
+			c = p.data[p.pos]
+			p.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+					p.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					value := int(c - '0')
+					for i := 0; i < 2 && p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+						value = value*8 + int(p.data[p.pos]-'0')
+						p.pos++
+					}
+					c = byte(value)
+				}
+			}
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
+
+// parseHexString parses a string in angle brackets
+func (p *parser) parseHexString() string {
+	p.pos++
+	end := bytes.IndexByte(p.data[p.pos:], '>')
+	if end < 0 {
+		end = len(p.data) - p.pos
+	}
+	data, _ := decodeASCIIHex(p.data[p.pos : p.pos+end])
+	p.pos += end + 1
+	return string(data)
+}
+
+// parseDict parses a dictionary
+func (p *parser) parseDict() dict {
+	p.pos += 2
+	d := dict{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return d
+		}
+		if bytes.HasPrefix(p.data[p.pos:], []byte(">>")) {
+			p.pos += 2
+			return d
+		}
+
+		key, ok := p.parseObject().(name)
+		if !ok {
+			continue
+		}
+		d[key] = p.parseObject()
+	}
+}
+
+// skipSpace skips white space and comments
+func (p *parser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c == '%' {
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+		} else if isSpace(c) {
+			p.pos++
+		} else {
+			return
+		}
+	}
+}
+
+// isSpace checks if a byte is PDF white space
+func isSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+// isDelimiter checks if a byte is a PDF delimiter
+func isDelimiter(c byte) bool {
+	return c == '(' || c == ')' || c == '<' || c == '>' || c == '[' || c == ']' || c == '{' || c == '}' || c == '/' || c == '%'
+}
+
+// decodeText decodes a PDF text string, either UTF-16BE with a byte order
+// mark or PDFDocEncoding, which matches Latin-1 for printable characters
+func decodeText(s string) string {
+	if len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff {
+		units := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+	return string(runes)
+}
@@ -0,0 +1,517 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"cbz2epub/cbz"
+	"cbz2epub/util"
+)
+
+// ConvertFromCBZ converts a CBZ file to PDF format. Each page has the native
+// size of its image, one pixel per point.
+func ConvertFromCBZ(cbzFile *cbz.File, outputFile string) error {
+	return ConvertContext(context.Background(), cbzFile, outputFile, nil)
+}
+
+// ConvertContext converts a CBZ file to PDF format like ConvertFromCBZ,
+// reporting each page written to progress. The conversion stops between
+// pages when the context is done, and the output file is left untouched.
+func ConvertContext(ctx context.Context, cbzFile *cbz.File, outputFile string, progress cbz.ProgressFunc) error {
+	if len(cbzFile.Images) == 0 {
+		return fmt.Errorf("no images to convert in %s", cbzFile.Name)
+	}
+
+	// Write to a temporary file that replaces the output file when complete
+	file, err := util.CreateAtomic(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer file.Abort()
+
+	if err := WriteContext(ctx, file, cbzFile, progress); err != nil {
+		return err
+	}
+	return file.Commit()
+}
+
+// Write writes a book as a PDF file to out
+func Write(out io.Writer, cbzFile *cbz.File) error {
+	return WriteContext(context.Background(), out, cbzFile, nil)
+}
+
+// WriteContext writes a book as a PDF file to out like Write, reporting
+// each page written to progress. Writing stops between pages when the
+// context is done.
+func WriteContext(ctx context.Context, out io.Writer, cbzFile *cbz.File, progress cbz.ProgressFunc) error {
+	if len(cbzFile.Images) == 0 {
+		return fmt.Errorf("no images to convert")
+	}
+
+	// Check every page before anything is written, so a book fails as a
+	// whole instead of partway through
+	for _, img := range cbzFile.Images {
+		if err := checkImage(img); err != nil {
+			return err
+		}
+	}
+
+	w := newWriter(out)
+	w.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	// Reserve the object numbers that are referenced before they are written
+	catalogID := w.allocate()
+	pagesID := w.allocate()
+	infoID := w.allocate()
+	pageIDs := make([]int, len(cbzFile.Images))
+	for i := range pageIDs {
+		pageIDs[i] = w.allocate()
+	}
+
+	// Write each page with its image
+	for i, img := range cbzFile.Images {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := w.writePage(img, pageIDs[i], pagesID); err != nil {
+			return err
+		}
+		progress.Report(cbz.Progress{Stage: cbz.StageWrite, Pages: i + 1, TotalPages: len(cbzFile.Images), Bytes: w.offset})
+	}
+
+	// Write the page tree
+	w.startObject(pagesID)
+	w.printf("<< /Type /Pages /Count %d /Kids [", len(pageIDs))
+	for _, id := range pageIDs {
+		w.printf(" %d 0 R", id)
+	}
+	w.printf(" ] >>\n")
+	w.endObject()
+
+	// Write the outline built from the chapters
+	outlineID := w.writeOutline(cbzFile.Chapters(), pageIDs)
+
+	// Write the document info
+	w.startObject(infoID)
+	w.printf("<< /Title %s", textString(cbzFile.Title()))
+	if info := cbzFile.ComicInfo; info != nil {
+		if info.Writer != "" {
+			w.printf(" /Author %s", textString(info.Writer))
+		}
+		if info.Summary != "" {
+			w.printf(" /Subject %s", textString(info.Summary))
+		}
+		if info.Tags != "" {
+			w.printf(" /Keywords %s", textString(info.Tags))
+		}
+	}
+	w.printf(" /Creator %s /Producer %s /CreationDate %s >>\n",
+		textString("CBZ2EPUB Converter"), textString("cbz2epub"), textString(dateString(time.Now())))
+	w.endObject()
+
+	// Write the catalog
+	w.startObject(catalogID)
+	w.printf("<< /Type /Catalog /Pages %d 0 R", pagesID)
+	if outlineID != 0 {
+		w.printf(" /Outlines %d 0 R /PageMode /UseOutlines", outlineID)
+	}
+	w.printf(" >>\n")
+	w.endObject()
+
+	// Write the cross-reference table and trailer
+	xrefOffset := w.offset
+	w.printf("xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		w.printf("%010d 00000 n \n", offset)
+	}
+	w.printf("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(w.offsets)+1, catalogID, infoID, xrefOffset)
+
+	if w.err != nil {
+		return fmt.Errorf("failed to write PDF: %w", w.err)
+	}
+	if err := w.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write PDF: %w", err)
+	}
+	progress.Report(cbz.Progress{Stage: cbz.StageWrite, Pages: len(cbzFile.Images), TotalPages: len(cbzFile.Images), Bytes: w.offset})
+	return nil
+}
+
+// ConvertFile converts a CBZ file to PDF format
+func ConvertFile(inputFile, outputFile string) error {
+	// Read the CBZ file
+	cbzFile, err := cbz.ReadFile(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to read CBZ file: %w", err)
+	}
+
+	// Convert to PDF
+	err = ConvertFromCBZ(cbzFile, outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to convert to PDF: %w", err)
+	}
+
+	return nil
+}
+
+// writer writes PDF objects and keeps track of their offsets
+type writer struct {
+	buf     *bufio.Writer
+	offset  int64
+	offsets []int64 // Offset of each object, indexed by object number - 1
+	err     error
+}
+
+// newWriter creates a new PDF writer
+func newWriter(w io.Writer) *writer {
+	return &writer{buf: bufio.NewWriter(w)}
+}
+
+// write writes raw bytes, remembering the first error
+func (w *writer) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.buf.Write(data)
+	w.offset += int64(n)
+	w.err = err
+}
+
+// printf writes formatted text
+func (w *writer) printf(format string, args ...interface{}) {
+	w.write([]byte(fmt.Sprintf(format, args...)))
+}
+
+// allocate reserves a new object number
+func (w *writer) allocate() int {
+	w.offsets = append(w.offsets, 0)
+	return len(w.offsets)
+}
+
+// startObject starts writing an object
+func (w *writer) startObject(id int) {
+	w.offsets[id-1] = w.offset
+	w.printf("%d 0 obj\n", id)
+}
+
+// endObject finishes writing an object
+func (w *writer) endObject() {
+	w.printf("endobj\n")
+}
+
+// writeStream writes a stream object with the given dictionary entries
+func (w *writer) writeStream(id int, dict string, data []byte) {
+	w.startObject(id)
+	w.printf("<< %s /Length %d >>\nstream\n", dict, len(data))
+	w.write(data)
+	w.printf("\nendstream\n")
+	w.endObject()
+}
+
+// writePage writes a page object with its content stream and image
+func (w *writer) writePage(img cbz.Image, pageID, pagesID int) error {
+	imageID := w.allocate()
+	width, height, err := w.writeImage(img, imageID)
+	if err != nil {
+		return err
+	}
+
+	// Draw the image over the whole page
+	contentsID := w.allocate()
+	content := fmt.Sprintf("q %d 0 0 %d 0 0 cm /Im0 Do Q", width, height)
+	w.writeStream(contentsID, "", []byte(content))
+
+	w.startObject(pageID)
+	w.printf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>\n",
+		pagesID, width, height, imageID, contentsID)
+	w.endObject()
+
+	return nil
+}
+
+// checkImage checks that a page image can be embedded in a PDF file
+func checkImage(img cbz.Image) error {
+	if img.MimeType == "image/webp" {
+		return fmt.Errorf("page %s is a WebP image, which PDF output does not support", img.Name)
+	}
+	if _, _, err := img.DecodeConfig(); err != nil {
+		return fmt.Errorf("page %s cannot be converted to PDF: %w", img.Name, err)
+	}
+	return nil
+}
+
+// writeImage writes an image XObject. JPEG images and opaque 8-bit PNG
+// images are embedded as they are, other images are decoded and stored
+// Flate-compressed, with a soft mask for transparency.
+func (w *writer) writeImage(img cbz.Image, id int) (int, int, error) {
+	config, format, err := img.DecodeConfig()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if format == "jpeg" {
+		colorSpace := "/DeviceRGB"
+		switch config.ColorModel {
+		case color.GrayModel:
+			colorSpace = "/DeviceGray"
+		case color.CMYKModel:
+			// Adobe CMYK JPEGs are stored inverted
+			colorSpace = "/DeviceCMYK /Decode [1 0 1 0 1 0 1 0]"
+		}
+		w.writeStream(id, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode",
+			config.Width, config.Height, colorSpace), img.Data)
+		return config.Width, config.Height, nil
+	}
+
+	// PNG image data is a zlib stream with the predictors of FlateDecode
+	if format == "png" {
+		if png, ok := parsePNG(img.Data); ok {
+			w.writeStream(id, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent %d /Filter /FlateDecode /DecodeParms << /Predictor 15 /Colors %d /BitsPerComponent %d /Columns %d >>",
+				png.width, png.height, png.colorSpace, png.bits, png.colors, png.bits, png.width), png.data)
+			return png.width, png.height, nil
+		}
+	}
+
+	decoded, _, err := img.Decode()
+	if err != nil {
+		return 0, 0, err
+	}
+	pixels, colorSpace, alpha := imagePixels(decoded)
+
+	pixelData, err := deflate(pixels)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	bounds := decoded.Bounds()
+	dict := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /FlateDecode",
+		bounds.Dx(), bounds.Dy(), colorSpace)
+
+	// Write the transparency as a soft mask
+	if alpha != nil {
+		alphaData, err := deflate(alpha)
+		if err != nil {
+			return 0, 0, err
+		}
+		maskID := w.allocate()
+		w.writeStream(maskID, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode",
+			bounds.Dx(), bounds.Dy()), alphaData)
+		dict += fmt.Sprintf(" /SMask %d 0 R", maskID)
+	}
+
+	w.writeStream(id, dict, pixelData)
+	return bounds.Dx(), bounds.Dy(), nil
+}
+
+// writeOutline writes the outline of the chapters and returns its object
+// number, or 0 if there is nothing worth an outline
+func (w *writer) writeOutline(chapters []cbz.Chapter, pageIDs []int) int {
+	if len(chapters) < 2 {
+		return 0
+	}
+
+	outlineID := w.allocate()
+	itemIDs := make([]int, len(chapters))
+	for i := range itemIDs {
+		itemIDs[i] = w.allocate()
+	}
+
+	for i, chapter := range chapters {
+		title := chapter.Title
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+
+		w.startObject(itemIDs[i])
+		w.printf("<< /Title %s /Parent %d 0 R /Dest [%d 0 R /Fit]", textString(title), outlineID, pageIDs[chapter.Start])
+		if i > 0 {
+			w.printf(" /Prev %d 0 R", itemIDs[i-1])
+		}
+		if i+1 < len(itemIDs) {
+			w.printf(" /Next %d 0 R", itemIDs[i+1])
+		}
+		w.printf(" >>\n")
+		w.endObject()
+	}
+
+	w.startObject(outlineID)
+	w.printf("<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>\n",
+		itemIDs[0], itemIDs[len(itemIDs)-1], len(itemIDs))
+	w.endObject()
+
+	return outlineID
+}
+
+// pngImage is a PNG image that PDF readers can decode without conversion
+type pngImage struct {
+	width, height int
+	colorSpace    string
+	colors        int    // Samples per pixel
+	bits          int    // Bits per sample
+	data          []byte // Concatenated IDAT chunks
+}
+
+// parsePNG reads the chunks of a non-interlaced grayscale, RGB or palette
+// PNG image of up to 8 bits per sample without transparency. It returns false for other
+// images and for damaged files, which are decoded instead.
+func parsePNG(data []byte) (*pngImage, bool) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, false
+	}
+	data = data[len(signature):]
+
+	img := &pngImage{}
+	var colorType byte
+	var palette []byte
+	for done := false; !done; {
+		if len(data) < 12 {
+			return nil, false
+		}
+		length := binary.BigEndian.Uint32(data)
+		if uint64(length) > uint64(len(data)-12) {
+			return nil, false
+		}
+		kind, chunk := data[4:8], data[8:8+length]
+		if crc32.ChecksumIEEE(data[4:8+length]) != binary.BigEndian.Uint32(data[8+length:]) {
+			return nil, false
+		}
+		data = data[12+length:]
+
+		switch string(kind) {
+		case "IHDR":
+			// 16-bit samples and interlaced images are re-encoded
+			if len(chunk) != 13 || chunk[8] > 8 || chunk[12] != 0 {
+				return nil, false
+			}
+			img.bits = int(chunk[8])
+			img.width = int(binary.BigEndian.Uint32(chunk[0:4]))
+			img.height = int(binary.BigEndian.Uint32(chunk[4:8]))
+			colorType = chunk[9]
+		case "PLTE":
+			palette = chunk
+		case "tRNS":
+			return nil, false
+		case "IDAT":
+			img.data = append(img.data, chunk...)
+		case "IEND":
+			done = true
+		}
+	}
+
+	switch colorType {
+	case 0:
+		img.colorSpace, img.colors = "/DeviceGray", 1
+	case 2:
+		img.colorSpace, img.colors = "/DeviceRGB", 3
+	case 3:
+		if len(palette) == 0 || len(palette)%3 != 0 {
+			return nil, false
+		}
+		img.colorSpace, img.colors = fmt.Sprintf("[/Indexed /DeviceRGB %d <%X>]", len(palette)/3-1, palette), 1
+	default:
+		return nil, false
+	}
+	if img.width == 0 || img.height == 0 || len(img.data) == 0 {
+		return nil, false
+	}
+	return img, true
+}
+
+// imagePixels returns the 8-bit samples of an image, its PDF color space and
+// the alpha channel if the image is not fully opaque
+func imagePixels(img image.Image) ([]byte, string, []byte) {
+	bounds := img.Bounds()
+
+	// Keep grayscale images in one channel
+	if gray, ok := img.(*image.Gray); ok {
+		pixels := make([]byte, 0, bounds.Dx()*bounds.Dy())
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			pixels = append(pixels, gray.Pix[gray.PixOffset(bounds.Min.X, y):gray.PixOffset(bounds.Max.X, y)]...)
+		}
+		return pixels, "/DeviceGray", nil
+	}
+
+	pixels := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	alpha := make([]byte, 0, bounds.Dx()*bounds.Dy())
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			pixels = append(pixels, c.R, c.G, c.B)
+			alpha = append(alpha, c.A)
+			if c.A != 0xff {
+				opaque = false
+			}
+		}
+	}
+
+	if opaque {
+		return pixels, "/DeviceRGB", nil
+	}
+	return pixels, "/DeviceRGB", alpha
+}
+
+// deflate compresses data for the FlateDecode filter
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress image data: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress image data: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// textString encodes a PDF text string. ASCII text is written as a literal
+// string, anything else as UTF-16BE with a byte order mark.
+func textString(s string) string {
+	ascii := true
+	for _, r := range s {
+		if r > 0x7e || (r < 0x20 && r != '\n' && r != '\t') {
+			ascii = false
+			break
+		}
+	}
+
+	if ascii {
+		replacer := strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`, "\n", `\n`, "\t", `\t`)
+		return "(" + replacer.Replace(s) + ")"
+	}
+
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, unit := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", unit)
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// dateString formats a time as a PDF date
+func dateString(t time.Time) string {
+	date := t.Format("D:20060102150405")
+	_, offset := t.Zone()
+	if offset == 0 {
+		return date + "Z"
+	}
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%s%02d'%02d'", date, sign, offset/3600, (offset%3600)/60)
+}
@@ -0,0 +1,261 @@
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cbz2epub/cbz"
+)
+
+// encodeTestImage creates an encoded test image of the given format
+func encodeTestImage(t *testing.T, format string, img image.Image) []byte {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "png":
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestConvertFromCBZ tests the ConvertFromCBZ function
+func TestConvertFromCBZ(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "pdf_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create test images in different formats
+	rgba := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	rgba.Set(1, 1, color.NRGBA{R: 255, A: 128})
+	gray := image.NewGray(image.Rect(0, 0, 5, 5))
+
+	cbzFile := &cbz.File{
+		Name: filepath.Join(tempDir, "test.cbz"),
+		Images: []cbz.Image{
+			{Name: "001.jpg", Path: "ch1/001.jpg", Data: encodeTestImage(t, "jpeg", image.NewRGBA(image.Rect(0, 0, 20, 30))), MimeType: "image/jpeg"},
+			{Name: "002.png", Path: "ch1/002.png", Data: encodeTestImage(t, "png", rgba), MimeType: "image/png"},
+			{Name: "003.png", Path: "ch2/003.png", Data: encodeTestImage(t, "png", gray), MimeType: "image/png"},
+		},
+		ComicInfo: &cbz.ComicInfo{Title: "Test (Comic)", Writer: "Jöhn Doe"},
+	}
+
+	// Convert the CBZ to PDF
+	pdfPath := filepath.Join(tempDir, "test.pdf")
+	if err := ConvertFromCBZ(cbzFile, pdfPath); err != nil {
+		t.Fatalf("ConvertFromCBZ failed: %v", err)
+	}
+
+	data, err := os.ReadFile(pdfPath)
+	if err != nil {
+		t.Fatalf("Failed to read PDF: %v", err)
+	}
+	content := string(data)
+
+	// Check the structure of the PDF
+	expected := []string{
+		"%PDF-1.4",
+		"/Type /Pages /Count 3",
+		"/MediaBox [0 0 20 30]",
+		"/MediaBox [0 0 4 3]",
+		"/Filter /DCTDecode",
+		"/Filter /FlateDecode",
+		"/SMask",
+		"/ColorSpace /DeviceGray",
+		"/Title (Test \\(Comic\\))",
+		"/Author <FEFF004A00F60068006E00200044006F0065>",
+		"/Type /Outlines",
+		"/Title (ch2)",
+		"%%EOF",
+	}
+	for _, s := range expected {
+		if !strings.Contains(content, s) {
+			t.Errorf("PDF does not contain %q", s)
+		}
+	}
+
+	// Check that every cross-reference entry points to its object
+	xref := content[strings.LastIndex(content, "\nxref\n"):]
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllStringSubmatch(xref, -1)
+	if len(entries) == 0 {
+		t.Fatalf("No cross-reference entries found")
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(entry[1])
+		prefix := fmt.Sprintf("%d 0 obj", i+1)
+		if !strings.HasPrefix(content[offset:], prefix) {
+			t.Errorf("Cross-reference entry %d does not point to its object", i+1)
+		}
+	}
+
+	// Test with no images
+	if err := ConvertFromCBZ(&cbz.File{Name: "empty.cbz"}, filepath.Join(tempDir, "empty.pdf")); err == nil {
+		t.Errorf("ConvertFromCBZ should fail without images")
+	}
+
+	// Test with an image that can't be decoded
+	broken := &cbz.File{Name: "broken.cbz", Images: []cbz.Image{{Name: "001.jpg", Data: []byte("not an image")}}}
+	if err := ConvertFromCBZ(broken, filepath.Join(tempDir, "broken.pdf")); err == nil {
+		t.Errorf("ConvertFromCBZ should fail with an invalid image")
+	}
+}
+
+// TestWritePNG tests that opaque PNG pages are embedded without decoding
+func TestWritePNG(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "pdf_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	gray := image.NewGray(image.Rect(0, 0, 3, 2))
+	gray.Set(1, 0, color.Gray{Y: 200})
+	rgb := image.NewRGBA(image.Rect(0, 0, 2, 3))
+	rgb.Set(0, 2, color.RGBA{R: 10, G: 20, B: 30, A: 255})
+	for i := 3; i < len(rgb.Pix); i += 4 {
+		rgb.Pix[i] = 255
+	}
+	paletted := image.NewPaletted(image.Rect(0, 0, 4, 1), color.Palette{color.RGBA{A: 255}, color.RGBA{R: 255, A: 255}})
+	paletted.SetColorIndex(2, 0, 1)
+
+	pages := []image.Image{gray, rgb, paletted}
+	book := &cbz.File{Name: "test.cbz"}
+	for i, page := range pages {
+		book.Images = append(book.Images, cbz.Image{Name: fmt.Sprintf("%03d.png", i+1), Data: encodeTestImage(t, "png", page), MimeType: "image/png"})
+	}
+
+	pdfPath := filepath.Join(tempDir, "test.pdf")
+	if err := ConvertFromCBZ(book, pdfPath); err != nil {
+		t.Fatalf("ConvertFromCBZ failed: %v", err)
+	}
+	data, err := os.ReadFile(pdfPath)
+	if err != nil {
+		t.Fatalf("Failed to read PDF: %v", err)
+	}
+	if count := strings.Count(string(data), "/Predictor 15"); count != len(pages) {
+		t.Errorf("Expected %d pages embedded as PNG data, got %d", len(pages), count)
+	}
+
+	// The pages read back have the same pixels
+	readBook, err := ReadFile(pdfPath)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	for i, page := range pages {
+		decoded, _, err := readBook.Images[i].Decode()
+		if err != nil {
+			t.Fatalf("Failed to decode page %d: %v", i+1, err)
+		}
+		bounds := page.Bounds()
+		if decoded.Bounds() != bounds {
+			t.Fatalf("Expected page %d to have bounds %v, got %v", i+1, bounds, decoded.Bounds())
+		}
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r1, g1, b1, _ := page.At(x, y).RGBA()
+				r2, g2, b2, _ := decoded.At(x, y).RGBA()
+				if r1 != r2 || g1 != g2 || b1 != b2 {
+					t.Errorf("Page %d differs at %d,%d", i+1, x, y)
+				}
+			}
+		}
+	}
+}
+
+// TestWriteWebP tests that WebP pages are rejected before anything is written
+func TestWriteWebP(t *testing.T) {
+	page := encodeTestImage(t, "png", image.NewGray(image.Rect(0, 0, 2, 2)))
+	book := &cbz.File{Images: []cbz.Image{
+		{Name: "001.png", Data: page, MimeType: "image/png"},
+		{Name: "002.webp", Data: []byte("RIFF....WEBPVP8 "), MimeType: "image/webp"},
+	}}
+
+	var buf bytes.Buffer
+	err := Write(&buf, book)
+	if err == nil || !strings.Contains(err.Error(), "002.webp is a WebP image") {
+		t.Errorf("Expected an error naming the WebP page, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing written, got %d bytes", buf.Len())
+	}
+}
+
+// TestTextString tests the textString function
+func TestTextString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Title", "(Title)"},
+		{`a (b) \c`, `(a \(b\) \\c)`},
+		{"é", "<FEFF00E9>"},
+		{"", "()"},
+	}
+
+	for _, test := range tests {
+		result := textString(test.input)
+		if result != test.expected {
+			t.Errorf("textString(%q) = %s, expected %s", test.input, result, test.expected)
+		}
+	}
+}
+
+// TestDateString tests the dateString function
+func TestDateString(t *testing.T) {
+	date := time.Date(2024, 3, 5, 14, 30, 0, 0, time.FixedZone("", -(5*3600+30*60)))
+	if result := dateString(date); result != "D:20240305143000-05'30'" {
+		t.Errorf("Unexpected PDF date: %s", result)
+	}
+
+	if result := dateString(date.UTC()); result != "D:20240305200000Z" {
+		t.Errorf("Unexpected PDF date: %s", result)
+	}
+}
+
+// TestWriteContext tests the progress events and cancellation of writing a PDF file
+func TestWriteContext(t *testing.T) {
+	page := encodeTestImage(t, "png", image.NewGray(image.Rect(0, 0, 2, 2)))
+	book := &cbz.File{Images: []cbz.Image{
+		{Name: "001.png", Data: page, MimeType: "image/png"},
+		{Name: "002.png", Data: page, MimeType: "image/png"},
+	}}
+
+	var events []cbz.Progress
+	var buf bytes.Buffer
+	if err := WriteContext(context.Background(), &buf, book, func(p cbz.Progress) { events = append(events, p) }); err != nil {
+		t.Fatalf("WriteContext failed: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %+v", events)
+	}
+	if last := events[2]; last.Stage != cbz.StageWrite || last.Pages != 2 || last.Bytes != int64(buf.Len()) {
+		t.Errorf("Unexpected last event: %+v", last)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := WriteContext(ctx, io.Discard, book, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,590 @@
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strings"
+
+	"cbz2epub/cbz"
+)
+
+// PageError reports the pages of a PDF file that could not be converted
+// because they don't consist of a single supported image
+type PageError struct {
+	Pages   []int    // Page numbers, starting at 1
+	Reasons []string // Reason for each page
+}
+
+// Error implements the error interface
+func (e *PageError) Error() string {
+	var parts []string
+	for i, page := range e.Pages {
+		parts = append(parts, fmt.Sprintf("page %d: %s", page, e.Reasons[i]))
+	}
+	return fmt.Sprintf("%d pages skipped (%s)", len(e.Pages), strings.Join(parts, "; "))
+}
+
+// add records a skipped page
+func (e *PageError) add(page int, reason string) {
+	e.Pages = append(e.Pages, page)
+	e.Reasons = append(e.Reasons, reason)
+}
+
+// ReadFile reads an image-based PDF file, extracting the single image of
+// each page. Pages without exactly one supported image are skipped and
+// reported with a *PageError, which is returned together with the pages
+// that could be read.
+func ReadFile(filename string) (*cbz.File, error) {
+	return ReadFileContext(context.Background(), filename, nil)
+}
+
+// ReadFileContext reads an image-based PDF file like ReadFile, reporting
+// each page read to progress. Reading stops between pages when the context
+// is done.
+func ReadFileContext(ctx context.Context, filename string, progress cbz.ProgressFunc) (*cbz.File, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF file: %w", err)
+	}
+
+	doc, err := parseDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PDF file: %w", err)
+	}
+
+	pages := doc.pages()
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("no pages found in %s", filename)
+	}
+
+	cbzFile := &cbz.File{
+		Name:      filename,
+		Images:    []cbz.Image{},
+		ComicInfo: doc.comicInfo(),
+	}
+
+	// Extract the image of each page
+	pageErr := &PageError{}
+	pageImages := make(map[int]int) // Image index by page index
+	for i, page := range pages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		progress.Report(cbz.Progress{Stage: cbz.StageRead, Pages: i, TotalPages: len(pages)})
+
+		images := doc.pageImages(page)
+		if len(images) != 1 {
+			pageErr.add(i+1, fmt.Sprintf("%d images", len(images)))
+			continue
+		}
+
+		imageData, ext, err := doc.extractImage(images[0])
+		if err != nil {
+			pageErr.add(i+1, err.Error())
+			continue
+		}
+
+		pageImages[i] = len(cbzFile.Images)
+		imageName := fmt.Sprintf("page%03d%s", i+1, ext)
+		cbzFile.Images = append(cbzFile.Images, cbz.Image{
+			Name:     imageName,
+			Path:     imageName,
+			Data:     imageData,
+			MimeType: mimeTypeForExt(ext),
+		})
+	}
+
+	progress.Report(cbz.Progress{Stage: cbz.StageRead, Pages: len(pages), TotalPages: len(pages)})
+
+	if len(cbzFile.Images) == 0 {
+		return nil, fmt.Errorf("no page images found in %s: %w", filename, pageErr)
+	}
+
+	// Use the top level outline entries as bookmarks
+	for _, bookmark := range doc.outline() {
+		if index, ok := pageImages[bookmark.Start]; ok && cbzFile.Images[index].Bookmark == "" {
+			cbzFile.Images[index].Bookmark = bookmark.Title
+		}
+	}
+
+	if len(pageErr.Pages) > 0 {
+		return cbzFile, pageErr
+	}
+	return cbzFile, nil
+}
+
+// pages returns the page dictionaries in order, with inherited resources
+// copied into each page
+func (d *document) pages() []dict {
+	root := d.resolveDict(d.trailer["Root"])
+	var pages []dict
+	visited := make(map[ref]bool)
+
+	var walk func(obj interface{}, resources interface{}, depth int)
+	walk = func(obj interface{}, resources interface{}, depth int) {
+		if r, ok := obj.(ref); ok {
+			if visited[r] {
+				return
+			}
+			visited[r] = true
+		}
+		node := d.resolveDict(obj)
+		if node == nil || depth > 64 {
+			return
+		}
+		if r, ok := node["Resources"]; ok {
+			resources = r
+		}
+
+		kids, ok := d.resolve(node["Kids"]).(array)
+		if !ok {
+			page := dict{}
+			for k, v := range node {
+				page[k] = v
+			}
+			page["Resources"] = resources
+			pages = append(pages, page)
+			return
+		}
+		for _, kid := range kids {
+			walk(kid, resources, depth+1)
+		}
+	}
+	walk(root["Pages"], nil, 0)
+
+	return pages
+}
+
+// pageImages returns the image XObjects drawn by a page. Form XObjects are
+// searched for images too.
+func (d *document) pageImages(page dict) []*stream {
+	content := d.contents(page["Contents"])
+	return d.drawnImages(content, d.resolveDict(page["Resources"]), 0)
+}
+
+// drawnImages returns the image XObjects drawn by a content stream
+func (d *document) drawnImages(content []byte, resources dict, depth int) []*stream {
+	xobjects := d.resolveDict(resources["XObject"])
+	if xobjects == nil || depth > 8 {
+		return nil
+	}
+
+	var images []*stream
+	seen := make(map[*stream]bool)
+	for _, xobjectName := range drawnXObjects(content) {
+		s, ok := d.resolve(xobjects[xobjectName]).(*stream)
+		if !ok || seen[s] {
+			continue
+		}
+		seen[s] = true
+
+		switch s.dict["Subtype"] {
+		case name("Image"):
+			images = append(images, s)
+		case name("Form"):
+			formContent, _, err := d.decodeStream(s, false)
+			if err != nil {
+				continue
+			}
+			formResources := d.resolveDict(s.dict["Resources"])
+			if formResources == nil {
+				formResources = resources
+			}
+			images = append(images, d.drawnImages(formContent, formResources, depth+1)...)
+		}
+	}
+
+	return images
+}
+
+// contents returns the decoded content streams of a page
+func (d *document) contents(obj interface{}) []byte {
+	var streams []interface{}
+	switch obj := d.resolve(obj).(type) {
+	case *stream:
+		streams = []interface{}{obj}
+	case array:
+		streams = obj
+	}
+
+	var content []byte
+	for _, s := range streams {
+		if s, ok := d.resolve(s).(*stream); ok {
+			if data, _, err := d.decodeStream(s, false); err == nil {
+				content = append(content, data...)
+				content = append(content, '\n')
+			}
+		}
+	}
+	return content
+}
+
+// drawnXObjects returns the names used with the Do operator in a content stream
+func drawnXObjects(content []byte) []name {
+	p := &parser{data: content}
+	var names []name
+	var last interface{}
+	for p.pos < len(content) {
+		obj := p.parseObject()
+		switch obj {
+		case keyword("Do"):
+			if n, ok := last.(name); ok {
+				names = append(names, n)
+			}
+		case keyword("ID"):
+			// Skip the data of inline images
+			end := bytes.Index(content[p.pos:], []byte("EI"))
+			for end >= 0 {
+				after := p.pos + end + 2
+				if after >= len(content) || isSpace(content[after]) {
+					break
+				}
+				next := bytes.Index(content[after:], []byte("EI"))
+				if next < 0 {
+					end = -1
+					break
+				}
+				end = after - p.pos + next
+			}
+			if end < 0 {
+				return names
+			}
+			p.pos += end + 2
+		}
+		last = obj
+	}
+	return names
+}
+
+// extractImage returns an image XObject as JPEG or PNG data together with
+// the file extension
+func (d *document) extractImage(s *stream) ([]byte, string, error) {
+	data, filters, err := d.decodeStream(s, true)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// JPEG images are kept as they are
+	if len(filters) > 0 {
+		if len(filters) == 1 && (filters[0] == "DCTDecode" || filters[0] == "DCT") {
+			return data, ".jpg", nil
+		}
+		return nil, "", fmt.Errorf("unsupported image filter %s", filters[0])
+	}
+
+	img, err := d.decodeSamples(s.dict, data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, "", fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), ".png", nil
+}
+
+// decodeSamples builds an image from the raw samples of an image XObject
+func (d *document) decodeSamples(params dict, data []byte) (image.Image, error) {
+	width := intParam(params, "Width", 0)
+	height := intParam(params, "Height", 0)
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+
+	imageMask, _ := params["ImageMask"].(bool)
+	bpc := intParam(params, "BitsPerComponent", 8)
+	if imageMask {
+		bpc = 1
+	}
+	if bpc != 1 && bpc != 2 && bpc != 4 && bpc != 8 && bpc != 16 {
+		return nil, fmt.Errorf("unsupported bits per component %d", bpc)
+	}
+
+	// Determine the color space
+	components := 1
+	var palette color.Palette
+	colorSpace := d.resolve(params["ColorSpace"])
+	if imageMask {
+		colorSpace = name("DeviceGray")
+	}
+	if arr, ok := colorSpace.(array); ok && len(arr) > 0 {
+		family, _ := d.resolve(arr[0]).(name)
+		switch family {
+		case "ICCBased":
+			if len(arr) > 1 {
+				components = intParam(d.resolveDict(arr[1]), "N", 3)
+			}
+		case "Indexed", "I":
+			var err error
+			palette, err = d.indexedPalette(arr)
+			if err != nil {
+				return nil, err
+			}
+		case "CalRGB":
+			components = 3
+		case "CalGray":
+			components = 1
+		default:
+			return nil, fmt.Errorf("unsupported color space %s", family)
+		}
+	} else {
+		switch colorSpace {
+		case name("DeviceGray"), name("G"):
+			components = 1
+		case name("DeviceRGB"), name("RGB"):
+			components = 3
+		case name("DeviceCMYK"), name("CMYK"):
+			components = 4
+		default:
+			return nil, fmt.Errorf("unsupported color space %v", colorSpace)
+		}
+	}
+
+	// Check that there is enough data
+	rowLen := (width*components*bpc + 7) / 8
+	if len(data) < rowLen*height {
+		return nil, fmt.Errorf("image data too short")
+	}
+
+	// Read a sample scaled to 8 bits, or the raw value for indexed images
+	invert := false
+	if decode, ok := d.resolve(params["Decode"]).(array); ok && len(decode) >= 2 && palette == nil {
+		first, _ := decode[0].(int64)
+		invert = first == 1
+	}
+	sample := func(row []byte, i int) uint8 {
+		var value, max int
+		switch bpc {
+		case 16:
+			value, max = int(row[i*2]), 255
+		case 8:
+			value, max = int(row[i]), 255
+		default:
+			bit := i * bpc
+			value = int(row[bit/8]>>(8-bpc-bit%8)) & (1<<bpc - 1)
+			max = 1<<bpc - 1
+		}
+		if palette != nil {
+			return uint8(value)
+		}
+		if invert {
+			value = max - value
+		}
+		return uint8(value * 255 / max)
+	}
+
+	bounds := image.Rect(0, 0, width, height)
+	switch {
+	case palette != nil:
+		img := image.NewPaletted(bounds, palette)
+		for y := 0; y < height; y++ {
+			row := data[y*rowLen:]
+			for x := 0; x < width; x++ {
+				img.Pix[y*img.Stride+x] = sample(row, x)
+			}
+		}
+		return img, nil
+	case components == 1:
+		img := image.NewGray(bounds)
+		for y := 0; y < height; y++ {
+			row := data[y*rowLen:]
+			for x := 0; x < width; x++ {
+				img.Pix[y*img.Stride+x] = sample(row, x)
+			}
+		}
+		return img, nil
+	case components == 3:
+		img := image.NewNRGBA(bounds)
+		for y := 0; y < height; y++ {
+			row := data[y*rowLen:]
+			for x := 0; x < width; x++ {
+				i := y*img.Stride + x*4
+				img.Pix[i] = sample(row, x*3)
+				img.Pix[i+1] = sample(row, x*3+1)
+				img.Pix[i+2] = sample(row, x*3+2)
+				img.Pix[i+3] = 0xff
+			}
+		}
+		return img, nil
+	case components == 4:
+		img := image.NewCMYK(bounds)
+		for y := 0; y < height; y++ {
+			row := data[y*rowLen:]
+			for x := 0; x < width; x++ {
+				for c := 0; c < 4; c++ {
+					img.Pix[y*img.Stride+x*4+c] = sample(row, x*4+c)
+				}
+			}
+		}
+		return img, nil
+	}
+
+	return nil, fmt.Errorf("unsupported number of color components %d", components)
+}
+
+// indexedPalette builds the palette of an Indexed color space
+// [/Indexed base hival lookup]
+func (d *document) indexedPalette(colorSpace array) (color.Palette, error) {
+	if len(colorSpace) < 4 {
+		return nil, fmt.Errorf("invalid indexed color space")
+	}
+
+	components := 0
+	switch base := d.resolve(colorSpace[1]).(type) {
+	case name:
+		switch base {
+		case "DeviceGray", "G", "CalGray":
+			components = 1
+		case "DeviceRGB", "RGB", "CalRGB":
+			components = 3
+		}
+	case array:
+		if len(base) > 1 && d.resolve(base[0]) == name("ICCBased") {
+			components = intParam(d.resolveDict(base[1]), "N", 3)
+		}
+	}
+	if components != 1 && components != 3 {
+		return nil, fmt.Errorf("unsupported indexed base color space")
+	}
+
+	hival, _ := d.resolve(colorSpace[2]).(int64)
+	var lookup []byte
+	switch l := d.resolve(colorSpace[3]).(type) {
+	case string:
+		lookup = []byte(l)
+	case *stream:
+		data, _, err := d.decodeStream(l, false)
+		if err != nil {
+			return nil, err
+		}
+		lookup = data
+	}
+
+	palette := make(color.Palette, 0, hival+1)
+	for i := 0; i <= int(hival) && (i+1)*components <= len(lookup); i++ {
+		entry := lookup[i*components:]
+		if components == 1 {
+			palette = append(palette, color.Gray{Y: entry[0]})
+		} else {
+			palette = append(palette, color.RGBA{R: entry[0], G: entry[1], B: entry[2], A: 0xff})
+		}
+	}
+	// Fill missing entries so every index maps to a color
+	for len(palette) < 256 {
+		palette = append(palette, color.Black)
+	}
+
+	return palette, nil
+}
+
+// outline returns the top level outline entries that point to a page
+func (d *document) outline() []cbz.Chapter {
+	root := d.resolveDict(d.trailer["Root"])
+	outlines := d.resolveDict(root["Outlines"])
+	if outlines == nil {
+		return nil
+	}
+
+	// Find pages by their reference
+	pageIndex := make(map[ref]int)
+	d.indexPages(root["Pages"], pageIndex, make(map[ref]bool), 0)
+
+	var chapters []cbz.Chapter
+	visited := make(map[ref]bool)
+	item := outlines["First"]
+	for i := 0; item != nil && i < 10000; i++ {
+		r, _ := item.(ref)
+		if visited[r] {
+			break
+		}
+		visited[r] = true
+
+		entry := d.resolveDict(item)
+		if entry == nil {
+			break
+		}
+
+		dest := d.resolve(entry["Dest"])
+		if dest == nil {
+			if action := d.resolveDict(entry["A"]); action != nil && action["S"] == name("GoTo") {
+				dest = d.resolve(action["D"])
+			}
+		}
+		if arr, ok := dest.(array); ok && len(arr) > 0 {
+			if pageRef, ok := arr[0].(ref); ok {
+				if index, ok := pageIndex[pageRef]; ok {
+					title, _ := d.resolve(entry["Title"]).(string)
+					chapters = append(chapters, cbz.Chapter{Title: decodeText(title), Start: index})
+				}
+			}
+		}
+
+		item = entry["Next"]
+	}
+
+	return chapters
+}
+
+// indexPages maps page references to page indexes, in the same order as pages
+func (d *document) indexPages(obj interface{}, index map[ref]int, visited map[ref]bool, depth int) {
+	r, isRef := obj.(ref)
+	if isRef {
+		if visited[r] {
+			return
+		}
+		visited[r] = true
+	}
+	node := d.resolveDict(obj)
+	if node == nil || depth > 64 {
+		return
+	}
+
+	kids, ok := d.resolve(node["Kids"]).(array)
+	if !ok {
+		if isRef {
+			index[r] = len(index)
+		}
+		return
+	}
+	for _, kid := range kids {
+		d.indexPages(kid, index, visited, depth+1)
+	}
+}
+
+// comicInfo builds ComicInfo metadata from the document info dictionary
+func (d *document) comicInfo() *cbz.ComicInfo {
+	info := d.resolveDict(d.trailer["Info"])
+	if info == nil {
+		return nil
+	}
+
+	text := func(key name) string {
+		s, _ := d.resolve(info[key]).(string)
+		return strings.TrimSpace(decodeText(s))
+	}
+
+	comicInfo := &cbz.ComicInfo{
+		Title:   text("Title"),
+		Writer:  text("Author"),
+		Summary: text("Subject"),
+		Tags:    text("Keywords"),
+	}
+	if comicInfo.Title == "" && comicInfo.Writer == "" && comicInfo.Summary == "" && comicInfo.Tags == "" {
+		return nil
+	}
+	return comicInfo
+}
+
+// mimeTypeForExt returns the MIME type for an extracted image
+func mimeTypeForExt(ext string) string {
+	if ext == ".jpg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
@@ -0,0 +1,251 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cbz2epub/cbz"
+)
+
+// buildTestPDF builds a PDF file from numbered objects, with either a
+// classic cross-reference table or a cross-reference stream
+func buildTestPDF(objects []string, root int, xrefStream bool) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.5\n")
+
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xrefOffset := buf.Len()
+	if !xrefStream {
+		fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+		for _, offset := range offsets {
+			fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+		}
+		fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root %d 0 R >>\n", len(objects)+1, root)
+	} else {
+		// Entries with 1 byte type, 4 bytes offset and 1 byte generation
+		var entries bytes.Buffer
+		entries.Write([]byte{0, 0, 0, 0, 0, 0xff})
+		for _, offset := range append(offsets, xrefOffset) {
+			entries.Write([]byte{1, byte(offset >> 24), byte(offset >> 16), byte(offset >> 8), byte(offset), 0})
+		}
+		data, _ := deflate(entries.Bytes())
+		fmt.Fprintf(&buf, "%d 0 obj\n<< /Type /XRef /Size %d /W [1 4 1] /Root %d 0 R /Filter /FlateDecode /Length %d >>\nstream\n",
+			len(objects)+1, len(objects)+2, root, len(data))
+		buf.Write(data)
+		buf.WriteString("\nendstream\nendobj\n")
+	}
+	fmt.Fprintf(&buf, "startxref\n%d\n%%%%EOF\n", xrefOffset)
+
+	return buf.Bytes()
+}
+
+// TestReadFileRoundTrip tests reading a PDF written by ConvertFromCBZ
+func TestReadFileRoundTrip(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "pdf_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create test images in different formats
+	rgb := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	rgb.Set(2, 1, color.NRGBA{R: 10, G: 20, B: 30, A: 255})
+	gray := image.NewGray(image.Rect(0, 0, 2, 2))
+	gray.Set(1, 0, color.Gray{Y: 200})
+	jpegData := encodeTestImage(t, "jpeg", image.NewRGBA(image.Rect(0, 0, 8, 8)))
+
+	cbzFile := &cbz.File{
+		Name: filepath.Join(tempDir, "test.cbz"),
+		Images: []cbz.Image{
+			{Name: "001.jpg", Path: "Chapter One/001.jpg", Data: jpegData, MimeType: "image/jpeg"},
+			{Name: "002.png", Path: "Chapter One/002.png", Data: encodeTestImage(t, "png", rgb), MimeType: "image/png"},
+			{Name: "003.png", Path: "Chapter Two/003.png", Data: encodeTestImage(t, "png", gray), MimeType: "image/png"},
+		},
+		ComicInfo: &cbz.ComicInfo{Title: "Test Comic", Writer: "Jöhn Doe"},
+	}
+
+	pdfPath := filepath.Join(tempDir, "test.pdf")
+	if err := ConvertFromCBZ(cbzFile, pdfPath); err != nil {
+		t.Fatalf("ConvertFromCBZ failed: %v", err)
+	}
+
+	readFile, err := ReadFile(pdfPath)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+
+	if len(readFile.Images) != 3 {
+		t.Fatalf("Expected 3 images, got %d", len(readFile.Images))
+	}
+
+	// JPEG data is passed through unchanged
+	if !bytes.Equal(readFile.Images[0].Data, jpegData) || readFile.Images[0].MimeType != "image/jpeg" {
+		t.Errorf("JPEG image was not passed through")
+	}
+
+	// Flate images are converted to PNG with the same pixels
+	decoded, _, err := readFile.Images[1].Decode()
+	if err != nil {
+		t.Fatalf("Failed to decode extracted image: %v", err)
+	}
+	if r, g, b, _ := decoded.At(2, 1).RGBA(); r>>8 != 10 || g>>8 != 20 || b>>8 != 30 {
+		t.Errorf("Unexpected pixel color: %d %d %d", r>>8, g>>8, b>>8)
+	}
+	decoded, _, err = readFile.Images[2].Decode()
+	if err != nil {
+		t.Fatalf("Failed to decode extracted image: %v", err)
+	}
+	if c := color.GrayModel.Convert(decoded.At(1, 0)).(color.Gray); c.Y != 200 {
+		t.Errorf("Unexpected gray value: %d", c.Y)
+	}
+
+	// Check the metadata and the bookmarks from the outline
+	if readFile.ComicInfo == nil || readFile.ComicInfo.Title != "Test Comic" || readFile.ComicInfo.Writer != "Jöhn Doe" {
+		t.Errorf("Unexpected ComicInfo: %+v", readFile.ComicInfo)
+	}
+	if readFile.Images[0].Bookmark != "Chapter One" || readFile.Images[2].Bookmark != "Chapter Two" {
+		t.Errorf("Unexpected bookmarks: %q, %q", readFile.Images[0].Bookmark, readFile.Images[2].Bookmark)
+	}
+}
+
+// TestReadFilePageErrors tests that pages without a single image are reported
+func TestReadFilePageErrors(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "pdf_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A 1-bit image mask, stored uncompressed
+	mask := "<< /Type /XObject /Subtype /Image /Width 8 /Height 1 /ImageMask true /Length 1 >>\nstream\n\x0f\nendstream"
+
+	// An indexed image, stored Flate-compressed with the PNG Up predictor
+	var predicted bytes.Buffer
+	zw := zlib.NewWriter(&predicted)
+	zw.Write([]byte{0, 0, 1, 2, 1, 1, 0})
+	zw.Close()
+	indexed := fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width 3 /Height 2 /BitsPerComponent 8 /ColorSpace [/Indexed /DeviceRGB 2 <FF000000FF000000FF>] /Filter /FlateDecode /DecodeParms << /Predictor 12 /Columns 3 >> /Length %d >>\nstream\n%s\nendstream",
+		predicted.Len(), predicted.String())
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R 5 0 R 6 0 R] /Count 4 /Resources << /XObject << /A 7 0 R /B 8 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 9 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 10 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 11 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 12 0 R >>",
+		mask,
+		indexed,
+		"<< /Length 20 >>\nstream\nq 8 0 0 1 0 0 cm /A Do Q\nendstream",
+		"<< /Length 25 >>\nstream\n/A Do /B Do BT (text) Tj ET\nendstream",
+		"<< /Length 10 >>\nstream\nBT (x) Tj ET\nendstream",
+		"<< /Length 7 >>\nstream\n/B Do\nendstream",
+	}
+
+	brokenXref := bytes.Replace(buildTestPDF(objects, 1, false), []byte("startxref\n"), []byte("startxref\n9"), 1)
+	for _, data := range [][]byte{buildTestPDF(objects, 1, false), buildTestPDF(objects, 1, true), brokenXref} {
+		pdfPath := filepath.Join(tempDir, "test.pdf")
+		if err := os.WriteFile(pdfPath, data, 0644); err != nil {
+			t.Fatalf("Failed to write test PDF: %v", err)
+		}
+
+		cbzFile, err := ReadFile(pdfPath)
+		var pageErr *PageError
+		if !errors.As(err, &pageErr) {
+			t.Fatalf("Expected a PageError, got %v", err)
+		}
+		if len(pageErr.Pages) != 2 || pageErr.Pages[0] != 2 || pageErr.Pages[1] != 3 {
+			t.Errorf("Expected pages 2 and 3 to be reported, got %v", pageErr.Pages)
+		}
+
+		if cbzFile == nil || len(cbzFile.Images) != 2 {
+			t.Fatalf("Expected 2 images to be read")
+		}
+		if cbzFile.Images[0].Name != "page001.png" || cbzFile.Images[1].Name != "page004.png" {
+			t.Errorf("Unexpected image names: %s, %s", cbzFile.Images[0].Name, cbzFile.Images[1].Name)
+		}
+
+		// The image mask paints black where the sample is 0
+		decoded, _, err := cbzFile.Images[0].Decode()
+		if err != nil {
+			t.Fatalf("Failed to decode mask image: %v", err)
+		}
+		if c := color.GrayModel.Convert(decoded.At(0, 0)).(color.Gray); c.Y != 0 {
+			t.Errorf("Expected black pixel, got %d", c.Y)
+		}
+		if c := color.GrayModel.Convert(decoded.At(7, 0)).(color.Gray); c.Y != 255 {
+			t.Errorf("Expected white pixel, got %d", c.Y)
+		}
+
+		// The second row of the indexed image is the first row plus one
+		decoded, _, err = cbzFile.Images[1].Decode()
+		if err != nil {
+			t.Fatalf("Failed to decode indexed image: %v", err)
+		}
+		if r, g, b, _ := decoded.At(0, 1).RGBA(); r>>8 != 0 || g>>8 != 255 || b>>8 != 0 {
+			t.Errorf("Unexpected indexed pixel color: %d %d %d", r>>8, g>>8, b>>8)
+		}
+	}
+
+	// Test with a file that is not a PDF
+	notPDF := filepath.Join(tempDir, "not.pdf")
+	os.WriteFile(notPDF, []byte("hello"), 0644)
+	if _, err := ReadFile(notPDF); err == nil {
+		t.Errorf("ReadFile should fail with a file that is not a PDF")
+	}
+}
+
+// TestParseObject tests parsing of the basic PDF object types
+func TestParseObject(t *testing.T) {
+	p := &parser{data: []byte(`<< /Name#20A (lit\(e\)ral\n) /Hex <48 65 6C6C6F> /Arr [1 -2.5 3 0 R true null] >>`)}
+	obj, ok := p.parseObject().(dict)
+	if !ok {
+		t.Fatalf("Expected a dictionary")
+	}
+
+	if obj["Name A"] != "lit(e)ral\n" {
+		t.Errorf("Unexpected literal string: %q", obj["Name A"])
+	}
+	if obj["Hex"] != "Hello" {
+		t.Errorf("Unexpected hex string: %q", obj["Hex"])
+	}
+	arr, ok := obj["Arr"].(array)
+	if !ok || len(arr) != 5 {
+		t.Fatalf("Unexpected array: %v", obj["Arr"])
+	}
+	if arr[0] != int64(1) || arr[1] != -2.5 || arr[2] != (ref{num: 3}) || arr[3] != true || arr[4] != nil {
+		t.Errorf("Unexpected array values: %v", arr)
+	}
+}
+
+// TestUnpredict tests the PNG predictors
+func TestUnpredict(t *testing.T) {
+	params := dict{"Predictor": int64(15), "Columns": int64(2), "Colors": int64(1)}
+	data := []byte{
+		1, 5, 1, // Sub
+		2, 1, 1, // Up
+		3, 2, 2, // Average
+		4, 1, 0, // Paeth
+	}
+	expected := []byte{5, 6, 6, 7, 5, 8, 6, 8}
+
+	result, err := unpredict(data, params)
+	if err != nil {
+		t.Fatalf("unpredict failed: %v", err)
+	}
+	if !bytes.Equal(result, expected) {
+		t.Errorf("unpredict = %v, expected %v", result, expected)
+	}
+}
@@ -0,0 +1,175 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// tempFiles holds the temporary files of atomic files that are not yet
+// committed, so they can be removed when the process is interrupted
+var tempFiles = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: map[string]bool{}}
+
+// AtomicFile is an output file that is written to a temporary file in the
+// same directory and only renamed to its final name by Commit. Readers never
+// see a partly written file under the final name.
+type AtomicFile struct {
+	*os.File
+	name string
+	done bool
+}
+
+// CreateAtomic creates a temporary file for the output file name
+func CreateAtomic(name string) (*AtomicFile, error) {
+	file, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return nil, err
+	}
+	// Temporary files are private by default, outputs should not be
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	tempFiles.Lock()
+	tempFiles.names[file.Name()] = true
+	tempFiles.Unlock()
+
+	return &AtomicFile{File: file, name: name}, nil
+}
+
+// Commit flushes the temporary file to disk, closes it and renames it to the
+// output file name, replacing any existing file. The temporary file is
+// removed on error.
+func (f *AtomicFile) Commit() error {
+	if f.done {
+		return fmt.Errorf("%s is already closed", f.name)
+	}
+	f.done = true
+	defer f.forget()
+
+	// Without a sync, a crash after the rename can leave an empty file
+	if err := f.File.Sync(); err != nil {
+		f.File.Close()
+		os.Remove(f.File.Name())
+		return fmt.Errorf("failed to sync %s: %w", f.name, err)
+	}
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.File.Name())
+		return fmt.Errorf("failed to close %s: %w", f.name, err)
+	}
+	if err := os.Rename(f.File.Name(), f.name); err != nil {
+		os.Remove(f.File.Name())
+		return fmt.Errorf("failed to rename temporary file to %s: %w", f.name, err)
+	}
+	syncDir(filepath.Dir(f.name))
+	return nil
+}
+
+// syncDir flushes a directory to disk, so a rename in it survives a crash.
+// Some platforms, such as Windows, can't sync directories, so errors are
+// ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// Abort closes and removes the temporary file. It does nothing after Commit,
+// so it can be deferred right after CreateAtomic.
+func (f *AtomicFile) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.File.Close()
+	os.Remove(f.File.Name())
+	f.forget()
+}
+
+// forget stops tracking the temporary file
+func (f *AtomicFile) forget() {
+	tempFiles.Lock()
+	delete(tempFiles.names, f.File.Name())
+	tempFiles.Unlock()
+}
+
+// RemoveOnInterrupt removes a temporary file or directory along with the
+// temporary files of atomic files when the process is interrupted
+func RemoveOnInterrupt(name string) {
+	tempFiles.Lock()
+	tempFiles.names[name] = true
+	tempFiles.Unlock()
+}
+
+// RemoveTempFiles removes the temporary files of all atomic files that are
+// not yet committed, and the files registered with RemoveOnInterrupt
+func RemoveTempFiles() {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	for name := range tempFiles.names {
+		os.RemoveAll(name)
+		delete(tempFiles.names, name)
+	}
+}
+
+var cleanupOnce sync.Once
+
+// interrupt holds the cancel function of the context returned by
+// InterruptContext, which handles the next signal instead of exiting
+var interrupt struct {
+	sync.Mutex
+	cancel context.CancelFunc
+}
+
+// CleanupOnInterrupt removes the temporary files of atomic files when the
+// process is interrupted or terminated, then exits with status 130. While
+// a context of InterruptContext is active, a signal cancels it instead.
+func CleanupOnInterrupt() {
+	cleanupOnce.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			for range signals {
+				interrupt.Lock()
+				cancel := interrupt.cancel
+				interrupt.cancel = nil
+				interrupt.Unlock()
+				if cancel != nil {
+					cancel()
+					continue
+				}
+				RemoveTempFiles()
+				os.Exit(130)
+			}
+		}()
+	})
+}
+
+// InterruptContext returns a context that is canceled when the process is
+// interrupted or terminated, so the caller can shut down cleanly. A second
+// signal, or a signal after stop is called, exits as CleanupOnInterrupt does.
+func InterruptContext(parent context.Context) (ctx context.Context, stop context.CancelFunc) {
+	CleanupOnInterrupt()
+	ctx, cancel := context.WithCancel(parent)
+	interrupt.Lock()
+	interrupt.cancel = cancel
+	interrupt.Unlock()
+	return ctx, func() {
+		interrupt.Lock()
+		interrupt.cancel = nil
+		interrupt.Unlock()
+		cancel()
+	}
+}
@@ -0,0 +1,108 @@
+package util
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestAtomicFile tests committing and aborting atomic files
+func TestAtomicFile(t *testing.T) {
+	// Create a temporary directory for test files
+	tempDir, err := os.MkdirTemp("", "util_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputFile := filepath.Join(tempDir, "output.epub")
+	if err := os.WriteFile(outputFile, []byte("old content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// An aborted file leaves the existing output untouched
+	file, err := CreateAtomic(outputFile)
+	if err != nil {
+		t.Fatalf("CreateAtomic failed: %v", err)
+	}
+	if _, err := file.Write([]byte("partial")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	file.Abort()
+	if data, _ := os.ReadFile(outputFile); string(data) != "old content" {
+		t.Errorf("Aborted file changed the output file to %q", data)
+	}
+
+	// A committed file replaces the output
+	file, err = CreateAtomic(outputFile)
+	if err != nil {
+		t.Fatalf("CreateAtomic failed: %v", err)
+	}
+	if _, err := file.Write([]byte("new content")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if data, _ := os.ReadFile(outputFile); string(data) != "old content" {
+		t.Errorf("Output file changed before commit to %q", data)
+	}
+	if err := file.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	file.Abort() // Must not remove the committed file
+	if data, _ := os.ReadFile(outputFile); string(data) != "new content" {
+		t.Errorf("Expected committed content, got %q", data)
+	}
+	if info, err := os.Stat(outputFile); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("Expected output file with mode 0644, got %v", info.Mode())
+	}
+	if err := file.Commit(); err == nil {
+		t.Errorf("Commit should fail for a committed file")
+	}
+
+	// Uncommitted files and registered directories are removed on interrupt
+	file, err = CreateAtomic(filepath.Join(tempDir, "interrupted.cbz"))
+	if err != nil {
+		t.Fatalf("CreateAtomic failed: %v", err)
+	}
+	workDir := filepath.Join(tempDir, "work")
+	if err := os.MkdirAll(filepath.Join(workDir, "job"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	RemoveOnInterrupt(workDir)
+	RemoveTempFiles()
+	file.Close()
+
+	// Only the committed output remains
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "output.epub" {
+		names := []string{}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("Expected only output.epub, got %v", names)
+	}
+}
+
+// TestInterruptContext tests that a signal cancels the interrupt context
+func TestInterruptContext(t *testing.T) {
+	ctx, stop := InterruptContext(context.Background())
+	defer stop()
+
+	// The first signal cancels the context instead of exiting
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("Failed to find the test process: %v", err)
+	}
+	if err := process.Signal(os.Interrupt); err != nil {
+		t.Skipf("Signals are not supported: %v", err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the interrupt to cancel the context")
+	}
+}
@@ -2,10 +2,18 @@ package util
 
 import (
 	"crypto/rand"
+	"crypto/sha1"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// urlNamespace is the RFC 4122 namespace for name-based UUIDs of URLs
+var urlNamespace = []byte{0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+
 // GenerateUUID generates a proper UUID for the EPUB
 func GenerateUUID() string {
 	uuid := make([]byte, 16)
@@ -22,3 +30,88 @@ func GenerateUUID() string {
 	return fmt.Sprintf("%x-%x-%x-%x-%x",
 		uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
 }
+
+// NameUUID generates a name-based UUID (RFC 4122 version 5) in the URL
+// namespace. The same name always gives the same UUID.
+func NameUUID(name string) string {
+	hash := sha1.New()
+	hash.Write(urlNamespace)
+	hash.Write([]byte(name))
+	uuid := hash.Sum(nil)[:16]
+
+	// Set version (5) and variant (RFC 4122)
+	uuid[6] = (uuid[6] & 0x0f) | 0x50
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x",
+		uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
+
+// SourceDateEpoch returns the time set by the SOURCE_DATE_EPOCH environment
+// variable for reproducible builds, and false if it is not set or invalid
+func SourceDateEpoch() (time.Time, bool) {
+	value := os.Getenv("SOURCE_DATE_EPOCH")
+	if value == "" {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0).UTC(), true
+}
+
+// ISBNDigits returns an ISBN-10 or ISBN-13 without hyphens and spaces, and
+// false if the value is not an ISBN: its check digit must match, and an
+// ISBN-13 must start with the 978 or 979 prefix of books. Other EAN-13
+// codes are not ISBNs.
+func ISBNDigits(value string) (string, bool) {
+	digits := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(value)))
+	sum := 0
+	for i, r := range digits {
+		var digit int
+		switch {
+		case r >= '0' && r <= '9':
+			digit = int(r - '0')
+		case r == 'X' && i == 9 && len(digits) == 10:
+			digit = 10
+		default:
+			return "", false
+		}
+
+		// ISBN-10 digits are weighted 10 to 1, ISBN-13 digits 1 and 3
+		switch {
+		case len(digits) == 10:
+			sum += digit * (10 - i)
+		case i%2 == 0:
+			sum += digit
+		default:
+			sum += digit * 3
+		}
+	}
+
+	valid := false
+	switch len(digits) {
+	case 10:
+		valid = sum%11 == 0
+	case 13:
+		valid = sum%10 == 0 && (strings.HasPrefix(digits, "978") || strings.HasPrefix(digits, "979"))
+	}
+	if !valid {
+		return "", false
+	}
+	return digits, true
+}
+
+// CountingWriter counts the bytes written to a writer
+type CountingWriter struct {
+	W io.Writer
+	N int64
+}
+
+// Write writes to the underlying writer and counts the bytes written
+func (c *CountingWriter) Write(p []byte) (int, error) {
+	n, err := c.W.Write(p)
+	c.N += int64(n)
+	return n, err
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"regexp"
 	"testing"
+	"time"
 )
 
 func TestGenerateUUID(t *testing.T) {
@@ -27,3 +28,57 @@ func TestGenerateUUID(t *testing.T) {
 		t.Errorf("Generated UUIDs are not unique: %s == %s", uuid, uuid2)
 	}
 }
+
+func TestNameUUID(t *testing.T) {
+	// The same UUID as Python's uuid.uuid5(uuid.NAMESPACE_URL, "http://www.example.com/")
+	if uuid := NameUUID("http://www.example.com/"); uuid != "fcde3c85-2270-590f-9e7c-ee003d65e0e2" {
+		t.Errorf("Unexpected UUID %s", uuid)
+	}
+
+	// The same name gives the same UUID, other names other UUIDs
+	if NameUUID("book") != NameUUID("book") || NameUUID("book") == NameUUID("other book") {
+		t.Errorf("Name-based UUIDs are not stable")
+	}
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	if date, ok := SourceDateEpoch(); !ok || !date.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Unexpected source date %v, %t", date, ok)
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	if _, ok := SourceDateEpoch(); ok {
+		t.Errorf("Expected an invalid SOURCE_DATE_EPOCH to be ignored")
+	}
+}
+
+func TestISBNDigits(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected string
+		ok       bool
+	}{
+		{"978-1-60706-601-9", "9781607066019", true},
+		{" 080442957X ", "080442957X", true},
+		{"0 8044 2957 X", "080442957X", true},
+		{"979-10-90636-07-1", "9791090636071", true},
+		{"080442957x", "080442957X", true},
+		{"761941366357", "", false},
+		{"97816070660X9", "", false},
+		{"", "", false},
+
+		// Mistyped check digits
+		{"978-1-60706-601-8", "", false},
+		{"0804429571", "", false},
+
+		// EAN-13 codes of other products
+		{"4006381333931", "", false},
+		{"0012345678905", "", false},
+	}
+	for _, tc := range testCases {
+		if got, ok := ISBNDigits(tc.value); got != tc.expected || ok != tc.ok {
+			t.Errorf("ISBNDigits(%q) = %q, %t; expected %q, %t", tc.value, got, ok, tc.expected, tc.ok)
+		}
+	}
+}